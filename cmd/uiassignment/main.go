@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
 	"os"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/authenticator"
 	"uiassignment/internal/pkg/db"
+	"uiassignment/internal/pkg/events"
 	"uiassignment/internal/pkg/handlers"
 	"uiassignment/internal/pkg/middlewares"
+	"uiassignment/internal/pkg/notify"
+	"uiassignment/internal/pkg/outbox"
+	"uiassignment/internal/pkg/webhook"
 	"uiassignment/internal/pkg/websocket"
 	"uiassignment/web/pkg/webhandlers"
 
@@ -21,38 +28,128 @@ import (
 // @BasePath     /
 // @schemes      http
 // @tag.name     uiassignment.
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+// @description                 "Bearer" followed by a space and an access token or API key
+
+// @securityDefinitions.apikey  AccessTokenAuth
+// @in                          header
+// @name                        X-Accesstoken
+// @description                 Access token
+
+// @securityDefinitions.apikey  ApiKeyAuth
+// @in                          header
+// @name                        X-Api-Key
+// @description                 API key
 func main() {
 	DB := db.Init()
 	Validator := validator.New()
-	hub := websocket.NewHub()
-	go hub.Run()
-	handler := handlers.New(DB, Validator, hub)
+	hub := websocket.NewHub(middlewares.AccessTokenValidator(DB), websocket.NewBackplane(DB, db.DSN()),
+		websocket.NewRoomStore(DB), websocket.NewMuteStore(DB), websocket.NewModerator())
+	go hub.Run(context.Background())
+	notifier := notify.New()
+	dispatcher := webhook.NewDispatcher(DB)
+	go dispatcher.Run(context.Background())
+	bus := events.NewBus()
+	bus.Subscribe(dispatcher.Handle)
+	bus.Subscribe(hub.HandleEvent)
+	go outbox.NewRelay(DB, bus.Publish).Run(context.Background())
+	handler := handlers.New(DB, Validator, hub, notifier, authenticator.New(DB))
 
 	router := mux.NewRouter()
 	router.HandleFunc("/health", handlers.HealthCheckHandler)
 	// Websocket demo
 	router.HandleFunc("/web/chat", webhandlers.ChatWebHandler)
-	router.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	// Connections act for the user in chats and receive their private messages,
+	// which OAuth clients and API keys can't
+	realtimeMW := func(h http.Handler) http.Handler {
+		return middlewares.AccessTokenCheckMW(DB)(middlewares.FirstPartyOnlyMW()(h))
+	}
+	router.Handle("/ws", realtimeMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		websocket.ServeWs(hub, w, r)
-	})
+	})))
+	router.Handle("/api/v1/events", realtimeMW(http.HandlerFunc(handler.EventStreamHandler))).
+		Methods(http.MethodGet)
 
 	// Paths without access control
 	subRouter := router.PathPrefix("/api/v1/").Subrouter()
 	subRouter.HandleFunc("/accessToken", handler.CreateAccessTokenHandler).Methods(http.MethodPost)
+	subRouter.HandleFunc("/accessToken/twoFactor", handler.CompleteTwoFactorLoginHandler).Methods(http.MethodPost)
 	subRouter.HandleFunc("/users", handler.CreateUserHandler).Methods(http.MethodPost)
+	subRouter.HandleFunc("/passwordResets", handler.CreatePasswordResetHandler).Methods(http.MethodPost)
+	subRouter.HandleFunc("/passwordResets/{token}", handler.RedeemPasswordResetHandler).Methods(http.MethodPost)
+	subRouter.HandleFunc("/emailVerifications/{token}", handler.VerifyEmailHandler).Methods(http.MethodGet)
+	subRouter.HandleFunc("/webauthn/assertions/options", handler.BeginWebauthnLoginHandler).Methods(http.MethodPost)
+	subRouter.HandleFunc("/webauthn/assertions", handler.FinishWebauthnLoginHandler).Methods(http.MethodPost)
+
+	subRouter.HandleFunc("/oauth/token", handler.OauthTokenHandler).Methods(http.MethodPost)
+	subRouter.HandleFunc("/oidc/providers", handler.ListOidcProvidersHandler).Methods(http.MethodGet)
+	subRouter.HandleFunc("/oidc/{provider}/login", handler.BeginOidcLoginHandler).Methods(http.MethodGet)
+	subRouter.HandleFunc("/oidc/{provider}/callback", handler.FinishOidcLoginHandler).Methods(http.MethodGet)
 
 	// Paths that requires access token
 	accessControledSR := router.PathPrefix("/api/v1/").Subrouter()
-	accessControledSR.Use(middlewares.AccessTokenCheckMW())
+	accessControledSR.Use(middlewares.AccessTokenCheckMW(DB), middlewares.CSRFProtectionMW(), middlewares.RequireScopeMW(auth.ScopeUsersRead))
 	accessControledSR.HandleFunc("/users/{account}", handler.GetUserByAccountHandler).Methods(http.MethodGet)
 	accessControledSR.HandleFunc("/users", handler.ListUsersHandler).Methods(http.MethodGet)
+	accessControledSR.HandleFunc("/presence", handler.ListPresenceHandler).Methods(http.MethodGet)
+
+	// Paths that requires a token issued by our own login, not to an OAuth client
+	firstPartySR := router.PathPrefix("/api/v1/").Subrouter()
+	firstPartySR.Use(middlewares.AccessTokenCheckMW(DB), middlewares.CSRFProtectionMW(), middlewares.FirstPartyOnlyMW())
+	firstPartySR.HandleFunc("/oauth/clients", handler.RegisterOauthClientHandler).Methods(http.MethodPost)
+	firstPartySR.HandleFunc("/oauth/clients", handler.ListOauthClientsHandler).Methods(http.MethodGet)
+	firstPartySR.HandleFunc("/oauth/clients/{clientId}", handler.DeleteOauthClientHandler).Methods(http.MethodDelete)
+	firstPartySR.HandleFunc("/oauth/authorize", handler.AuthorizeOauthClientHandler).Methods(http.MethodPost)
+	firstPartySR.HandleFunc("/sessions", handler.CreateSessionHandler).Methods(http.MethodPost)
+	firstPartySR.HandleFunc("/sessions/current", handler.DeleteCurrentSessionHandler).Methods(http.MethodDelete)
+	firstPartySR.HandleFunc("/chatRooms", handler.CreateChatRoomHandler).Methods(http.MethodPost)
+	firstPartySR.HandleFunc("/chatRooms", handler.ListChatRoomsHandler).Methods(http.MethodGet)
+	firstPartySR.HandleFunc("/chatRooms/{name}", handler.UpdateChatRoomHandler).Methods(http.MethodPatch)
+	firstPartySR.HandleFunc("/chatRooms/{name}/messages", handler.ListChatMessagesHandler).Methods(http.MethodGet)
 
 	// Paths that requires resource owner access
 	ownerAccessSR := router.PathPrefix("/api/v1/").Subrouter()
-	ownerAccessSR.Use(middlewares.OwnerAccessCheckMW())
+	ownerAccessSR.Use(middlewares.OwnerAccessCheckMW(DB), middlewares.CSRFProtectionMW(), middlewares.RequireScopeMW(auth.ScopeUsersWrite))
 	ownerAccessSR.HandleFunc("/users/{account}", handler.DeleteUserByAccountHandler).Methods(http.MethodDelete)
 	ownerAccessSR.HandleFunc("/users/{account}", handler.UpdateUserHandler).Methods(http.MethodPatch)
 
+	// Paths that manage credentials of the resource owner, never delegated to OAuth clients
+	credentialSR := router.PathPrefix("/api/v1/").Subrouter()
+	credentialSR.Use(middlewares.OwnerAccessCheckMW(DB), middlewares.CSRFProtectionMW(), middlewares.FirstPartyOnlyMW())
+	credentialSR.HandleFunc("/users/{account}/password", handler.ChangePasswordHandler).Methods(http.MethodPut)
+	credentialSR.HandleFunc("/users/{account}/emailVerifications", handler.ResendEmailVerificationHandler).Methods(http.MethodPost)
+	credentialSR.HandleFunc("/users/{account}/totp", handler.EnrollTotpHandler).Methods(http.MethodPost)
+	credentialSR.HandleFunc("/users/{account}/totp/confirm", handler.ConfirmTotpHandler).Methods(http.MethodPost)
+	credentialSR.HandleFunc("/users/{account}/totp", handler.DisableTotpHandler).Methods(http.MethodDelete)
+	credentialSR.HandleFunc("/users/{account}/webauthn/registrations/options", handler.BeginWebauthnRegistrationHandler).Methods(http.MethodPost)
+	credentialSR.HandleFunc("/users/{account}/webauthn/registrations", handler.FinishWebauthnRegistrationHandler).Methods(http.MethodPost)
+	credentialSR.HandleFunc("/users/{account}/webauthn/credentials", handler.ListWebauthnCredentialsHandler).Methods(http.MethodGet)
+	credentialSR.HandleFunc("/users/{account}/webauthn/credentials/{id}", handler.DeleteWebauthnCredentialHandler).Methods(http.MethodDelete)
+	credentialSR.HandleFunc("/users/{account}/apiKeys", handler.CreateApiKeyHandler).Methods(http.MethodPost)
+	credentialSR.HandleFunc("/users/{account}/apiKeys", handler.ListApiKeysHandler).Methods(http.MethodGet)
+	credentialSR.HandleFunc("/users/{account}/apiKeys/{id}", handler.RevokeApiKeyHandler).Methods(http.MethodDelete)
+	credentialSR.HandleFunc("/users/{account}/sessions", handler.ListSessionsHandler).Methods(http.MethodGet)
+	credentialSR.HandleFunc("/users/{account}/sessions/{id}", handler.RevokeSessionHandler).Methods(http.MethodDelete)
+
+	// Paths that requires an admin
+	adminSR := router.PathPrefix("/api/v1/admin/").Subrouter()
+	adminSR.Use(middlewares.AccessTokenCheckMW(DB), middlewares.CSRFProtectionMW(), middlewares.FirstPartyOnlyMW(),
+		middlewares.RequireRoleMW(auth.AdminRole))
+	adminSR.HandleFunc("/users/{account}/sessions", handler.ListSessionsHandler).Methods(http.MethodGet)
+	adminSR.HandleFunc("/users/{account}/sessions/{id}", handler.RevokeSessionHandler).Methods(http.MethodDelete)
+	adminSR.HandleFunc("/webhooks", handler.CreateWebhookHandler).Methods(http.MethodPost)
+	adminSR.HandleFunc("/webhooks", handler.ListWebhooksHandler).Methods(http.MethodGet)
+	adminSR.HandleFunc("/webhooks/{id}", handler.DeleteWebhookHandler).Methods(http.MethodDelete)
+	adminSR.HandleFunc("/webhooks/{id}/deliveries", handler.ListWebhookDeliveriesHandler).Methods(http.MethodGet)
+	adminSR.HandleFunc("/webhooks/{id}/deliveries/{deliveryId}/retry", handler.RetryWebhookDeliveryHandler).Methods(http.MethodPost)
+	adminSR.HandleFunc("/users/{account}/mute", handler.MuteUserHandler).Methods(http.MethodPut)
+	adminSR.HandleFunc("/users/{account}/mute", handler.UnmuteUserHandler).Methods(http.MethodDelete)
+	adminSR.HandleFunc("/users/{account}/kick", handler.KickUserHandler).Methods(http.MethodPost)
+	adminSR.HandleFunc("/metrics", handler.MetricsHandler).Methods(http.MethodGet)
+
 	// TLS
 	enableTls := true
 	sslCrtPath := "/app/uiassignment/tls/tls.crt"
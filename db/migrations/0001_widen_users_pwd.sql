@@ -0,0 +1,2 @@
+-- Password hashes are stored as PHC strings which can be longer than bcrypt's 60 characters.
+ALTER TABLE users ALTER COLUMN pwd TYPE VARCHAR ( 255 );
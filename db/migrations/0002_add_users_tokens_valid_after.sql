@@ -0,0 +1,2 @@
+-- Access tokens issued before this time are rejected, e.g. after a password change.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP;
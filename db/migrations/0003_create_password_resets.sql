@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash CHAR ( 64 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL
+);
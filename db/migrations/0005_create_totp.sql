@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS totp_credentials (
+	acct VARCHAR PRIMARY KEY REFERENCES users ( acct ) ON DELETE CASCADE,
+	secret VARCHAR ( 64 ) NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	failed_attempts INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+	code_hash CHAR ( 64 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL
+);
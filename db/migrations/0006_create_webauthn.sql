@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	credential_id VARCHAR ( 1366 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	name VARCHAR ( 50 ) NOT NULL,
+	public_key BYTEA NOT NULL,
+	sign_count BIGINT NOT NULL DEFAULT 0,
+	aaguid BYTEA,
+	created_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webauthn_credentials_acct_idx ON webauthn_credentials ( acct );
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+	challenge_hash CHAR ( 64 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL DEFAULT '',
+	ceremony VARCHAR ( 20 ) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
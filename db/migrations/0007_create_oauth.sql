@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id VARCHAR ( 64 ) PRIMARY KEY,
+	secret_hash VARCHAR ( 64 ) NOT NULL DEFAULT '',
+	name VARCHAR ( 50 ) NOT NULL,
+	owner_acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	redirect_uris TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+	code_hash CHAR ( 64 ) PRIMARY KEY,
+	client_id VARCHAR ( 64 ) NOT NULL REFERENCES oauth_clients ( client_id ) ON DELETE CASCADE,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	redirect_uri TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	code_challenge VARCHAR ( 128 ) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
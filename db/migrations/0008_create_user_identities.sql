@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider VARCHAR ( 50 ) NOT NULL,
+	subject VARCHAR ( 255 ) NOT NULL,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	last_login_at TIMESTAMP,
+	PRIMARY KEY ( provider, subject )
+);
+CREATE INDEX IF NOT EXISTS user_identities_acct_idx ON user_identities ( acct );
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	token_hash CHAR ( 64 ) NOT NULL UNIQUE,
+	ip VARCHAR ( 45 ) NOT NULL DEFAULT '',
+	user_agent VARCHAR ( 255 ) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	max_expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_acct_idx ON sessions ( acct );
@@ -0,0 +1,3 @@
+ALTER TABLE sessions ALTER COLUMN token_hash DROP NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS kind VARCHAR ( 10 ) NOT NULL DEFAULT 'cookie';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device VARCHAR ( 50 ) NOT NULL DEFAULT '';
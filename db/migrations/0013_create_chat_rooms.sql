@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS chat_rooms (
+	name VARCHAR ( 59 ) PRIMARY KEY,
+	description VARCHAR ( 255 ) NOT NULL DEFAULT '',
+	created_by VARCHAR REFERENCES users ( acct ) ON DELETE SET NULL,
+	retention_days INTEGER,
+	max_messages INTEGER,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_messages (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	room VARCHAR NOT NULL REFERENCES chat_rooms ( name ) ON DELETE CASCADE,
+	acct VARCHAR NOT NULL,
+	text TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS chat_messages_room_created_at_idx ON chat_messages ( room, created_at );
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL,
+	secret VARCHAR ( 64 ) NOT NULL,
+	created_by VARCHAR REFERENCES users ( acct ) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	subscription_id VARCHAR ( 16 ) NOT NULL REFERENCES webhook_subscriptions ( id ) ON DELETE CASCADE,
+	event_id VARCHAR ( 16 ) NOT NULL,
+	event_type VARCHAR ( 50 ) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR ( 10 ) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_status_code INTEGER,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx ON webhook_deliveries ( subscription_id, created_at );
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries ( next_attempt_at ) WHERE status = 'pending';
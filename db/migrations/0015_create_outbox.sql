@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS outbox_entries (
+	id BIGSERIAL PRIMARY KEY,
+	event_id VARCHAR ( 16 ) NOT NULL UNIQUE,
+	event_type VARCHAR ( 50 ) NOT NULL,
+	aggregate VARCHAR NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_entries_pending_idx ON outbox_entries ( aggregate, id ) WHERE delivered_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_entries_delivered_at_idx ON outbox_entries ( delivered_at ) WHERE delivered_at IS NOT NULL;
+
+-- Entries are relayed at least once, so the same event may be enqueued again.
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON webhook_deliveries ( subscription_id, event_id );
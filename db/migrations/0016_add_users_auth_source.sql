@@ -0,0 +1,8 @@
+-- Where the password of a user is verified, local or ldap. Directory users
+-- can't take over local accounts and have no local password to change.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_source VARCHAR ( 10 ) NOT NULL DEFAULT 'local';
+
+-- Users provisioned from the directory so far are the ones without a password
+-- which weren't provisioned by an OIDC login
+UPDATE users SET auth_source = 'ldap'
+	WHERE pwd = '' AND NOT EXISTS ( SELECT 1 FROM user_identities WHERE user_identities.acct = users.acct );
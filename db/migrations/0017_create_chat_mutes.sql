@@ -0,0 +1,6 @@
+-- Mutes outlast restarts and apply to connections made later on any replica
+CREATE TABLE IF NOT EXISTS chat_mutes (
+	acct VARCHAR PRIMARY KEY REFERENCES users ( acct ) ON DELETE CASCADE,
+	muted_until TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
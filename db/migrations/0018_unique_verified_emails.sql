@@ -0,0 +1,4 @@
+-- Only verified emails are unique, so nobody can hold back an address from
+-- its owner by registering it without verifying it
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_verified_email_idx ON users ( email ) WHERE email_verified;
@@ -0,0 +1,7 @@
+-- Messages too large for a NOTIFY payload, the Postgres websocket backplane
+-- notifies their IDs instead
+CREATE TABLE IF NOT EXISTS backplane_payloads (
+	id VARCHAR PRIMARY KEY,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
@@ -1,7 +1,192 @@
 CREATE TABLE IF NOT EXISTS users (
 	acct VARCHAR PRIMARY KEY,
-	pwd VARCHAR ( 60 ) NOT NULL,
+	pwd VARCHAR ( 255 ) NOT NULL,
 	fullname VARCHAR ( 50 ) NOT NULL,
+	email VARCHAR ( 254 ),
+	email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+	roles VARCHAR NOT NULL DEFAULT '',
+	auth_source VARCHAR ( 10 ) NOT NULL DEFAULT 'local',
 	created_at TIMESTAMP NOT NULL,
-    updated_at TIMESTAMP 
-);
\ No newline at end of file
+    updated_at TIMESTAMP,
+	tokens_valid_after TIMESTAMP
+);
+-- Only verified emails are unique
+CREATE UNIQUE INDEX IF NOT EXISTS users_verified_email_idx ON users ( email ) WHERE email_verified;
+
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash CHAR ( 64 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS totp_credentials (
+	acct VARCHAR PRIMARY KEY REFERENCES users ( acct ) ON DELETE CASCADE,
+	secret VARCHAR ( 64 ) NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	failed_attempts INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+	code_hash CHAR ( 64 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	credential_id VARCHAR ( 1366 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	name VARCHAR ( 50 ) NOT NULL,
+	public_key BYTEA NOT NULL,
+	sign_count BIGINT NOT NULL DEFAULT 0,
+	aaguid BYTEA,
+	created_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webauthn_credentials_acct_idx ON webauthn_credentials ( acct );
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+	challenge_hash CHAR ( 64 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL DEFAULT '',
+	ceremony VARCHAR ( 20 ) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id VARCHAR ( 64 ) PRIMARY KEY,
+	secret_hash VARCHAR ( 64 ) NOT NULL DEFAULT '',
+	name VARCHAR ( 50 ) NOT NULL,
+	owner_acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	redirect_uris TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+	code_hash CHAR ( 64 ) PRIMARY KEY,
+	client_id VARCHAR ( 64 ) NOT NULL REFERENCES oauth_clients ( client_id ) ON DELETE CASCADE,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	redirect_uri TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	code_challenge VARCHAR ( 128 ) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider VARCHAR ( 50 ) NOT NULL,
+	subject VARCHAR ( 255 ) NOT NULL,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	last_login_at TIMESTAMP,
+	PRIMARY KEY ( provider, subject )
+);
+CREATE INDEX IF NOT EXISTS user_identities_acct_idx ON user_identities ( acct );
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	name VARCHAR ( 50 ) NOT NULL,
+	key_hash CHAR ( 64 ) NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS api_keys_acct_idx ON api_keys ( acct );
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	acct VARCHAR NOT NULL REFERENCES users ( acct ) ON DELETE CASCADE,
+	kind VARCHAR ( 10 ) NOT NULL DEFAULT 'cookie',
+	token_hash CHAR ( 64 ) UNIQUE,
+	device VARCHAR ( 50 ) NOT NULL DEFAULT '',
+	ip VARCHAR ( 45 ) NOT NULL DEFAULT '',
+	user_agent VARCHAR ( 255 ) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	max_expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_acct_idx ON sessions ( acct );
+
+CREATE TABLE IF NOT EXISTS chat_rooms (
+	name VARCHAR ( 59 ) PRIMARY KEY,
+	description VARCHAR ( 255 ) NOT NULL DEFAULT '',
+	created_by VARCHAR REFERENCES users ( acct ) ON DELETE SET NULL,
+	retention_days INTEGER,
+	max_messages INTEGER,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_messages (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	room VARCHAR NOT NULL REFERENCES chat_rooms ( name ) ON DELETE CASCADE,
+	acct VARCHAR NOT NULL,
+	text TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS chat_messages_room_created_at_idx ON chat_messages ( room, created_at );
+
+CREATE TABLE IF NOT EXISTS chat_mutes (
+	acct VARCHAR PRIMARY KEY REFERENCES users ( acct ) ON DELETE CASCADE,
+	muted_until TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS backplane_payloads (
+	id VARCHAR PRIMARY KEY,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL,
+	secret VARCHAR ( 64 ) NOT NULL,
+	created_by VARCHAR REFERENCES users ( acct ) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id VARCHAR ( 16 ) PRIMARY KEY,
+	subscription_id VARCHAR ( 16 ) NOT NULL REFERENCES webhook_subscriptions ( id ) ON DELETE CASCADE,
+	event_id VARCHAR ( 16 ) NOT NULL,
+	event_type VARCHAR ( 50 ) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR ( 10 ) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_status_code INTEGER,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx ON webhook_deliveries ( subscription_id, created_at );
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries ( next_attempt_at ) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS outbox_entries (
+	id BIGSERIAL PRIMARY KEY,
+	event_id VARCHAR ( 16 ) NOT NULL UNIQUE,
+	event_type VARCHAR ( 50 ) NOT NULL,
+	aggregate VARCHAR NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_entries_pending_idx ON outbox_entries ( aggregate, id ) WHERE delivered_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_entries_delivered_at_idx ON outbox_entries ( delivered_at ) WHERE delivered_at IS NOT NULL;
+
+-- Entries are relayed at least once, so the same event may be enqueued again.
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON webhook_deliveries ( subscription_id, event_id );
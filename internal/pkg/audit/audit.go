@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Audit actions
+const (
+	ActionPasswordChanged       = "password.changed"
+	ActionPasswordReset         = "password.reset"
+	ActionTotpEnabled           = "totp.enabled"
+	ActionTotpDisabled          = "totp.disabled"
+	ActionWebauthnRegistered    = "webauthn.registered"
+	ActionWebauthnRemoved       = "webauthn.removed"
+	ActionOauthClientRegistered = "oauth.client.registered"
+	ActionOauthClientDeleted    = "oauth.client.deleted"
+	ActionOauthClientAuthorized = "oauth.client.authorized"
+	ActionIdentityLinked        = "identity.linked"
+	ActionUserProvisioned       = "user.provisioned"
+	ActionApiKeyCreated         = "apikey.created"
+	ActionApiKeyRevoked         = "apikey.revoked"
+	ActionSessionRevoked        = "session.revoked"
+	ActionWebhookCreated        = "webhook.created"
+	ActionWebhookDeleted        = "webhook.deleted"
+	ActionUserMuted             = "user.muted"
+	ActionUserUnmuted           = "user.unmuted"
+	ActionUserKicked            = "user.kicked"
+)
+
+// Record describes a security relevant action taken on an account.
+type Record struct {
+	// When the action happened
+	Time time.Time `json:"time"`
+	// What happened
+	Action string `json:"action"`
+	// Account which performed the action
+	Actor string `json:"actor"`
+	// Account the action was performed on
+	Target string `json:"target"`
+	// Remote address of the request
+	RemoteAddr string `json:"remoteAddr"`
+	// User agent of the request
+	UserAgent string `json:"userAgent"`
+}
+
+// Log writes an audit record for the action performed within the given request.
+func Log(r *http.Request, action string, actor string, target string) {
+	record := Record{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		Actor:      actor,
+		Target:     target,
+		RemoteAddr: RemoteIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	log.Println("AUDIT", string(bytes))
+}
+
+// Remote IP address of the request, without port.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -2,7 +2,9 @@ package auth
 
 import (
 	_ "embed"
+	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -11,13 +13,58 @@ import (
 //go:embed resources/jwt.key
 var jwtSecretKey []byte
 
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
 type Claims struct {
-	Account string `json:"acct"`
+	// Account the token acts for, empty for client credentials tokens
+	Account string `json:"acct,omitempty"`
+	// OAuth client the token was issued to, empty for first-party tokens
+	ClientID string `json:"client_id,omitempty"`
+	// Space separated OAuth scopes, empty for first-party tokens
+	Scope string `json:"scope,omitempty"`
+	// API key the request was authenticated with, never part of a token
+	APIKeyID string `json:"-"`
+	// Session the token belongs to
+	SessionID string `json:"sid,omitempty"`
+	// Issue time in microseconds, so revocations tell apart tokens issued in
+	// the same second. Missing in tokens issued before it was introduced.
+	IssuedAtMicros int64 `json:"iat_us,omitempty"`
+	// Roles of the account, loaded on validation
+	Roles []string `json:"-"`
 	jwt.StandardClaims
 }
 
-// Validates access token and returns owner account.
-func IsAccessTokenValid(accessToken string) (isTokenValid bool, tokenOwnerAccount string) {
+// First-party tokens are issued to our own clients and aren't limited by scopes.
+// API keys aren't first-party.
+func (c *Claims) IsFirstParty() bool {
+	return len(c.ClientID) == 0 && len(c.APIKeyID) == 0
+}
+
+// Reports whether the account the token acts for has the role.
+func (c *Claims) HasRole(role string) bool {
+	for _, granted := range c.Roles {
+		if granted == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the token grants the scope.
+func (c *Claims) HasScope(scope string) bool {
+	if c.IsFirstParty() {
+		return true
+	}
+	for _, granted := range strings.Fields(c.Scope) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses and validates access token. Returns the claims of a valid token.
+func ParseAccessToken(accessToken string) (*Claims, error) {
 	claims := &Claims{}
 	// Parse the token
 	token, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
@@ -25,29 +72,72 @@ func IsAccessTokenValid(accessToken string) (isTokenValid bool, tokenOwnerAccoun
 	})
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
-			log.Println("Received a token with invalid signature: ", accessToken)
-			return false, ""
+			log.Println("Received a token with invalid signature: ", TokenFingerprint(accessToken))
+			return nil, ErrInvalidAccessToken
 		}
-		log.Println("Fail to parse token: ", accessToken)
-		return false, ""
+		log.Println("Fail to parse token: ", TokenFingerprint(accessToken))
+		return nil, ErrInvalidAccessToken
 	}
 	if !token.Valid {
-		log.Println("Invalid token: ", accessToken)
+		log.Println("Invalid token: ", TokenFingerprint(accessToken))
+		return nil, ErrInvalidAccessToken
+	}
+
+	return claims, nil
+}
+
+// Validates access token and returns owner account.
+func IsAccessTokenValid(accessToken string) (isTokenValid bool, tokenOwnerAccount string) {
+	claims, err := ParseAccessToken(accessToken)
+	if err != nil {
 		return false, ""
 	}
 
 	return true, claims.Account
 }
 
-// Creates access token for the given user account.
-func CreateAccessTokenForUser(userAccount string) (accessToken string, expiresAt int64, err error) {
-	expiresAt = time.Now().Add(24 * time.Hour).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, Claims{
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expiresAt,
-		},
-		Account: userAccount,
-	})
+// Reports whether the token was issued before the given time, i.e. has been revoked.
+// Tokens without the issue time in microseconds only have second precision, so
+// if they're issued in the second of the revocation they're revoked as well.
+// Tokens without issue time are considered revoked once any revocation happened.
+func IsIssuedBefore(claims *Claims, revokedAt *time.Time) bool {
+	if revokedAt == nil {
+		return false
+	}
+	if claims.IssuedAtMicros > 0 {
+		return claims.IssuedAtMicros <= revokedAt.UnixMicro()
+	}
+	return claims.IssuedAt <= revokedAt.Unix()
+}
+
+// Lifetime of access tokens issued on login
+var AccessTokenTTL = 24 * time.Hour
+
+// Creates access token for the given user account, belonging to the session
+// recorded for the login.
+func CreateAccessTokenForUser(userAccount string, sessionID string) (accessToken string, expiresAt int64, err error) {
+	return createAccessToken(Claims{Account: userAccount, SessionID: sessionID}, AccessTokenTTL)
+}
+
+// Creates access token for an OAuth client limited to the given scopes. The
+// account is empty when the client acts on its own behalf.
+func CreateScopedAccessToken(userAccount string, clientID string, scopes []string, ttl time.Duration) (accessToken string, expiresAt int64, err error) {
+	return createAccessToken(Claims{
+		Account:  userAccount,
+		ClientID: clientID,
+		Scope:    strings.Join(scopes, " "),
+	}, ttl)
+}
+
+func createAccessToken(claims Claims, ttl time.Duration) (accessToken string, expiresAt int64, err error) {
+	now := time.Now()
+	expiresAt = now.Add(ttl).Unix()
+	claims.StandardClaims = jwt.StandardClaims{
+		ExpiresAt: expiresAt,
+		IssuedAt:  now.Unix(),
+	}
+	claims.IssuedAtMicros = now.UnixMicro()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
 
 	accessToken, err = token.SignedString(jwtSecretKey)
 	if err != nil {
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestIsIssuedBefore(t *testing.T) {
+	revokedAt := time.Unix(1700000000, 900*int64(time.Millisecond))
+	claimsIssuedAt := func(issuedAt int64) *Claims {
+		return &Claims{StandardClaims: jwt.StandardClaims{IssuedAt: issuedAt}}
+	}
+
+	if IsIssuedBefore(claimsIssuedAt(revokedAt.Unix()-1), nil) {
+		t.Error("token is revoked without a revocation")
+	}
+	// Issue times are truncated to seconds, so a token of the same second may
+	// have been issued just before the revocation
+	for issuedAt, revoked := range map[int64]bool{
+		0:                    true,
+		revokedAt.Unix() - 1: true,
+		revokedAt.Unix():     true,
+		revokedAt.Unix() + 1: false,
+	} {
+		if got := IsIssuedBefore(claimsIssuedAt(issuedAt), &revokedAt); got != revoked {
+			t.Errorf("token issued at %d got revoked %t, want %t", issuedAt, got, revoked)
+		}
+	}
+
+	// Tokens with microseconds are told apart within the second
+	for issuedAt, revoked := range map[time.Time]bool{
+		revokedAt.Add(-time.Millisecond): true,
+		revokedAt:                        true,
+		revokedAt.Add(time.Millisecond):  false,
+	} {
+		claims := claimsIssuedAt(issuedAt.Unix())
+		claims.IssuedAtMicros = issuedAt.UnixMicro()
+		if got := IsIssuedBefore(claims, &revokedAt); got != revoked {
+			t.Errorf("token issued at %s got revoked %t, want %t", issuedAt, got, revoked)
+		}
+	}
+}
+
+func TestAccessTokensCarryIssueMicroseconds(t *testing.T) {
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	token, _, err := CreateAccessTokenForUser("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.IssuedAtMicros < before.UnixMicro() || claims.IssuedAtMicros > time.Now().UnixMicro() ||
+		claims.IssuedAt != claims.IssuedAtMicros/1000000 {
+		t.Errorf("got issue time %d, %dus", claims.IssuedAt, claims.IssuedAtMicros)
+	}
+	if IsIssuedBefore(claims, &before) {
+		t.Error("token issued after the revocation is revoked")
+	}
+}
+
+// Rejected tokens may be valid ones with a typo, or have a valid signature
+// but expired, so only their fingerprint is logged.
+func TestParseAccessTokenLogsFingerprint(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	accessToken, _, err := CreateAccessTokenForUser("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := createAccessToken(Claims{Account: "alice"}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rejected := range []string{accessToken + "x", expired, "not.a.jwt"} {
+		logged.Reset()
+		if _, err := ParseAccessToken(rejected); err != ErrInvalidAccessToken {
+			t.Fatalf("got error %v", err)
+		}
+		if strings.Contains(logged.String(), rejected) {
+			t.Errorf("token was logged: %s", logged.String())
+		}
+		if !strings.Contains(logged.String(), TokenFingerprint(rejected)) {
+			t.Errorf("fingerprint wasn't logged: %s", logged.String())
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package auth
+
+import "strings"
+
+// Prefix of API keys, which tells them apart from JWT access tokens and makes
+// leaked keys easy to find with secret scanners.
+const APIKeyPrefix = "uia_"
+
+// Generates a new API key. Only its hash is stored, the key is shown once.
+// The ID identifies the key for listing and revoking.
+func CreateAPIKey() (apiKey string, apiKeyHash string, id string, err error) {
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	id, err = GenerateRandomToken(9)
+	if err != nil {
+		return "", "", "", err
+	}
+	apiKey = APIKeyPrefix + secret
+	return apiKey, HashToken(apiKey), id, nil
+}
+
+// Reports whether the token looks like an API key rather than an access token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// Claims of a request authenticated by an API key. API keys act for their
+// owner limited to their scopes, like tokens of OAuth clients.
+func NewAPIKeyClaims(account string, apiKeyID string, scopes []string) *Claims {
+	return &Claims{
+		Account:  account,
+		APIKeyID: apiKeyID,
+		Scope:    strings.Join(scopes, " "),
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"errors"
+	"time"
+	"uiassignment/internal/pkg/config"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const emailVerificationPurpose = "email-verification"
+
+// How long an email verification link stays valid
+var EmailVerificationTokenTTL = config.GetDuration("EMAIL_VERIFICATION_TTL", 72*time.Hour)
+
+var ErrInvalidEmailVerificationToken = errors.New("invalid or expired email verification token")
+
+// Claims of a signed email verification link
+type EmailVerificationClaims struct {
+	Account string `json:"acct"`
+	Email   string `json:"email"`
+	jwt.StandardClaims
+}
+
+// Creates a signed token proving the owner of the account controls the email.
+func CreateEmailVerificationToken(account string, email string) (string, error) {
+	return CreateSignedToken(emailVerificationPurpose, EmailVerificationClaims{
+		Account: account,
+		Email:   email,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(EmailVerificationTokenTTL).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	})
+}
+
+// Validates an email verification token and returns its claims.
+func ParseEmailVerificationToken(verificationToken string) (*EmailVerificationClaims, error) {
+	claims := &EmailVerificationClaims{}
+	if err := ParseSignedToken(emailVerificationPurpose, verificationToken, claims); err != nil {
+		return nil, ErrInvalidEmailVerificationToken
+	}
+	return claims, nil
+}
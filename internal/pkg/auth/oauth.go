@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/config"
+)
+
+// OAuth scopes which can be granted to third-party clients
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+var SupportedScopes = []string{ScopeUsersRead, ScopeUsersWrite}
+
+var (
+	// Lifetime of access tokens issued to OAuth clients
+	OAuthAccessTokenTTL = config.GetDuration("OAUTH_ACCESS_TOKEN_TTL", time.Hour)
+	// Lifetime of authorization codes
+	OAuthAuthorizationCodeTTL = config.GetDuration("OAUTH_AUTHORIZATION_CODE_TTL", time.Minute)
+)
+
+var (
+	ErrInvalidScope       = errors.New("requested scope is invalid or exceeds the granted scope")
+	ErrInvalidRedirectURI = errors.New("redirect URI must be an absolute https URL, or http for localhost, without fragment")
+)
+
+// Parses a space separated scope and checks every scope is within the allowed
+// ones. An empty request is given all allowed scopes.
+func ParseScope(requested string, allowed []string) ([]string, error) {
+	requestedScopes := strings.Fields(requested)
+	if len(requestedScopes) == 0 {
+		return allowed, nil
+	}
+
+	var scopes []string
+	seen := map[string]bool{}
+	for _, scope := range requestedScopes {
+		if !containsString(allowed, scope) {
+			return nil, ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// Checks a redirect URI can be registered for a client.
+func ValidateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || !parsed.IsAbs() || len(parsed.Fragment) > 0 || len(parsed.Host) == 0 {
+		return ErrInvalidRedirectURI
+	}
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if host == "localhost" || net.ParseIP(host).IsLoopback() {
+			return nil
+		}
+	}
+	return ErrInvalidRedirectURI
+}
+
+// Verifies a PKCE code verifier(RFC 7636) against the challenge sent with the
+// authorization request. Only the S256 method is supported.
+func VerifyPKCE(codeChallenge string, codeVerifier string) bool {
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+	expected := PKCEChallenge(codeVerifier)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) == 1
+}
+
+// Derives the S256 PKCE code challenge of a code verifier.
+func PKCEChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Compares a presented client secret with the stored hash.
+func IsClientSecretMatched(storedSecretHash string, clientSecret string) bool {
+	return subtle.ConstantTimeCompare([]byte(storedSecretHash), []byte(HashToken(clientSecret))) == 1
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
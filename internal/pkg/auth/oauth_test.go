@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Example of RFC 7636 appendix B
+const (
+	rfc7636Verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfc7636Challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestPKCEChallengeRFC7636Vector(t *testing.T) {
+	if got := PKCEChallenge(rfc7636Verifier); got != rfc7636Challenge {
+		t.Errorf("challenge = %s, want %s", got, rfc7636Challenge)
+	}
+	if !VerifyPKCE(rfc7636Challenge, rfc7636Verifier) {
+		t.Error("verifier of the RFC example was rejected")
+	}
+}
+
+func TestVerifyPKCERejectsInvalidVerifiers(t *testing.T) {
+	for name, verifier := range map[string]string{
+		"empty": "",
+		// The plain method sends the verifier as the challenge
+		"plain":     rfc7636Challenge,
+		"other":     strings.Repeat("a", 43),
+		"too short": rfc7636Verifier[:42],
+		"too long":  strings.Repeat("a", 129),
+	} {
+		if VerifyPKCE(rfc7636Challenge, verifier) {
+			t.Errorf("%s verifier was accepted", name)
+		}
+	}
+	// A plain challenge equal to its verifier isn't accepted either
+	plain := strings.Repeat("b", 43)
+	if VerifyPKCE(plain, plain) {
+		t.Error("plain code challenge method was accepted")
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	for redirectURI, valid := range map[string]bool{
+		"https://client.example.com/callback":   true,
+		"http://localhost:8080/callback":        true,
+		"http://127.0.0.1/callback":             true,
+		"http://[::1]:3000/callback":            true,
+		"http://client.example.com/callback":    false,
+		"http://localhost.example.com/callback": false,
+		"http://10.0.0.1/callback":              false,
+		"https://client.example.com/cb#frag":    false,
+		"/callback":                             false,
+		"custom:callback":                       false,
+		"https:///callback":                     false,
+	} {
+		if err := ValidateRedirectURI(redirectURI); (err == nil) != valid {
+			t.Errorf("%s: got error %v, want valid %t", redirectURI, err, valid)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	allowed := []string{ScopeUsersRead}
+
+	scopes, err := ParseScope("", allowed)
+	if err != nil || !reflect.DeepEqual(scopes, allowed) {
+		t.Errorf("empty scope got %v, error %v", scopes, err)
+	}
+	scopes, err = ParseScope("users:read  users:read", allowed)
+	if err != nil || !reflect.DeepEqual(scopes, allowed) {
+		t.Errorf("duplicated scope got %v, error %v", scopes, err)
+	}
+	if _, err := ParseScope("users:read users:write", allowed); err != ErrInvalidScope {
+		t.Errorf("scope outside the allowed ones got error %v", err)
+	}
+	if _, err := ParseScope("admin", SupportedScopes); err != ErrInvalidScope {
+		t.Errorf("unknown scope got error %v", err)
+	}
+}
+
+func TestIsClientSecretMatched(t *testing.T) {
+	hash := HashToken("client-secret")
+	if !IsClientSecretMatched(hash, "client-secret") {
+		t.Error("matching secret was rejected")
+	}
+	if IsClientSecretMatched(hash, "other-secret") || IsClientSecretMatched(hash, "") {
+		t.Error("wrong secret was accepted")
+	}
+}
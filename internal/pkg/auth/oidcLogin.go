@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"errors"
+	"time"
+	"uiassignment/internal/pkg/config"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const oidcLoginPurpose = "oidc-login"
+
+// How long a login at an upstream OIDC provider can take
+var OIDCLoginTTL = config.GetDuration("OIDC_LOGIN_TTL", 10*time.Minute)
+
+var ErrInvalidOIDCLoginState = errors.New("invalid or expired OIDC login state")
+
+// Claims of a pending login at an upstream OIDC provider. The token is kept in
+// a cookie of the browser which started the login.
+type OIDCLoginClaims struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+	// Whether the login starts a browser session instead of issuing an access token
+	CookieSession bool `json:"cookieSession,omitempty"`
+	jwt.StandardClaims
+}
+
+// Creates the random values of a new login at the given provider, returning
+// them together with the signed token carrying them.
+func CreateOIDCLoginState(provider string, cookieSession bool) (string, *OIDCLoginClaims, error) {
+	claims := &OIDCLoginClaims{
+		Provider:      provider,
+		CookieSession: cookieSession,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(OIDCLoginTTL).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+
+	var err error
+	if claims.State, err = GenerateRandomToken(32); err != nil {
+		return "", nil, err
+	}
+	if claims.Nonce, err = GenerateRandomToken(32); err != nil {
+		return "", nil, err
+	}
+	if claims.CodeVerifier, err = GenerateRandomToken(32); err != nil {
+		return "", nil, err
+	}
+
+	loginState, err := CreateSignedToken(oidcLoginPurpose, claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return loginState, claims, nil
+}
+
+// Validates a login state token created for the given provider.
+func ParseOIDCLoginState(loginState string, provider string) (*OIDCLoginClaims, error) {
+	claims := &OIDCLoginClaims{}
+	if err := ParseSignedToken(oidcLoginPurpose, loginState, claims); err != nil || claims.Provider != provider {
+		return nil, ErrInvalidOIDCLoginState
+	}
+	return claims, nil
+}
@@ -1,25 +1,265 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"uiassignment/internal/pkg/config"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Argon2id parameters out of range make argon2.IDKey panic or take forever,
+// so they're limited to these.
+const (
+	// 4 GiB
+	maxArgon2Memory     = 4 * 1024 * 1024
+	maxArgon2Iterations = 100
+	maxArgon2Threads    = 255
+)
+
+var (
+	passwordHashAlgorithm = config.Get("PASSWORD_HASH_ALGORITHM", "argon2id")
+	bcryptCost            = config.GetIntInRange("PASSWORD_BCRYPT_COST", bcrypt.DefaultCost, bcrypt.MinCost, bcrypt.MaxCost)
+	argon2Memory          = config.GetIntInRange("PASSWORD_ARGON2_MEMORY", 64*1024, 8*maxArgon2Threads, maxArgon2Memory)
+	argon2Iterations      = config.GetIntInRange("PASSWORD_ARGON2_ITERATIONS", 3, 1, maxArgon2Iterations)
+	argon2Parallelism     = config.GetIntInRange("PASSWORD_ARGON2_PARALLELISM", 2, 1, maxArgon2Threads)
+)
+
+var (
+	ErrUnknownHashFormat    = errors.New("unknown password hash format")
+	ErrInvalidHashParameter = errors.New("invalid password hash parameter")
+)
+
+// PasswordHasher hashes and verifies passwords for one algorithm.
+// Hashes are self-describing strings, PHC format for Argon2id and the
+// modular crypt format for bcrypt, so the algorithm and its parameters can
+// be recovered from a stored hash.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash.
+	Verify(encodedHash string, password string) (bool, error)
+	// Handles reports whether the encoded hash was produced by this algorithm.
+	Handles(encodedHash string) bool
+	// NeedsRehash reports whether the encoded hash was produced with
+	// parameters other than the ones currently configured.
+	NeedsRehash(encodedHash string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt at the given cost. Costs out of
+// bcrypt's range are clamped to it.
+type BcryptHasher struct {
+	Cost int
+}
+
+// bcrypt silently hashes with its default cost below its minimum, which would
+// never match the configured cost and rehash on every login.
+func (b BcryptHasher) cost() int {
+	if b.Cost < bcrypt.MinCost {
+		return bcrypt.MinCost
+	}
+	if b.Cost > bcrypt.MaxCost {
+		return bcrypt.MaxCost
+	}
+	return b.Cost
+}
+
+func (b BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), b.cost())
+	return string(bytes), err
+}
+
+func (b BcryptHasher) Verify(encodedHash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b BcryptHasher) Handles(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") ||
+		strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+func (b BcryptHasher) NeedsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return true
+	}
+	return cost != b.cost()
+}
+
+// Argon2idHasher hashes passwords with Argon2id and encodes them as PHC strings:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+type Argon2idHasher struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (a Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2idHasher) Verify(encodedHash string, password string) (bool, error) {
+	params, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (a Argon2idHasher) Handles(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$argon2id$")
+}
+
+func (a Argon2idHasher) NeedsRehash(encodedHash string) bool {
+	params, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return true
+	}
+	return params.memory != a.Memory ||
+		params.iterations != a.Iterations ||
+		params.parallelism != a.Parallelism ||
+		uint32(len(params.salt)) != a.SaltLength ||
+		uint32(len(params.key)) != a.KeyLength
+}
+
+func decodeArgon2idHash(encodedHash string) (*argon2idParams, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+
+	params := &argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, err
+	}
+	// Sscanf ignores anything after the parameters
+	if fmt.Sprintf("m=%d,t=%d,p=%d", params.memory, params.iterations, params.parallelism) != parts[3] {
+		return nil, ErrUnknownHashFormat
+	}
+	// argon2.IDKey panics without iterations or threads
+	if params.memory == 0 || params.memory > maxArgon2Memory ||
+		params.iterations == 0 || params.iterations > maxArgon2Iterations ||
+		params.parallelism == 0 {
+		return nil, ErrInvalidHashParameter
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(params.salt) == 0 || len(params.key) == 0 {
+		return nil, ErrInvalidHashParameter
+	}
+	return params, nil
+}
+
+// Hasher used for new passwords. Selected by PASSWORD_HASH_ALGORITHM.
+var DefaultHasher PasswordHasher = newDefaultHasher()
+
+// All known hashers, used for verifying hashes produced by any algorithm.
+var knownHashers = []PasswordHasher{
+	Argon2idHasher{
+		Memory:      uint32(argon2Memory),
+		Iterations:  uint32(argon2Iterations),
+		Parallelism: uint8(argon2Parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	},
+	BcryptHasher{Cost: bcryptCost},
+}
+
+func newDefaultHasher() PasswordHasher {
+	switch passwordHashAlgorithm {
+	case "bcrypt":
+		return knownHashers[1]
+	case "argon2id":
+		return knownHashers[0]
+	default:
+		log.Printf("Unknown PASSWORD_HASH_ALGORITHM %q, falling back to argon2id", passwordHashAlgorithm)
+		return knownHashers[0]
+	}
+}
+
+func hasherFor(encodedHash string) PasswordHasher {
+	for _, hasher := range knownHashers {
+		if hasher.Handles(encodedHash) {
+			return hasher
+		}
+	}
+	return nil
+}
+
 // Hash the password. Returns empty string if an empty password string is given.
 func EncryptPassword(password string) (string, error) {
 	if len(password) == 0 {
 		return "", nil
 	}
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return DefaultHasher.Hash(password)
 }
 
 func IsPasswordMatched(storedPassword string, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password))
+	hasher := hasherFor(storedPassword)
+	if hasher == nil {
+		log.Println(ErrUnknownHashFormat.Error())
+		return false
+	}
+	matched, err := hasher.Verify(storedPassword, password)
 	if err != nil {
 		log.Println(err.Error())
 		return false
 	}
-	return true
+	return matched
+}
+
+// Reports whether the stored password should be rehashed with DefaultHasher,
+// either because it uses another algorithm or outdated parameters.
+func PasswordNeedsRehash(storedPassword string) bool {
+	if !DefaultHasher.Handles(storedPassword) {
+		return true
+	}
+	return DefaultHasher.NeedsRehash(storedPassword)
 }
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"uiassignment/internal/pkg/config"
+	"unicode"
+)
+
+var (
+	passwordMinLength = config.GetInt("PASSWORD_MIN_LENGTH", 8)
+	passwordMaxLength = 72
+)
+
+var (
+	ErrPasswordTooShort     = fmt.Errorf("password must be at least %d characters long", passwordMinLength)
+	ErrPasswordTooLong      = fmt.Errorf("password must be at most %d bytes long", passwordMaxLength)
+	ErrPasswordNotComplex   = errors.New("password must contain both letters and digits")
+	ErrPasswordContainsAcct = errors.New("password must not contain the account")
+	ErrPasswordNotChanged   = errors.New("new password must differ from the current password")
+)
+
+// Checks the password against the password policy for the given account.
+func ValidatePasswordPolicy(password string, account string) error {
+	if len([]rune(password)) < passwordMinLength {
+		return ErrPasswordTooShort
+	}
+	// bcrypt only uses the first 72 bytes
+	if len(password) > passwordMaxLength {
+		return ErrPasswordTooLong
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return ErrPasswordNotComplex
+	}
+
+	if len(account) > 0 && strings.Contains(strings.ToLower(password), strings.ToLower(account)) {
+		return ErrPasswordContainsAcct
+	}
+	return nil
+}
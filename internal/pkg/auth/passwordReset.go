@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"time"
+	"uiassignment/internal/pkg/config"
+)
+
+// How long a password reset token can be redeemed
+var PasswordResetTokenTTL = config.GetDuration("PASSWORD_RESET_TTL", 30*time.Minute)
+
+// Creates a single-use password reset token. Only the hash should be stored.
+func CreatePasswordResetToken() (token string, tokenHash string, expiresAt time.Time, err error) {
+	token, err = GenerateRandomToken(32)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, HashToken(token), time.Now().Add(PasswordResetTokenTTL), nil
+}
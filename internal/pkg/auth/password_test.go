@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters, the tests don't need slow hashes
+func newTestArgon2idHasher() Argon2idHasher {
+	return Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func setDefaultHasher(t *testing.T, hasher PasswordHasher) {
+	previous := DefaultHasher
+	DefaultHasher = hasher
+	t.Cleanup(func() { DefaultHasher = previous })
+}
+
+func TestPasswordHashersRoundTrip(t *testing.T) {
+	for name, hasher := range map[string]PasswordHasher{
+		"argon2id": newTestArgon2idHasher(),
+		"bcrypt":   BcryptHasher{Cost: bcrypt.MinCost},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash("Secret-Passw0rd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hasher.Handles(hash) || hasher.NeedsRehash(hash) {
+				t.Errorf("hasher doesn't recognize its hash %q", hash)
+			}
+			if matched, err := hasher.Verify(hash, "Secret-Passw0rd"); !matched || err != nil {
+				t.Errorf("password doesn't match its hash: %v", err)
+			}
+			if matched, err := hasher.Verify(hash, "Secret-Passw0rd "); matched || err != nil {
+				t.Errorf("other password matched: %v", err)
+			}
+			// Salted, so the same password hashes differently
+			if other, _ := hasher.Hash("Secret-Passw0rd"); other == hash {
+				t.Error("hashes aren't salted")
+			}
+		})
+	}
+}
+
+func TestArgon2idHashEncoding(t *testing.T) {
+	hash, err := newTestArgon2idHasher().Hash("Secret-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("got hash %q", hash)
+	}
+}
+
+// Malformed hashes are rejected with an error instead of panicking in argon2.
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	hasher := newTestArgon2idHasher()
+	hash, err := hasher.Hash("Secret-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hash, "$")
+	salt, key := parts[4], parts[5]
+	withParams := func(params string) string {
+		return strings.Join([]string{"", "argon2id", "v=19", params, salt, key}, "$")
+	}
+
+	for name, malformed := range map[string]string{
+		"truncated":          hash[:len(hash)-len(key)-1],
+		"truncated key":      hash[:len(hash)-len(key)],
+		"no salt":            strings.Join([]string{"", "argon2id", "v=19", "m=64,t=1,p=1", "", key}, "$"),
+		"other algorithm":    strings.Replace(hash, "$argon2id$", "$argon2i$", 1),
+		"other version":      strings.Replace(hash, "$v=19$", "$v=16$", 1),
+		"no version":         strings.Replace(hash, "$v=19$", "$$", 1),
+		"zero parallelism":   withParams("m=64,t=1,p=0"),
+		"zero iterations":    withParams("m=64,t=0,p=1"),
+		"zero memory":        withParams("m=0,t=1,p=1"),
+		"huge memory":        withParams("m=4294967295,t=1,p=1"),
+		"parallelism > 255":  withParams("m=64,t=1,p=256"),
+		"negative":           withParams("m=64,t=-1,p=1"),
+		"missing parameter":  withParams("m=64,t=1"),
+		"trailing garbage":   withParams("m=64,t=1,p=1,x=2"),
+		"invalid salt":       strings.Join([]string{"", "argon2id", "v=19", "m=64,t=1,p=1", "!!", key}, "$"),
+		"invalid key base64": strings.Join([]string{"", "argon2id", "v=19", "m=64,t=1,p=1", salt, key + "="}, "$"),
+	} {
+		if matched, err := hasher.Verify(malformed, "Secret-Passw0rd"); matched || err == nil {
+			t.Errorf("%s %q got matched %t, error %v", name, malformed, matched, err)
+		}
+		if !hasher.NeedsRehash(malformed) {
+			t.Errorf("%s %q doesn't need a rehash", name, malformed)
+		}
+		if IsPasswordMatched(malformed, "Secret-Passw0rd") {
+			t.Errorf("%s %q matched", name, malformed)
+		}
+	}
+}
+
+func TestPasswordNeedsRehashOnParameterChange(t *testing.T) {
+	hasher := newTestArgon2idHasher()
+	setDefaultHasher(t, hasher)
+	hash, err := hasher.Hash("Secret-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if PasswordNeedsRehash(hash) {
+		t.Fatal("hash with the current parameters needs a rehash")
+	}
+
+	for name, changed := range map[string]func(*Argon2idHasher){
+		"memory":      func(h *Argon2idHasher) { h.Memory *= 2 },
+		"iterations":  func(h *Argon2idHasher) { h.Iterations++ },
+		"parallelism": func(h *Argon2idHasher) { h.Parallelism++ },
+		"salt length": func(h *Argon2idHasher) { h.SaltLength = 32 },
+		"key length":  func(h *Argon2idHasher) { h.KeyLength = 64 },
+	} {
+		updated := hasher
+		changed(&updated)
+		setDefaultHasher(t, updated)
+		if !PasswordNeedsRehash(hash) {
+			t.Errorf("changed %s doesn't need a rehash", name)
+		}
+	}
+
+	bcryptHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("Secret-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, stronger := BcryptHasher{Cost: bcrypt.MinCost}, BcryptHasher{Cost: bcrypt.MinCost + 1}
+	if current.NeedsRehash(bcryptHash) || !stronger.NeedsRehash(bcryptHash) {
+		t.Error("bcrypt rehash doesn't follow the cost")
+	}
+}
+
+// bcrypt would hash with its default cost below its minimum, then every login
+// would rehash.
+func TestBcryptCostIsClamped(t *testing.T) {
+	hasher := BcryptHasher{Cost: 1}
+	hash, err := hasher.Hash("Secret-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != bcrypt.MinCost {
+		t.Errorf("got cost %d, want %d", cost, bcrypt.MinCost)
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("hash with the clamped cost needs a rehash")
+	}
+}
+
+// Passwords hashed with bcrypt keep working after switching to Argon2id and
+// are upgraded on the next login.
+func TestBcryptToArgon2idMigration(t *testing.T) {
+	setDefaultHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+	stored, err := EncryptPassword("Secret-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, "$2a$") || PasswordNeedsRehash(stored) {
+		t.Fatalf("got bcrypt hash %q", stored)
+	}
+
+	setDefaultHasher(t, newTestArgon2idHasher())
+	if !IsPasswordMatched(stored, "Secret-Passw0rd") {
+		t.Fatal("bcrypt hash doesn't match after switching to argon2id")
+	}
+	if !PasswordNeedsRehash(stored) {
+		t.Fatal("bcrypt hash isn't upgraded")
+	}
+
+	// What the login does with the plain password
+	upgraded, err := EncryptPassword("Secret-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(upgraded, "$argon2id$") || PasswordNeedsRehash(upgraded) || !IsPasswordMatched(upgraded, "Secret-Passw0rd") {
+		t.Errorf("got upgraded hash %q", upgraded)
+	}
+	if IsPasswordMatched(upgraded, "wrong") {
+		t.Error("upgraded hash matches another password")
+	}
+}
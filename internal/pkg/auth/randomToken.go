@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Generates a URL safe random token from the given number of random bytes.
+func GenerateRandomToken(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// Hashes a high entropy token for storage. Tokens are random so no salt or
+// key stretching is needed, which keeps them searchable by hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Identifies a token in logs without revealing it: the first 12 hex digits of
+// its hash.
+func TokenFingerprint(token string) string {
+	return HashToken(token)[:12]
+}
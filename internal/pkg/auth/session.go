@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/config"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Prefix of session tokens, which tells them apart from access tokens and API keys
+const SessionTokenPrefix = "uis_"
+
+// Kinds of sessions
+const (
+	// Browser session kept in a cookie
+	SessionKindCookie = "cookie"
+	// Login which issued an access token
+	SessionKindToken = "token"
+)
+
+var (
+	// Name of the cookie carrying the session token
+	SessionCookieName = config.Get("SESSION_COOKIE", "uia_session")
+	// Name of the cookie carrying the CSRF token, readable by scripts
+	CSRFCookieName = config.Get("CSRF_COOKIE", "uia_csrf")
+	// Whether session cookies are only sent over HTTPS
+	SessionCookieSecure = config.Get("SESSION_COOKIE_SECURE", "true") == "true"
+	// A session expires after being idle this long
+	SessionIdleTTL = config.GetDuration("SESSION_IDLE_TTL", 2*time.Hour)
+	// A session expires this long after login however active it is
+	SessionMaxTTL = config.GetDuration("SESSION_MAX_TTL", 7*24*time.Hour)
+	// Role allowed to manage sessions of every user
+	AdminRole = config.Get("ADMIN_ROLE", "admin")
+)
+
+// Generates the ID of a new session.
+func NewSessionID() (string, error) {
+	return GenerateRandomToken(9)
+}
+
+// Generates a new session token. Only its hash is stored, the token is kept in
+// an HttpOnly cookie. The ID identifies the session for listing and revoking.
+func CreateSessionToken() (sessionToken string, sessionTokenHash string, id string, err error) {
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	id, err = NewSessionID()
+	if err != nil {
+		return "", "", "", err
+	}
+	sessionToken = SessionTokenPrefix + secret
+	return sessionToken, HashToken(sessionToken), id, nil
+}
+
+// Reports whether the token looks like a session token.
+func IsSessionToken(token string) bool {
+	return strings.HasPrefix(token, SessionTokenPrefix)
+}
+
+// Claims of a request authenticated by a session. Sessions are first-party and
+// count as issued at login, so revoking the account's tokens ends them as well.
+func NewSessionClaims(account string, sessionID string, createdAt time.Time) *Claims {
+	return &Claims{
+		Account:   account,
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt: createdAt.Unix(),
+		},
+	}
+}
+
+// CSRF token of a session. It's derived from the session ID with a server key,
+// so it needs no storage and can't be computed by other sites.
+func CSRFTokenFor(sessionID string) string {
+	mac := hmac.New(sha256.New, signingKeyFor("csrf"))
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Checks a CSRF token sent along with a request of the session.
+func IsCSRFTokenValid(sessionID string, csrfToken string) bool {
+	return hmac.Equal([]byte(CSRFTokenFor(sessionID)), []byte(csrfToken))
+}
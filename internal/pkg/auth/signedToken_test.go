@@ -0,0 +1,16 @@
+package auth
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeriveID(t *testing.T) {
+	id := DeriveID("purpose", "alice")
+	if len(id) != 32 || !bytes.Equal(id, DeriveID("purpose", "alice")) {
+		t.Errorf("got ID %x", id)
+	}
+	if bytes.Equal(id, DeriveID("purpose", "bob")) || bytes.Equal(id, DeriveID("other", "alice")) {
+		t.Error("ID doesn't depend on the value and purpose")
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/config"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// RFC 6238 parameters, the defaults understood by all authenticator apps
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// Number of periods before and after the current one accepted for clock skew
+	totpSkew = 1
+)
+
+var (
+	totpIssuer = config.Get("TOTP_ISSUER", "uiassignment")
+	// How long the second login step can be completed after the password check
+	TwoFactorChallengeTTL = config.GetDuration("TOTP_CHALLENGE_TTL", 5*time.Minute)
+	// Consecutive failed second factor verifications before locking it
+	TOTPMaxFailedAttempts = config.GetInt("TOTP_MAX_FAILED_ATTEMPTS", 5)
+	// How long second factor verification stays locked
+	TOTPLockout = config.GetDuration("TOTP_LOCKOUT", 15*time.Minute)
+)
+
+var ErrInvalidTwoFactorChallenge = errors.New("invalid or expired two-factor challenge")
+
+// Generates a random TOTP secret, base32 encoded without padding.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// Builds the otpauth:// URI for enrolling the secret in an authenticator app.
+func TOTPProvisioningURI(account string, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Validates the code against the secret at the given time. Returns the time
+// step the code belongs to, which must be greater than lastUsedStep so a code
+// can't be replayed.
+func ValidateTOTPCode(secret string, code string, at time.Time, lastUsedStep int64) (step int64, ok bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		log.Println(err.Error())
+		return 0, false
+	}
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := at.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// HOTP value(RFC 4226) of the given counter.
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus())
+}
+
+// 10^totpDigits, keeps the last totpDigits digits of a value
+func totpModulus() uint32 {
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+	return modulus
+}
+
+// Returns the code of the secret at the given time, e.g. for clients of the
+// API acting as authenticator app.
+func GenerateTOTPCode(secret string, at time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, at.Unix()/totpPeriod), nil
+}
+
+// Generates one-time recovery codes. Only their hashes(HashToken) should be stored.
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, count)
+	for i := range codes {
+		bytes := make([]byte, 10)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))
+		codes[i] = code[:8] + "-" + code[8:]
+	}
+	return codes, nil
+}
+
+// Normalizes user input of a recovery code before hashing.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == 16 {
+		code = code[:8] + "-" + code[8:]
+	}
+	return code
+}
+
+// Claims of the token proving the first login step(password) succeeded
+type TwoFactorChallengeClaims struct {
+	Account string `json:"acct"`
+	jwt.StandardClaims
+}
+
+const twoFactorChallengePurpose = "two-factor-challenge"
+
+// Creates a short-lived challenge token to be exchanged for an access token
+// together with a second factor.
+func CreateTwoFactorChallenge(account string) (challengeToken string, expiresAt int64, err error) {
+	expiresAt = time.Now().Add(TwoFactorChallengeTTL).Unix()
+	challengeToken, err = CreateSignedToken(twoFactorChallengePurpose, TwoFactorChallengeClaims{
+		Account: account,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expiresAt,
+			IssuedAt:  time.Now().Unix(),
+		},
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return
+}
+
+// Validates a challenge token and returns the account which passed the first step.
+func ParseTwoFactorChallenge(challengeToken string) (account string, err error) {
+	claims := &TwoFactorChallengeClaims{}
+	if err := ParseSignedToken(twoFactorChallengePurpose, challengeToken, claims); err != nil {
+		return "", ErrInvalidTwoFactorChallenge
+	}
+	return claims.Account, nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret of the RFC 6238 appendix B test vectors for SHA-1
+const rfc6238Secret = "12345678901234567890"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes, 6 digit codes are their last digits
+	for unixTime, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		if got := totpCode([]byte(rfc6238Secret), unixTime/totpPeriod); got != want[len(want)-totpDigits:] {
+			t.Errorf("code at %d = %s, want %s", unixTime, got, want[len(want)-totpDigits:])
+		}
+	}
+}
+
+func TestGenerateTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(rfc6238Secret))
+	code, err := GenerateTOTPCode(strings.ToLower(secret), time.Unix(1111111109, 0))
+	if err != nil || code != "081804" {
+		t.Errorf("got code %q, error %v", code, err)
+	}
+	if _, err := GenerateTOTPCode("not base32!", time.Now()); err == nil {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func TestValidateTOTPCodeWindow(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	current := now.Unix() / totpPeriod
+	codeAt := func(step int64) string {
+		code, err := GenerateTOTPCode(secret, time.Unix(step*totpPeriod, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	// One step of clock skew either way
+	for offset, accepted := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		step, ok := ValidateTOTPCode(secret, codeAt(current+offset), now, 0)
+		if ok != accepted || (ok && step != current+offset) {
+			t.Errorf("code of step %+d got step %d, accepted %t", offset, step-current, ok)
+		}
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := ValidateTOTPCode(secret, code, now, 0); ok {
+			t.Errorf("code %q was accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTPCode("not base32!", codeAt(current), now, 0); ok {
+		t.Error("code of an invalid secret was accepted")
+	}
+}
+
+// A code can't be used again, nor can an older one after a newer was used.
+func TestValidateTOTPCodeRejectsReplays(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	current := now.Unix() / totpPeriod
+	code, _ := GenerateTOTPCode(secret, now)
+	previous, _ := GenerateTOTPCode(secret, now.Add(-totpPeriod*time.Second))
+	next, _ := GenerateTOTPCode(secret, now.Add(totpPeriod*time.Second))
+
+	step, ok := ValidateTOTPCode(secret, code, now, 0)
+	if !ok || step != current {
+		t.Fatalf("got step %d, accepted %t", step, ok)
+	}
+	if _, ok := ValidateTOTPCode(secret, code, now, step); ok {
+		t.Error("used code was accepted again")
+	}
+	if _, ok := ValidateTOTPCode(secret, previous, now, step); ok {
+		t.Error("code older than the used one was accepted")
+	}
+	if _, ok := ValidateTOTPCode(secret, next, now, step); !ok {
+		t.Error("code newer than the used one was rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 17 || code[8] != '-' || seen[code] {
+			t.Errorf("got code %q", code)
+		}
+		seen[code] = true
+		// Users may type them in upper case, with spaces or without the dash
+		for _, input := range []string{strings.ToUpper(code), " " + code + " ", strings.Replace(code, "-", "", 1)} {
+			if NormalizeRecoveryCode(input) != code {
+				t.Errorf("%q isn't normalized to %q", input, code)
+			}
+		}
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(TOTPProvisioningURI("alice", "SECRET"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || query.Get("secret") != "SECRET" ||
+		query.Get("digits") != "6" || query.Get("period") != "30" || query.Get("algorithm") != "SHA1" {
+		t.Errorf("got URI %s", uri)
+	}
+}
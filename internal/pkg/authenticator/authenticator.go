@@ -0,0 +1,88 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"uiassignment/internal/pkg/config"
+
+	"gorm.io/gorm"
+)
+
+var (
+	authenticatorType = config.Get("AUTHENTICATOR", "local")
+	ldapURL           = config.Get("LDAP_URL", "ldap://localhost:389")
+	ldapStartTLS      = config.Get("LDAP_START_TLS", "false") == "true"
+	ldapBindDN        = config.Get("LDAP_BIND_DN", "")
+	ldapBindPassword  = config.Get("LDAP_BIND_PWD", "")
+	ldapBaseDN        = config.Get("LDAP_BASE_DN", "")
+	ldapUserFilter    = config.Get("LDAP_USER_FILTER", "(&(objectClass=person)(uid={username}))")
+	ldapAccountAttr   = config.Get("LDAP_ACCOUNT_ATTRIBUTE", "uid")
+	ldapGroupRoles    = config.Get("LDAP_GROUP_ROLES", "")
+	ldapAutoProvision = config.Get("LDAP_AUTO_PROVISION", "false") == "true"
+)
+
+var (
+	// The identifier is known but the password is wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// No user can be found by the identifier
+	ErrUserNotFound = errors.New("user not found")
+	// The user matches a local account which belongs to another user store
+	ErrAccountNotLinked = errors.New("local account isn't linked to the directory")
+)
+
+// Identity is a user whose credentials have been verified.
+type Identity struct {
+	// Local account of the user
+	Account string
+	// Roles of the user, e.g. mapped from directory groups
+	Roles []string
+}
+
+// Authenticator verifies login credentials against a user store.
+type Authenticator interface {
+	// Verifies the password of the user with the given account or email.
+	// On ErrInvalidCredentials the identity of the known user is returned as well.
+	Authenticate(ctx context.Context, identifier string, password string) (*Identity, error)
+}
+
+// Creates the authenticator selected by the AUTHENTICATOR env variable(local or ldap).
+func New(db *gorm.DB) Authenticator {
+	switch authenticatorType {
+	case "ldap":
+		return NewLDAPAuthenticator(db, LDAPConfig{
+			URL:           ldapURL,
+			StartTLS:      ldapStartTLS,
+			BindDN:        ldapBindDN,
+			BindPassword:  ldapBindPassword,
+			BaseDN:        ldapBaseDN,
+			UserFilter:    ldapUserFilter,
+			AccountAttr:   ldapAccountAttr,
+			GroupRoles:    parseGroupRoles(ldapGroupRoles),
+			AutoProvision: ldapAutoProvision,
+		})
+	case "local":
+		return NewLocalAuthenticator(db)
+	default:
+		log.Printf("Unknown AUTHENTICATOR %q, falling back to local", authenticatorType)
+		return NewLocalAuthenticator(db)
+	}
+}
+
+// Parses "groupDN=role;groupDN=role" mappings. Group DNs are compared case-insensitively.
+func parseGroupRoles(value string) map[string]string {
+	groupRoles := make(map[string]string)
+	for _, mapping := range strings.Split(value, ";") {
+		separator := strings.LastIndex(mapping, "=")
+		if separator <= 0 {
+			continue
+		}
+		groupDN := strings.ToLower(strings.TrimSpace(mapping[:separator]))
+		role := strings.TrimSpace(mapping[separator+1:])
+		if len(groupDN) > 0 && len(role) > 0 {
+			groupRoles[groupDN] = role
+		}
+	}
+	return groupRoles
+}
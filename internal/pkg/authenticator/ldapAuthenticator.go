@@ -0,0 +1,235 @@
+package authenticator
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+const ldapTimeout = 10 * time.Second
+
+var alphanumericPattern = regexp.MustCompile("^[a-zA-Z0-9]+$")
+
+// LDAPConfig describes how users are looked up in an LDAP directory or Active Directory.
+type LDAPConfig struct {
+	// Server URL, ldap:// or ldaps://
+	URL string
+	// Whether to upgrade an ldap:// connection with StartTLS
+	StartTLS bool
+	// TLS settings for ldaps:// and StartTLS, system defaults if nil
+	TLSConfig *tls.Config
+	// Service account used for searching users, anonymous if empty
+	BindDN string
+	// Password of the service account
+	BindPassword string
+	// Where users are searched
+	BaseDN string
+	// Search filter, {username} is replaced with the escaped login identifier
+	UserFilter string
+	// Attribute holding the local account name, e.g. uid or sAMAccountName
+	AccountAttr string
+	// Roles granted to members of a group, indexed by lowercase group DN
+	GroupRoles map[string]string
+	// Whether directory users without a local account get one on their first login
+	AutoProvision bool
+}
+
+// LDAPAuthenticator verifies passwords by binding as the user found with a
+// search. Every directory user is backed by a local account linked to the
+// directory, whose roles are synced from the user's groups on login.
+type LDAPAuthenticator struct {
+	DB     *gorm.DB
+	Config LDAPConfig
+}
+
+func NewLDAPAuthenticator(db *gorm.DB, config LDAPConfig) LDAPAuthenticator {
+	return LDAPAuthenticator{DB: db, Config: config}
+}
+
+func (a LDAPAuthenticator) Authenticate(ctx context.Context, identifier string, password string) (*Identity, error) {
+	// A simple bind with an empty password is an unauthenticated bind(RFC 4513
+	// section 5.1.2), which many servers accept.
+	if len(identifier) == 0 || len(password) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := a.searchUser(conn, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	account := entry.GetAttributeValue(a.Config.AccountAttr)
+	if !alphanumericPattern.MatchString(account) {
+		return nil, fmt.Errorf("directory user %s has no usable %s", entry.DN, a.Config.AccountAttr)
+	}
+	identity := &Identity{Account: account, Roles: a.rolesOf(entry)}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return identity, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err := a.syncUser(ctx, identity, entry); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (a LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.Config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(a.Config.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if a.Config.StartTLS {
+		tlsConfig := a.Config.TLSConfig
+		if tlsConfig == nil {
+			serverURL, err := url.Parse(a.Config.URL)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			tlsConfig = &tls.Config{ServerName: serverURL.Hostname()}
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if len(a.Config.BindDN) > 0 {
+		if err := conn.Bind(a.Config.BindDN, a.Config.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP service account bind failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// Finds the single directory entry matching the login identifier.
+func (a LDAPAuthenticator) searchUser(conn *ldap.Conn, identifier string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(a.Config.UserFilter, "{username}", ldap.EscapeFilter(identifier))
+	request := ldap.NewSearchRequest(
+		a.Config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout.Seconds()), false,
+		filter,
+		[]string{a.Config.AccountAttr, "cn", "displayName", "mail", "memberOf"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, ErrUserNotFound
+	}
+	if len(result.Entries) > 1 {
+		log.Printf("LDAP filter %s matches more than one user", filter)
+		return nil, ErrUserNotFound
+	}
+	return result.Entries[0], nil
+}
+
+// Maps the groups of the entry to roles, sorted and without duplicates.
+func (a LDAPAuthenticator) rolesOf(entry *ldap.Entry) []string {
+	roleSet := make(map[string]bool)
+	for _, groupDN := range entry.GetAttributeValues("memberOf") {
+		if role, ok := a.Config.GroupRoles[strings.ToLower(groupDN)]; ok {
+			roleSet[role] = true
+		}
+	}
+	roles := []string{}
+	for role := range roleSet {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Updates the roles of the local account, creating the account first if
+// provisioning is enabled. Local accounts which weren't provisioned from the
+// directory are refused, so directory users can't take them over.
+func (a LDAPAuthenticator) syncUser(ctx context.Context, identity *Identity, entry *ldap.Entry) error {
+	roles := strings.Join(identity.Roles, " ")
+
+	var user models.Users
+	result := a.DB.WithContext(ctx).Select("acct", "auth_source").
+		Where("acct = ?", identity.Account).Limit(1).Find(&user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		if !user.IsDirectoryUser() {
+			log.Printf("Directory user %s matches local account %s, which isn't linked to the directory", entry.DN, user.Acct)
+			return ErrAccountNotLinked
+		}
+		return a.DB.WithContext(ctx).Model(&models.Users{}).
+			Where("acct = ? AND auth_source = ?", user.Acct, models.AuthSourceLDAP).UpdateColumn("roles", roles).Error
+	}
+	if !a.Config.AutoProvision {
+		log.Printf("Directory user %s has no local account", entry.DN)
+		return ErrUserNotFound
+	}
+
+	// Provisioned users have no local password, the directory is authoritative
+	user = models.Users{
+		Acct:       identity.Account,
+		FullName:   fullNameOf(entry, identity.Account),
+		Roles:      roles,
+		AuthSource: models.AuthSourceLDAP,
+	}
+	if mail := strings.ToLower(entry.GetAttributeValue("mail")); len(mail) > 0 {
+		// Verified emails are unique, another account may have verified it first
+		var verified int64
+		if result := a.DB.WithContext(ctx).Model(&models.Users{}).
+			Where("email = ? AND email_verified", mail).Count(&verified); result.Error != nil {
+			return result.Error
+		}
+		user.Email = &mail
+		user.EmailVerified = verified == 0
+	}
+	if result := a.DB.WithContext(ctx).Create(&user); result.Error != nil {
+		return result.Error
+	}
+	log.Printf("Provisioned account %s for directory user %s", user.Acct, entry.DN)
+	return nil
+}
+
+func fullNameOf(entry *ldap.Entry, account string) string {
+	fullName := entry.GetAttributeValue("displayName")
+	if len(fullName) == 0 {
+		fullName = entry.GetAttributeValue("cn")
+	}
+	runes := []rune(strings.TrimSpace(fullName))
+	if len(runes) == 0 {
+		return account
+	}
+	if len(runes) > 50 {
+		runes = runes[:50]
+	}
+	return string(runes)
+}
@@ -0,0 +1,270 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/db"
+	"uiassignment/internal/pkg/models"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testServiceDN       = "cn=service,dc=example,dc=com"
+	testServicePassword = "service-secret"
+	testAdminsGroupDN   = "cn=admins,ou=groups,dc=example,dc=com"
+	testStaffGroupDN    = "cn=staff,ou=groups,dc=example,dc=com"
+)
+
+// A user of the fake directory
+type directoryUser struct {
+	password   string
+	attributes map[string][]string
+}
+
+var uidFilterPattern = regexp.MustCompile(`\(uid=([^)]*)\)`)
+
+// Serves simple binds and searches by uid over plain LDAP on a local port,
+// which is all the authenticator needs.
+func startFakeLDAPServer(t *testing.T, users map[string]directoryUser) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	dnOf := func(uid string) string { return fmt.Sprintf("uid=%s,ou=people,dc=example,dc=com", uid) }
+	passwords := map[string]string{testServiceDN: testServicePassword}
+	for uid, user := range users {
+		passwords[dnOf(uid)] = user.password
+	}
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			request, err := ber.ReadPacket(conn)
+			if err != nil || len(request.Children) < 2 {
+				return
+			}
+			messageID := request.Children[0].Value.(int64)
+			operation := request.Children[1]
+
+			var responses []*ber.Packet
+			switch operation.Tag {
+			case ldap.ApplicationBindRequest:
+				dn := operation.Children[1].Value.(string)
+				password := operation.Children[2].Data.String()
+				resultCode := ldap.LDAPResultSuccess
+				if expected, ok := passwords[dn]; !ok || len(password) == 0 || password != expected {
+					resultCode = ldap.LDAPResultInvalidCredentials
+				}
+				responses = append(responses, ldapResult(ldap.ApplicationBindResponse, resultCode))
+			case ldap.ApplicationSearchRequest:
+				filter, err := ldap.DecompileFilter(operation.Children[6])
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if match := uidFilterPattern.FindStringSubmatch(filter); match != nil {
+					if user, ok := users[match[1]]; ok {
+						responses = append(responses, searchResultEntry(dnOf(match[1]), user.attributes))
+					}
+				}
+				responses = append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+			case ldap.ApplicationUnbindRequest:
+				return
+			default:
+				t.Errorf("unexpected LDAP operation %d", operation.Tag)
+				return
+			}
+
+			for _, response := range responses {
+				envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+				envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+				envelope.AppendChild(response)
+				if _, err := conn.Write(envelope.Bytes()); err != nil {
+					return
+				}
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return "ldap://" + listener.Addr().String()
+}
+
+func ldapResult(tag ber.Tag, resultCode int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(resultCode), "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return result
+}
+
+func searchResultEntry(dn string, attributes map[string][]string) *ber.Packet {
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "objectName"))
+	attributeList := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		valueSet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			valueSet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attribute.AppendChild(valueSet)
+		attributeList.AppendChild(attribute)
+	}
+	entry.AppendChild(attributeList)
+	return entry
+}
+
+func newTestLDAPAuthenticator(t *testing.T, testDB *gorm.DB, users map[string]directoryUser) LDAPAuthenticator {
+	return NewLDAPAuthenticator(testDB, LDAPConfig{
+		URL:          startFakeLDAPServer(t, users),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePassword,
+		BaseDN:       "ou=people,dc=example,dc=com",
+		UserFilter:   "(&(objectClass=person)(uid={username}))",
+		AccountAttr:  "uid",
+		GroupRoles: map[string]string{
+			testAdminsGroupDN: "admin",
+			testStaffGroupDN:  "staff",
+		},
+		AutoProvision: true,
+	})
+}
+
+func directoryUserOf(account string, groups ...string) directoryUser {
+	return directoryUser{
+		password: "directory-secret",
+		attributes: map[string][]string{
+			"uid":      {account},
+			"cn":       {"Directory " + account},
+			"mail":     {account + "@example.com"},
+			"memberOf": append(groups, "cn=unmapped,ou=groups,dc=example,dc=com"),
+		},
+	}
+}
+
+// Failed binds don't reach the database, so these run without one.
+func TestLDAPAuthenticatorRejectsWrongPassword(t *testing.T) {
+	authenticator := newTestLDAPAuthenticator(t, nil, map[string]directoryUser{
+		"alice": directoryUserOf("alice", testStaffGroupDN, testAdminsGroupDN, testStaffGroupDN),
+	})
+	ctx := context.Background()
+
+	identity, err := authenticator.Authenticate(ctx, "alice", "wrong")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidCredentials)
+	}
+	if identity.Account != "alice" || !reflect.DeepEqual(identity.Roles, []string{"admin", "staff"}) {
+		t.Errorf("got identity %+v", identity)
+	}
+
+	for _, identifier := range []string{"bob", "alice)(uid=*", ""} {
+		if _, err := authenticator.Authenticate(ctx, identifier, "directory-secret"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("identifier %q got error %v, want %v", identifier, err, ErrUserNotFound)
+		}
+	}
+	// An empty password would be an unauthenticated bind
+	if _, err := authenticator.Authenticate(ctx, "alice", ""); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("empty password got error %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+// Connects to the database set up by `make start_db init_db`. Tests needing
+// it are skipped if it can't be reached.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	testDB, err := gorm.Open(postgres.Open(db.DSN()+" connect_timeout=2"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Skipf("test database isn't available: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := testDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return testDB
+}
+
+func TestLDAPAuthenticatorSyncsLinkedAccountsOnly(t *testing.T) {
+	testDB := openTestDB(t)
+	suffix := time.Now().UnixNano() % 1000000000
+	local := fmt.Sprintf("ldaplocal%d", suffix)
+	linked := fmt.Sprintf("ldaplinked%d", suffix)
+	provisioned := fmt.Sprintf("ldapnew%d", suffix)
+	t.Cleanup(func() {
+		testDB.Where("acct IN ?", []string{local, linked, provisioned}).Delete(&models.Users{})
+	})
+
+	for _, user := range []models.Users{
+		{Acct: local, Password: "local-hash", FullName: "Local", Roles: "staff", AuthSource: models.AuthSourceLocal},
+		{Acct: linked, FullName: "Linked", Roles: "staff", AuthSource: models.AuthSourceLDAP},
+	} {
+		if result := testDB.Create(&user); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	authenticator := newTestLDAPAuthenticator(t, testDB, map[string]directoryUser{
+		local:       directoryUserOf(local, testAdminsGroupDN),
+		linked:      directoryUserOf(linked, testAdminsGroupDN),
+		provisioned: directoryUserOf(provisioned, testAdminsGroupDN),
+	})
+	ctx := context.Background()
+
+	userOf := func(account string) models.Users {
+		var user models.Users
+		if result := testDB.Where("acct = ?", account).First(&user); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		return user
+	}
+
+	// A directory user can't take over a local account, nor change its roles
+	if _, err := authenticator.Authenticate(ctx, local, "directory-secret"); !errors.Is(err, ErrAccountNotLinked) {
+		t.Errorf("got error %v, want %v", err, ErrAccountNotLinked)
+	}
+	if user := userOf(local); user.Roles != "staff" || user.Password != "local-hash" || user.IsDirectoryUser() {
+		t.Errorf("local account was changed: %+v", user)
+	}
+
+	if _, err := authenticator.Authenticate(ctx, linked, "directory-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if user := userOf(linked); user.Roles != "admin" {
+		t.Errorf("got roles %q of linked account, want admin", user.Roles)
+	}
+
+	if _, err := authenticator.Authenticate(ctx, provisioned, "directory-secret"); err != nil {
+		t.Fatal(err)
+	}
+	user := userOf(provisioned)
+	if !user.IsDirectoryUser() || user.Roles != "admin" || len(user.Password) > 0 ||
+		user.Email == nil || *user.Email != provisioned+"@example.com" || !user.EmailVerified {
+		t.Errorf("got provisioned account %+v", user)
+	}
+}
@@ -0,0 +1,60 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+
+	"gorm.io/gorm"
+)
+
+// LocalAuthenticator verifies passwords against the hashes in the users table.
+type LocalAuthenticator struct {
+	DB *gorm.DB
+}
+
+func NewLocalAuthenticator(db *gorm.DB) LocalAuthenticator {
+	return LocalAuthenticator{DB: db}
+}
+
+func (a LocalAuthenticator) Authenticate(ctx context.Context, identifier string, password string) (*Identity, error) {
+	// Accounts are alphanumeric, so an identifier with @ can only be an email
+	var user models.Users
+	query := a.DB.WithContext(ctx).Where("acct = ?", identifier)
+	if strings.Contains(identifier, "@") {
+		query = a.DB.WithContext(ctx).Where("email = ? AND email_verified", strings.ToLower(identifier))
+	}
+	if result := query.First(&user); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, result.Error
+	}
+
+	identity := &Identity{Account: user.Acct, Roles: user.RoleList()}
+	if !auth.IsPasswordMatched(user.Password, password) {
+		return identity, ErrInvalidCredentials
+	}
+
+	// Upgrade outdated hashes while the plain password is at hand
+	if auth.PasswordNeedsRehash(user.Password) {
+		a.rehashPassword(ctx, &user, password)
+	}
+	return identity, nil
+}
+
+// Rehash the user's password with the current hasher. Failures are only logged
+// since the old hash is still valid for login.
+func (a LocalAuthenticator) rehashPassword(ctx context.Context, user *models.Users, password string) {
+	encryptedPassword, err := auth.EncryptPassword(password)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if result := a.DB.WithContext(ctx).Model(user).UpdateColumn("pwd", encryptedPassword); result.Error != nil {
+		log.Println(result.Error)
+	}
+}
@@ -0,0 +1,50 @@
+// Package config reads the settings of the service from env variables.
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Returns the value of the env variable, or fallback if it isn't set.
+func Get(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// Returns the env variable as an integer, or fallback if it isn't set or
+// invalid.
+func GetInt(key string, fallback int) int {
+	value, err := strconv.Atoi(Get(key, strconv.Itoa(fallback)))
+	if err != nil {
+		log.Printf("Invalid value for %s, using default %d", key, fallback)
+		return fallback
+	}
+	return value
+}
+
+// Returns the env variable as an integer between min and max, or fallback if
+// it isn't set, invalid or out of range.
+func GetIntInRange(key string, fallback int, min int, max int) int {
+	value := GetInt(key, fallback)
+	if value < min || value > max {
+		log.Printf("Value for %s must be between %d and %d, using default %d", key, min, max, fallback)
+		return fallback
+	}
+	return value
+}
+
+// Returns the env variable as a duration, e.g. 90s or 5m, or fallback if it
+// isn't set or invalid.
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(Get(key, fallback.String()))
+	if err != nil {
+		log.Printf("Invalid value for %s, using default %s", key, fallback)
+		return fallback
+	}
+	return value
+}
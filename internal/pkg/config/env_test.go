@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	t.Setenv("CONFIG_TEST_SET", "value")
+	t.Setenv("CONFIG_TEST_EMPTY", "")
+	if got := Get("CONFIG_TEST_SET", "fallback"); got != "value" {
+		t.Errorf("got %q", got)
+	}
+	// Set but empty is still set
+	if got := Get("CONFIG_TEST_EMPTY", "fallback"); got != "" {
+		t.Errorf("got %q for an empty variable", got)
+	}
+	if got := Get("CONFIG_TEST_UNSET", "fallback"); got != "fallback" {
+		t.Errorf("got %q for an unset variable", got)
+	}
+}
+
+func TestGetInt(t *testing.T) {
+	for value, want := range map[string]int{"12": 12, "-3": -3, "twelve": 7, "1.5": 7} {
+		t.Setenv("CONFIG_TEST_INT", value)
+		if got := GetInt("CONFIG_TEST_INT", 7); got != want {
+			t.Errorf("%q got %d, want %d", value, got, want)
+		}
+	}
+	for value, want := range map[string]int{"1": 1, "255": 255, "0": 4, "256": 4, "x": 4} {
+		t.Setenv("CONFIG_TEST_INT", value)
+		if got := GetIntInRange("CONFIG_TEST_INT", 4, 1, 255); got != want {
+			t.Errorf("%q in range got %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestGetDuration(t *testing.T) {
+	for value, want := range map[string]time.Duration{"90s": 90 * time.Second, "5m": 5 * time.Minute, "5": time.Hour} {
+		t.Setenv("CONFIG_TEST_DURATION", value)
+		if got := GetDuration("CONFIG_TEST_DURATION", time.Hour); got != want {
+			t.Errorf("%q got %s, want %s", value, got, want)
+		}
+	}
+}
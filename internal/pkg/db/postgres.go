@@ -4,27 +4,20 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"time"
+	"uiassignment/internal/pkg/config"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var (
-	dbHost     = getEnv("POSTGRES_HOST", "postgresql")
-	dbPort     = getEnv("POSTGRES_PORT", "5432")
-	dbUser     = getEnv("POSTGRES_USER", "ui_test")
-	dbPassword = getEnv("POSTGRES_PWD", "uiPassword5678")
+	dbHost     = config.Get("POSTGRES_HOST", "postgresql")
+	dbPort     = config.Get("POSTGRES_PORT", "5432")
+	dbUser     = config.Get("POSTGRES_USER", "ui_test")
+	dbPassword = config.Get("POSTGRES_PWD", "uiPassword5678")
 )
 
-func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
-	}
-	return fallback
-}
-
 // swagger:db Pagination
 // @Description JSON response body to hold paginated data
 type Pagination struct {
@@ -41,10 +34,15 @@ type Pagination struct {
 	Rows interface{} `json:"rows"`
 }
 
-func Init() *gorm.DB {
-	dsn := fmt.Sprintf(
+// Connection string of the database, for connections outside of GORM.
+func DSN() string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=ui_test  sslmode=disable",
 		dbHost, dbPort, dbUser, dbPassword)
+}
+
+func Init() *gorm.DB {
+	dsn := DSN()
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 
 	retries := 5
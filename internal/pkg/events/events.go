@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+	"uiassignment/internal/pkg/auth"
+)
+
+// Event types
+const (
+	UserCreated     = "user.created"
+	UserUpdated     = "user.updated"
+	UserDeleted     = "user.deleted"
+	UserLoginFailed = "user.login_failed"
+)
+
+// Types lists every event type, e.g. for validating subscriptions.
+var Types = []string{UserCreated, UserUpdated, UserDeleted, UserLoginFailed}
+
+// Event is something that happened to a user, delivered to other systems.
+type Event struct {
+	// Event ID, unique across all events
+	ID string `json:"id"`
+	// Event type
+	Type string `json:"type"`
+	// Account the event is about
+	Account string `json:"account"`
+	// The time when the event happened
+	OccurredAt time.Time `json:"occurredAt"`
+	// Type specific details
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Details of user.created and user.updated events
+type UserData struct {
+	FullName string `json:"fullName,omitempty"`
+	// Whether the email address changed, the address itself isn't shared
+	EmailChanged bool `json:"emailChanged,omitempty"`
+}
+
+// Details of user.login_failed events
+type LoginFailedData struct {
+	RemoteAddr string `json:"remoteAddr"`
+}
+
+// New creates an event of the given type about the account.
+func New(eventType string, account string, data interface{}) (Event, error) {
+	id, err := auth.GenerateRandomToken(12)
+	if err != nil {
+		return Event{}, err
+	}
+	event := Event{
+		ID:         id,
+		Type:       eventType,
+		Account:    account,
+		OccurredAt: time.Now().UTC(),
+	}
+	if data != nil {
+		if event.Data, err = json.Marshal(data); err != nil {
+			return Event{}, err
+		}
+	}
+	return event, nil
+}
+
+// Handler reacts to published events. An error makes the event be published
+// again later, so handlers must tolerate duplicates.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus passes relayed events on to every subscribed handler, e.g. webhooks and
+// the websocket hub. Events are written to the outbox instead of being
+// published directly, see the outbox package.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds a handler for all events published from then on.
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish passes the event to every subscribed handler and returns the first
+// error any of them returned.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var firstErr error
+	for _, handler := range b.handlers {
+		if err := handler(ctx, event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -6,15 +6,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"uiassignment/internal/pkg/audit"
 	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/authenticator"
+	"uiassignment/internal/pkg/events"
 	"uiassignment/internal/pkg/models"
-
-	"gorm.io/gorm"
+	"uiassignment/internal/pkg/websocket"
 )
 
 // swagger:handlers createAccessTokenRequest
 type createAccessTokenRequest struct {
-	// User account
+	// User account or verified email address
 	// example: myAccount100
 	// required: true
 	Acct string `json:"account"`
@@ -32,13 +34,26 @@ type createAccessTokenResponse struct {
 	ExpiresAt int64 `json:"ExpiresAt"`
 }
 
+// swagger:handlers twoFactorChallengeResponse
+type twoFactorChallengeResponse struct {
+	// Challenge token to be exchanged with a second factor code at /v1/accessToken/twoFactor
+	ChallengeToken string `json:"ChallengeToken"`
+	// Unix timestamp of when the challenge expires
+	ExpiresAt int64 `json:"ExpiresAt"`
+}
+
 // CreateAccessTokenHandler godoc
-// @Description Create user access token
+// @Description Create user access token. Accounts with two-factor authentication get a challenge token instead,
+// @Description which has to be completed at /v1/accessToken/twoFactor. With ?session=cookie a browser session
+// @Description is started instead of returning a token, see /v1/sessions.
 // @Tags accessToken
 // @Produce application/json
 // @Param Body body createAccessTokenRequest true "User login credentials"
+// @Param session query string false "cookie for starting a browser session"
 // @Success 200 {object} createAccessTokenResponse
+// @Success 202 {object} twoFactorChallengeResponse "Second factor required"
 // @Failure 400 "Invalid user account credentials"
+// @Failure 403 {object} CommonResponse "Directory user matches a local account which isn't linked to the directory"
 // @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
 // @Router /v1/accessToken [post]
 func (h handler) CreateAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -50,25 +65,96 @@ func (h handler) CreateAccessTokenHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var user models.Users
-	if result := h.DB.Where("acct = ?", catRequest.Acct).First(&user); result.Error != nil {
-		log.Println(result.Error)
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	identity, err := h.Authenticator.Authenticate(r.Context(), catRequest.Acct, catRequest.Password)
+	if err != nil {
+		log.Println(err.Error())
+		switch {
+		case errors.Is(err, authenticator.ErrInvalidCredentials):
+			notificationMsg := fmt.Sprintf("Login attempt failed for account: %s", identity.Account)
+			if err := h.Hub.SendToUser(r.Context(), identity.Account, websocket.NewEvent(websocket.EventLoginFailed, identity.Account, notificationMsg)); err != nil {
+				log.Println(err.Error())
+			}
+			if err := writeEvent(h.DB, events.UserLoginFailed, identity.Account, events.LoginFailedData{RemoteAddr: audit.RemoteIP(r)}); err != nil {
+				log.Println(err.Error())
+			}
 			w.WriteHeader(http.StatusBadRequest)
-		} else {
+		case errors.Is(err, authenticator.ErrUserNotFound):
+			w.WriteHeader(http.StatusBadRequest)
+		case errors.Is(err, authenticator.ErrAccountNotLinked):
+			writeErrorMessage(w, http.StatusForbidden, err.Error())
+		default:
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
 
-	if !auth.IsPasswordMatched(user.Password, catRequest.Password) {
-		notificationMsg := fmt.Sprintf("Login attempt failed for account: %s", catRequest.Acct)
-		h.Hub.BroadcastMessage(notificationMsg)
-		w.WriteHeader(http.StatusBadRequest)
+	h.writeLoginResponse(w, r, identity.Account, isCookieSessionRequested(r))
+}
+
+// Responds to a successful first login step with an access token, or a browser
+// session if cookieSession is set, or with a two-factor challenge if the account
+// has TOTP enabled.
+func (h handler) writeLoginResponse(w http.ResponseWriter, r *http.Request, account string, cookieSession bool) {
+	var totpEnabled int64
+	if result := h.DB.Model(&models.TotpCredentials{}).
+		Where(&models.TotpCredentials{Acct: account, Enabled: true}).Count(&totpEnabled); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if totpEnabled > 0 {
+		h.writeTwoFactorChallenge(w, account)
+		return
+	}
+
+	h.writeAccessToken(w, r, account, cookieSession)
+}
+
+// Responds with a challenge token for the second login step.
+func (h handler) writeTwoFactorChallenge(w http.ResponseWriter, account string) {
+	challengeToken, expiresAt, err := auth.CreateTwoFactorChallenge(account)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	accessToken, expiresAt, err := auth.CreateAccessTokenForUser(user.Acct)
+	var tfcResponse twoFactorChallengeResponse
+	tfcResponse.ChallengeToken = challengeToken
+	tfcResponse.ExpiresAt = expiresAt
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(tfcResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Responds with a new access token for the given account. The login is
+// recorded as a session, which the token belongs to. With cookieSession a
+// browser session is started instead.
+func (h handler) writeAccessToken(w http.ResponseWriter, r *http.Request, account string, cookieSession bool) {
+	if cookieSession {
+		h.writeCookieSession(w, r, account, http.StatusOK)
+		return
+	}
+
+	session, err := newSession(r, account, auth.SessionKindToken, auth.AccessTokenTTL, auth.AccessTokenTTL)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result := h.DB.Create(&session); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, expiresAt, err := auth.CreateAccessTokenForUser(account, session.ID)
 	if err != nil {
 		log.Println(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -88,3 +174,62 @@ func (h handler) CreateAccessTokenHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 }
+
+// swagger:handlers completeTwoFactorLoginRequest
+type completeTwoFactorLoginRequest struct {
+	// Challenge token returned by /v1/accessToken
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	// Current TOTP code or an unused recovery code
+	Code string `json:"code" validate:"required"`
+}
+
+// CompleteTwoFactorLoginHandler godoc
+// @Description Exchange a two-factor challenge and a TOTP or recovery code for a user access token, or for
+// @Description a browser session with ?session=cookie
+// @Tags accessToken
+// @Produce application/json
+// @Param Body body completeTwoFactorLoginRequest true "Challenge token and second factor code"
+// @Param session query string false "cookie for starting a browser session"
+// @Success 200 {object} createAccessTokenResponse
+// @Failure 400 {object} CommonResponse "Invalid request body or invalid challenge"
+// @Failure 403 {object} CommonResponse "Invalid code"
+// @Failure 429 {object} CommonResponse "Too many failed attempts"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/accessToken/twoFactor [post]
+func (h handler) CompleteTwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var ctflRequest completeTwoFactorLoginRequest
+
+	err := json.NewDecoder(r.Body).Decode(&ctflRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(ctflRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	account, err := auth.ParseTwoFactorChallenge(ctflRequest.ChallengeToken)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.verifySecondFactor(account, ctflRequest.Code); err != nil {
+		if errors.Is(err, errInvalidTotpCode) {
+			notificationMsg := fmt.Sprintf("Login attempt failed for account: %s", account)
+			if err := h.Hub.SendToUser(r.Context(), account, websocket.NewEvent(websocket.EventLoginFailed, account, notificationMsg)); err != nil {
+				log.Println(err.Error())
+			}
+			if err := writeEvent(h.DB, events.UserLoginFailed, account, events.LoginFailedData{RemoteAddr: audit.RemoteIP(r)}); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		h.writeSecondFactorError(w, err)
+		return
+	}
+
+	h.writeAccessToken(w, r, account, isCookieSessionRequested(r))
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// swagger:handlers createApiKeyRequest
+// @Description JSON request body for creating an API key
+type createApiKeyRequest struct {
+	// Name of the key(Length: min=1, max=50)
+	Name string `json:"name" validate:"required,min=1,max=50"`
+	// Scopes granted to the key(users:read, users:write), defaults to all scopes
+	Scopes []string `json:"scopes" validate:"omitempty,dive,oneof=users:read users:write"`
+	// RFC 3339 time when the key expires, never if omitted
+	ExpiresAt *time.Time `json:"expiresAt" validate:"omitempty,gt"`
+}
+
+// swagger:handlers apiKeyResponse
+// @Description API key of a user
+type apiKeyResponse struct {
+	// Key ID
+	ID string `json:"id"`
+	// The key, shown only once on creation
+	Key string `json:"key,omitempty"`
+	// Name of the key
+	Name string `json:"name"`
+	// Scopes granted to the key
+	Scopes []string `json:"scopes"`
+	// The time when the key expires, never if empty
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// The time when the key was last used
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	// The time when the key was created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newApiKeyResponse(key models.ApiKeys) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scopes:     key.ScopeList(),
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+// CreateApiKeyHandler godoc
+// @Description Create an API key for the selected account. The key can be sent in place of an access token
+// @Description in X-Api-Key or "Authorization: Bearer" and is limited to its scopes.
+// @Tags apiKey
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Param Body body createApiKeyRequest true "Key details"
+// @Success 201 {object} apiKeyResponse
+// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/apiKeys [post]
+func (h handler) CreateApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var cakRequest createApiKeyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&cakRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(cakRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+	scopes, _ := auth.ParseScope(strings.Join(cakRequest.Scopes, " "), auth.SupportedScopes)
+
+	apiKey, apiKeyHash, id, err := auth.CreateAPIKey()
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+	account := vars["account"]
+	key := models.ApiKeys{
+		ID:        id,
+		Acct:      account,
+		Name:      cakRequest.Name,
+		KeyHash:   apiKeyHash,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: cakRequest.ExpiresAt,
+	}
+	if result := h.DB.Create(&key); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r, audit.ActionApiKeyCreated, account, id)
+
+	akResponse := newApiKeyResponse(key)
+	akResponse.Key = apiKey
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(akResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListApiKeysHandler godoc
+// @Description List API keys of the selected account
+// @Tags apiKey
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Success 200 {array} apiKeyResponse
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/apiKeys [get]
+func (h handler) ListApiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	var keys []models.ApiKeys
+	if result := h.DB.Where(&models.ApiKeys{Acct: account}).Order("created_at").Find(&keys); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var akResponses = []apiKeyResponse{}
+	for _, key := range keys {
+		akResponses = append(akResponses, newApiKeyResponse(key))
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(akResponses)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// RevokeApiKeyHandler godoc
+// @Description Revoke an API key of the selected account
+// @Tags apiKey
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Param id path string true "Key ID"
+// @Success 200 "Successfully revoked the key"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 404 "Key doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/users/{account}/apiKeys/{id} [delete]
+func (h handler) RevokeApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+	id := vars["id"]
+
+	result := h.DB.Where(&models.ApiKeys{ID: id, Acct: account}).Delete(&models.ApiKeys{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	audit.Log(r, audit.ActionApiKeyRevoked, account, id)
+
+	w.WriteHeader(http.StatusOK)
+}
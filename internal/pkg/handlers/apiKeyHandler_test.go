@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/middlewares"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+func TestCreateApiKeyRequestValidation(t *testing.T) {
+	h := handler{Validator: validator.New()}
+	for body, reason := range map[string]string{
+		`{"scopes":["users:read"]}`:                                          "missing name",
+		`{"name":"ci","scopes":["admin"]}`:                                   "unknown scope",
+		`{"name":"ci","expiresAt":"2000-01-01T00:00:00Z"}`:                   "expiry in the past",
+		`{"name":"` + strings.Repeat("n", 51) + `","scopes":["users:read"]}`: "long name",
+	} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/api/v1/users/alice/apiKeys", strings.NewReader(body)),
+			map[string]string{"account": "alice"})
+		w := httptest.NewRecorder()
+		h.CreateApiKeyHandler(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s got status %d", reason, w.Code)
+		}
+	}
+}
+
+// Keys act for their owner limited to their scopes until they expire or are
+// revoked, and record when they were last used.
+func TestApiKeyScopeAndExpiry(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	account := newTestAccount(t, "apikey")
+	if result := h.DB.Create(&models.Users{Acct: account, Password: "-", FullName: "API Key Test"}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("acct = ?", account).Delete(&models.ApiKeys{})
+		h.DB.Where("acct = ?", account).Delete(&models.Users{})
+	})
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/api/v1/users/"+account+"/apiKeys",
+		strings.NewReader(`{"name":"ci","scopes":["users:read"]}`)), map[string]string{"account": account})
+	w := httptest.NewRecorder()
+	h.CreateApiKeyHandler(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating the key got status %d: %s", w.Code, w.Body.String())
+	}
+	var akResponse apiKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&akResponse); err != nil {
+		t.Fatal(err)
+	}
+	if !auth.IsAPIKey(akResponse.Key) {
+		t.Fatalf("got key %q", akResponse.Key)
+	}
+
+	var stored models.ApiKeys
+	if result := h.DB.Where("id = ?", akResponse.ID).First(&stored); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if stored.KeyHash != auth.HashToken(akResponse.Key) || strings.Contains(stored.KeyHash, akResponse.Key) {
+		t.Error("key isn't stored as its hash")
+	}
+
+	// Routes requiring users:write reject the key, users:read ones accept it
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	for scope, want := range map[string]int{auth.ScopeUsersRead: http.StatusOK, auth.ScopeUsersWrite: http.StatusForbidden} {
+		route := middlewares.AccessTokenCheckMW(h.DB)(middlewares.RequireScopeMW(scope)(ok))
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		r.Header.Set("X-Api-Key", akResponse.Key)
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("route requiring %s got status %d, want %d", scope, w.Code, want)
+		}
+	}
+
+	validate := middlewares.AccessTokenValidator(h.DB)
+	claims, err := validate(akResponse.Key)
+	if err != nil || claims.Account != account || claims.APIKeyID != akResponse.ID || claims.IsFirstParty() {
+		t.Fatalf("got claims %+v, error %v", claims, err)
+	}
+	if result := h.DB.Where("id = ?", akResponse.ID).First(&stored); result.Error != nil || stored.LastUsedAt == nil {
+		t.Errorf("last use wasn't recorded: %v", result.Error)
+	}
+
+	if result := h.DB.Model(&models.ApiKeys{}).Where("id = ?", akResponse.ID).
+		Update("expires_at", time.Now().Add(-time.Second)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if _, err := validate(akResponse.Key); !errors.Is(err, auth.ErrInvalidAccessToken) {
+		t.Errorf("expired key got error %v", err)
+	}
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/api/v1/users/"+account+"/apiKeys/"+akResponse.ID, nil),
+		map[string]string{"account": account, "id": akResponse.ID})
+	w = httptest.NewRecorder()
+	h.RevokeApiKeyHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoking the key got status %d", w.Code)
+	}
+	if _, err := validate(akResponse.Key); !errors.Is(err, auth.ErrInvalidAccessToken) {
+		t.Errorf("revoked key got error %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/websocket"
+
+	"github.com/gorilla/mux"
+)
+
+// swagger:handlers muteUserRequest
+// @Description JSON request body for muting a user in the websocket chat
+type muteUserRequest struct {
+	// How long the user is muted in minutes(min=1, max=43200)
+	Minutes int `json:"minutes" validate:"required,min=1,max=43200"`
+}
+
+// swagger:handlers muteUserResponse
+// @Description End of the mute
+type muteUserResponse struct {
+	// The time when the user can send chat messages again
+	MutedUntil time.Time `json:"mutedUntil"`
+}
+
+// MuteUserHandler godoc
+// @Description Stop the user from sending chat messages over websockets for the given time, replacing any earlier mute.
+// @Description The user can still receive messages and is notified with the event chat.muted.
+// @Tags moderation
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param account path string true "User account"
+// @Param Body body muteUserRequest true "Duration of the mute"
+// @Success 200 {object} muteUserResponse
+// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Failure 503 "The websocket hub is busy or shutting down"
+// @Router /v1/admin/users/{account}/mute [put]
+func (h handler) MuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	var muRequest muteUserRequest
+
+	err := json.NewDecoder(r.Body).Decode(&muRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(muRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	mutedUntil := time.Now().Add(time.Duration(muRequest.Minutes) * time.Minute).UTC()
+	if err := h.Hub.Mute(r.Context(), account, mutedUntil); err != nil {
+		writeHubError(w, err)
+		return
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	audit.Log(r, audit.ActionUserMuted, tokenOwner, account)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(muteUserResponse{MutedUntil: mutedUntil})
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// UnmuteUserHandler godoc
+// @Description Let a muted user send chat messages again. The user is notified with the event chat.unmuted.
+// @Tags moderation
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param account path string true "User account"
+// @Success 200 "Successfully unmuted the user"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Failure 503 "The websocket hub is busy or shutting down"
+// @Router /v1/admin/users/{account}/mute [delete]
+func (h handler) UnmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	if err := h.Hub.Unmute(r.Context(), account); err != nil {
+		writeHubError(w, err)
+		return
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	audit.Log(r, audit.ActionUserUnmuted, tokenOwner, account)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// KickUserHandler godoc
+// @Description Disconnect every websocket connection and event stream of the user. Websockets are closed with
+// @Description 1008(policy violation), event streams end with an error event with the code kicked. The user can connect
+// @Description again unless the sessions are revoked as well.
+// @Tags moderation
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param account path string true "User account"
+// @Success 200 "Successfully kicked the user"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 503 "The websocket hub is busy or shutting down"
+// @Router /v1/admin/users/{account}/kick [post]
+func (h handler) KickUserHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	if err := h.Hub.Kick(r.Context(), account); err != nil {
+		writeHubError(w, err)
+		return
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	audit.Log(r, audit.ActionUserKicked, tokenOwner, account)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Responds to a failed request to the websocket hub.
+func writeHubError(w http.ResponseWriter, err error) {
+	log.Println(err.Error())
+	if errors.Is(err, websocket.ErrHubBusy) || errors.Is(err, websocket.ErrHubClosed) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/db"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/websocket"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+// swagger:handlers createChatRoomRequest
+// @Description JSON request body for creating a chat room
+type createChatRoomRequest struct {
+	// Room name(lowercase letters, digits and ._-, Length: min=1, max=59)
+	Name string `json:"name" validate:"required,min=1,max=59"`
+	// Description of the room(Length: max=255)
+	Description string `json:"description" validate:"max=255"`
+	// Messages older than this many days are deleted, never if omitted
+	RetentionDays *int `json:"retentionDays" validate:"omitempty,gte=1,lte=3650"`
+	// Only this many latest messages are kept, all if omitted
+	MaxMessages *int `json:"maxMessages" validate:"omitempty,gte=1,lte=1000000"`
+}
+
+// swagger:handlers updateChatRoomRequest
+// @Description JSON request body for updating a chat room, omitted retention limits are removed
+type updateChatRoomRequest struct {
+	// Description of the room(Length: max=255)
+	Description string `json:"description" validate:"max=255"`
+	// Messages older than this many days are deleted, never if omitted
+	RetentionDays *int `json:"retentionDays" validate:"omitempty,gte=1,lte=3650"`
+	// Only this many latest messages are kept, all if omitted
+	MaxMessages *int `json:"maxMessages" validate:"omitempty,gte=1,lte=1000000"`
+}
+
+// CreateChatRoomHandler godoc
+// @Description Create a chat room. Websocket clients join it with {"type":"join","topic":"room:{name}"}.
+// @Tags chat
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param Body body createChatRoomRequest true "Room details"
+// @Success 201 {object} models.ChatRooms
+// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 409 {object} CommonResponse "Room already exists"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/chatRooms [post]
+func (h handler) CreateChatRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var ccrRequest createChatRoomRequest
+
+	err := json.NewDecoder(r.Body).Decode(&ccrRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(ccrRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+	if !websocket.IsValidRoomName(ccrRequest.Name) {
+		writeErrorMessage(w, http.StatusBadRequest, "Name must start with a lowercase letter or digit followed by lowercase letters, digits and ._-")
+		return
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	chatRoom := models.ChatRooms{
+		Name:          ccrRequest.Name,
+		Description:   ccrRequest.Description,
+		CreatedBy:     &tokenOwner,
+		RetentionDays: ccrRequest.RetentionDays,
+		MaxMessages:   ccrRequest.MaxMessages,
+		CreatedAt:     time.Now(),
+	}
+	if result := h.DB.Create(&chatRoom); result.Error != nil {
+		log.Println(result.Error)
+		var pgErr *pgconn.PgError
+		if errors.As(result.Error, &pgErr) && pgErr.Code == "23505" {
+			writeErrorMessage(w, http.StatusConflict, "Room already exists")
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(chatRoom)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListChatRoomsHandler godoc
+// @Description List chat rooms
+// @Tags chat
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Success 200 {array} models.ChatRooms
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/chatRooms [get]
+func (h handler) ListChatRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	var chatRooms = []models.ChatRooms{}
+	if result := h.DB.Order("name").Find(&chatRooms); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(chatRooms)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateChatRoomHandler godoc
+// @Description Update the description and retention limits of a chat room. Only its creator or admins can.
+// @Description New limits apply with the next message sent to the room.
+// @Tags chat
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param name path string true "Room name"
+// @Param Body body updateChatRoomRequest true "Room details"
+// @Success 200 {object} models.ChatRooms
+// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to update the room"
+// @Failure 404 "Room doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/chatRooms/{name} [patch]
+func (h handler) UpdateChatRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var ucrRequest updateChatRoomRequest
+
+	err := json.NewDecoder(r.Body).Decode(&ucrRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(ucrRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	chatRoom, ok := h.findChatRoom(w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	isCreator := chatRoom.CreatedBy != nil && *chatRoom.CreatedBy == claims.Account
+	if !isCreator && !claims.HasRole(auth.AdminRole) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	chatRoom.Description = ucrRequest.Description
+	chatRoom.RetentionDays = ucrRequest.RetentionDays
+	chatRoom.MaxMessages = ucrRequest.MaxMessages
+	if result := h.DB.Model(&chatRoom).Select("description", "retention_days", "max_messages").
+		Updates(&chatRoom); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(chatRoom)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListChatMessagesHandler godoc
+// @Description Get the message history of a chat room with paging, latest messages first.
+// @Description Clients load it after joining the room.
+// @Tags chat
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param name path string true "Room name"
+// @Param limit query int false "Max items per page(min=5, max=100, default=5)"
+// @Param page query int false "Requested page"
+// @Success 200 {object} db.Pagination{rows=[]models.ChatMessages}
+// @Failure 400 {object} CommonResponse "Invalid query parameter"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 404 "Room doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/chatRooms/{name}/messages [get]
+func (h handler) ListChatMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	type listChatMessagesQuery struct {
+		Limit int `schema:"limit" validate:"omitempty,gte=5,lte=100"`
+		Page  int `schema:"page" validate:"omitempty,gt=0"`
+	}
+	var lcmQuery listChatMessagesQuery
+
+	err := queryDecoder.Decode(&lcmQuery, r.URL.Query())
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(lcmQuery)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	chatRoom, ok := h.findChatRoom(w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	// Messages past the retention may not have been deleted yet
+	messagesQuery := h.DB.Where("room = ? AND created_at >= ?", chatRoom.Name, chatRoom.RetainedSince(time.Now()))
+
+	pagination := db.Pagination{Limit: lcmQuery.Limit, Page: lcmQuery.Page}
+	var chatMessages = []models.ChatMessages{}
+	if result := messagesQuery.Session(&gorm.Session{}).
+		Scopes(db.Paginate(models.ChatMessages{}, &pagination, messagesQuery.Session(&gorm.Session{}))).
+		Order("created_at desc, id desc").Find(&chatMessages); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	pagination.Rows = chatMessages
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(pagination)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Looks up the chat room, responding with 404 or 500 if it can't be found.
+func (h handler) findChatRoom(w http.ResponseWriter, name string) (models.ChatRooms, bool) {
+	var chatRoom models.ChatRooms
+	if result := h.DB.Where(&models.ChatRooms{Name: name}).First(&chatRoom); result.Error != nil {
+		log.Println(result.Error)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return chatRoom, false
+	}
+	return chatRoom, true
+}
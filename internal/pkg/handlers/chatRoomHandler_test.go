@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/db"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/websocket"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Creates a room no other test run uses, removed with its messages when the
+// test ends.
+func newTestChatRoom(t *testing.T, testDB *gorm.DB, retentionDays *int, maxMessages *int) string {
+	t.Helper()
+	room := newTestAccount(t, "room")
+	if result := testDB.Create(&models.ChatRooms{Name: room, RetentionDays: retentionDays, MaxMessages: maxMessages}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		testDB.Where("name = ?", room).Delete(&models.ChatRooms{})
+	})
+	return room
+}
+
+// Stores chat messages sent at the given times, returning their IDs.
+func saveTestChatMessages(t *testing.T, store websocket.RoomStore, room string, sentAt ...time.Time) []string {
+	t.Helper()
+	var ids []string
+	for i, ts := range sentAt {
+		id, err := auth.GenerateRandomToken(12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := json.Marshal(websocket.ChatPayload{Text: strings.Repeat("m", i+1)})
+		message := websocket.Message{V: websocket.ProtocolVersion, Type: websocket.TypeChat, ID: id, TS: ts, From: "alice", Payload: payload}
+		if err := store.SaveMessage(context.Background(), room, message); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func listTestChatMessages(t *testing.T, h handler, room string, query string) (db.Pagination, []string) {
+	t.Helper()
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v1/chatRooms/"+room+"/messages?"+query, nil),
+		map[string]string{"name": room})
+	w := httptest.NewRecorder()
+	h.ListChatMessagesHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("history got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var chatMessages []models.ChatMessages
+	pagination := db.Pagination{Rows: &chatMessages}
+	if err := json.NewDecoder(w.Body).Decode(&pagination); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, chatMessage := range chatMessages {
+		ids = append(ids, chatMessage.ID)
+	}
+	return pagination, ids
+}
+
+// The history pages through messages latest first.
+func TestChatHistoryPagination(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	room := newTestChatRoom(t, h.DB, nil, nil)
+	start := time.Now().UTC().Add(-time.Hour)
+	var sentAt []time.Time
+	for i := 0; i < 7; i++ {
+		sentAt = append(sentAt, start.Add(time.Duration(i)*time.Minute))
+	}
+	ids := saveTestChatMessages(t, websocket.NewRoomStore(h.DB), room, sentAt...)
+
+	pagination, firstPage := listTestChatMessages(t, h, room, "limit=5&page=1")
+	if pagination.TotalRows != 7 || pagination.TotalPages != 2 {
+		t.Errorf("got %d rows in %d pages", pagination.TotalRows, pagination.TotalPages)
+	}
+	if strings.Join(firstPage, ",") != strings.Join([]string{ids[6], ids[5], ids[4], ids[3], ids[2]}, ",") {
+		t.Errorf("first page %v isn't the latest messages first of %v", firstPage, ids)
+	}
+	if _, secondPage := listTestChatMessages(t, h, room, "limit=5&page=2"); strings.Join(secondPage, ",") != ids[1]+","+ids[0] {
+		t.Errorf("second page %v isn't the oldest messages of %v", secondPage, ids)
+	}
+
+	for _, query := range []string{"limit=4", "limit=101", "page=0"} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v1/chatRooms/"+room+"/messages?"+query, nil),
+			map[string]string{"name": room})
+		w := httptest.NewRecorder()
+		h.ListChatMessagesHandler(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s got status %d", query, w.Code)
+		}
+	}
+}
+
+// Only the latest MaxMessages messages are kept.
+func TestChatRoomMaxMessages(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	maxMessages := 3
+	room := newTestChatRoom(t, h.DB, nil, &maxMessages)
+	start := time.Now().UTC().Add(-time.Hour)
+	ids := saveTestChatMessages(t, websocket.NewRoomStore(h.DB), room,
+		start, start.Add(time.Minute), start.Add(2*time.Minute), start.Add(3*time.Minute), start.Add(4*time.Minute))
+
+	var stored int64
+	h.DB.Model(&models.ChatMessages{}).Where("room = ?", room).Count(&stored)
+	if stored != 3 {
+		t.Errorf("%d messages are stored", stored)
+	}
+	if _, history := listTestChatMessages(t, h, room, ""); strings.Join(history, ",") != strings.Join([]string{ids[4], ids[3], ids[2]}, ",") {
+		t.Errorf("history %v isn't the latest messages of %v", history, ids)
+	}
+}
+
+// Messages past RetentionDays are left out of the history at once, and
+// deleted with the next message.
+func TestChatRoomRetention(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	retentionDays := 1
+	room := newTestChatRoom(t, h.DB, &retentionDays, nil)
+	store := websocket.NewRoomStore(h.DB)
+	now := time.Now().UTC()
+	ids := saveTestChatMessages(t, store, room, now.Add(-time.Hour))
+
+	// Stored before the retention was set, e.g. by an update of the room
+	expired := models.ChatMessages{ID: "expired" + ids[0][:9], Room: room, Acct: "alice", Text: "old", CreatedAt: now.AddDate(0, 0, -2)}
+	if result := h.DB.Create(&expired); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if _, history := listTestChatMessages(t, h, room, ""); strings.Join(history, ",") != ids[0] {
+		t.Errorf("history %v includes an expired message", history)
+	}
+
+	ids = append(ids, saveTestChatMessages(t, store, room, now)...)
+	var remaining int64
+	h.DB.Model(&models.ChatMessages{}).Where("id = ?", expired.ID).Count(&remaining)
+	if remaining != 0 {
+		t.Error("expired message wasn't deleted")
+	}
+	if _, history := listTestChatMessages(t, h, room, ""); len(history) != 2 {
+		t.Errorf("history %v, want %v", history, ids)
+	}
+}
+
+// Only the creator of a room and admins can change its retention.
+func TestUpdateChatRoomPermissions(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	creator := newTestAccount(t, "creator")
+	if result := h.DB.Create(&models.Users{Acct: creator, Password: "-", FullName: "Room Creator"}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("acct = ?", creator).Delete(&models.Users{})
+	})
+	room := newTestChatRoom(t, h.DB, nil, nil)
+	if result := h.DB.Model(&models.ChatRooms{}).Where("name = ?", room).Update("created_by", creator); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	for name, tt := range map[string]struct {
+		claims *auth.Claims
+		want   int
+	}{
+		"missing claims": {nil, http.StatusUnauthorized},
+		"other user":     {&auth.Claims{Account: "mallory"}, http.StatusForbidden},
+		"creator":        {&auth.Claims{Account: creator}, http.StatusOK},
+		"admin":          {&auth.Claims{Account: "root", Roles: []string{auth.AdminRole}}, http.StatusOK},
+	} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPatch, "/api/v1/chatRooms/"+room,
+			strings.NewReader(`{"retentionDays":7}`)), map[string]string{"name": room})
+		if tt.claims != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "tokenClaims", tt.claims))
+		}
+		w := httptest.NewRecorder()
+		h.UpdateChatRoomHandler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s got status %d, want %d", name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/notify"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+var errEmailTaken = errors.New("email is already verified by another account")
+
+// VerifyEmailHandler godoc
+// @Description Verify user's email address with the signed link sent to it
+// @Tags emailVerification
+// @Produce application/json
+// @Param token path string true "Email verification token"
+// @Success 200 {object} CommonResponse "Email verified"
+// @Failure 400 {object} CommonResponse "Invalid or expired token, or the email has been changed since"
+// @Failure 409 {object} CommonResponse "Another account has verified the email first"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/emailVerifications/{token} [get]
+func (h handler) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	claims, err := auth.ParseEmailVerificationToken(vars["token"])
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Only verify if the account still uses the email the link was sent to
+	result := h.DB.Model(&models.Users{}).
+		Where("acct = ? AND email = ?", claims.Account, claims.Email).
+		Update("email_verified", true)
+	if result.Error != nil {
+		log.Println(result.Error)
+		// Verified emails are unique, the first account to verify keeps it
+		var pgErr *pgconn.PgError
+		if errors.As(result.Error, &pgErr) && pgErr.Code == "23505" {
+			writeErrorMessage(w, http.StatusConflict, errEmailTaken.Error())
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeErrorMessage(w, http.StatusBadRequest, auth.ErrInvalidEmailVerificationToken.Error())
+		return
+	}
+
+	writeErrorMessage(w, http.StatusOK, "Email verified")
+}
+
+// ResendEmailVerificationHandler godoc
+// @Description Send a new verification link to the selected account's email address
+// @Tags emailVerification
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Success 202 "Verification link will be sent"
+// @Failure 400 {object} CommonResponse "Account has no email or it's already verified"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 404 "Account doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/users/{account}/emailVerifications [post]
+func (h handler) ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	var user models.Users
+	if result := h.DB.Where(&models.Users{Acct: account}).First(&user); result.Error != nil {
+		log.Println(result.Error)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if user.Email == nil {
+		writeErrorMessage(w, http.StatusBadRequest, "Account has no email")
+		return
+	}
+	if user.EmailVerified {
+		writeErrorMessage(w, http.StatusBadRequest, "Email is already verified")
+		return
+	}
+
+	go h.sendEmailVerification(user.Acct, *user.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Sends a signed verification link to the given email. Meant to run in background.
+func (h handler) sendEmailVerification(account string, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	token, err := auth.CreateEmailVerificationToken(account, email)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	link := fmt.Sprintf("%s/api/v1/emailVerifications/%s", notify.PublicBaseURL, url.PathEscape(token))
+	err = h.Notifier.Send(ctx, notify.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Open the following link to verify the email address of account %s:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't sign up, you can ignore this message.",
+			account, link, auth.EmailVerificationTokenTTL),
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
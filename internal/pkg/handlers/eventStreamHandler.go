@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"uiassignment/internal/pkg/websocket"
+)
+
+// EventStreamHandler godoc
+// @Description Stream the messages of the websocket hub as Server-Sent Events, for clients which can't use websockets:
+// @Description broadcasts, messages sent to the token owner and messages published to the given topics. Each event is
+// @Description named after the message type, its data is the JSON message and its id the message ID. Comments are sent
+// @Description as heartbeats every SSE_HEARTBEAT_PERIOD. Reconnecting with the Last-Event-ID header resumes after that
+// @Description message if it's still buffered, else an error event with the code replay_unavailable is sent first.
+// @Description The stream ends with an error event with the code unauthorized once the access token expires or is revoked.
+// @Tags event
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param topic query []string false "Topics to receive messages of, rooms can't be streamed(max=32)" collectionFormat(multi)
+// @Param Last-Event-ID header string false "ID of the last received event, for resuming"
+// @Success 200 "Event stream"
+// @Failure 400 {object} CommonResponse "Invalid topic"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "The token isn't a first-party token, e.g. of an OAuth client or an API key"
+// @Failure 503 "The websocket hub is shutting down"
+// @Router /v1/events [get]
+func (h handler) EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	topics := r.URL.Query()["topic"]
+	if len(topics) > 32 {
+		writeErrorMessage(w, http.StatusBadRequest, "at most 32 topics can be streamed")
+		return
+	}
+	for _, topic := range topics {
+		if !websocket.IsValidTopic(topic) {
+			writeErrorMessage(w, http.StatusBadRequest, "topic must be lowercase letters, digits and ._:- up to 64 characters, and not a room")
+			return
+		}
+	}
+
+	websocket.ServeEvents(h.Hub, w, r, topics)
+}
@@ -1,7 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
+	"log"
+	"net/http"
 	"strings"
+	"uiassignment/internal/pkg/authenticator"
+	"uiassignment/internal/pkg/events"
+	"uiassignment/internal/pkg/notify"
+	"uiassignment/internal/pkg/outbox"
 	"uiassignment/internal/pkg/websocket"
 
 	"github.com/go-playground/validator/v10"
@@ -9,9 +16,11 @@ import (
 )
 
 type handler struct {
-	DB        *gorm.DB
-	Validator *validator.Validate
-	Hub       *websocket.Hub
+	DB            *gorm.DB
+	Validator     *validator.Validate
+	Hub           *websocket.Hub
+	Notifier      notify.Notifier
+	Authenticator authenticator.Authenticator
 }
 
 // swagger:handlers CommonResponse
@@ -21,8 +30,9 @@ type CommonResponse struct {
 	Message string `json:"message"`
 }
 
-func New(db *gorm.DB, validator *validator.Validate, hub *websocket.Hub) handler {
-	return handler{db, validator, hub}
+func New(db *gorm.DB, validator *validator.Validate, hub *websocket.Hub, notifier notify.Notifier,
+	authenticator authenticator.Authenticator) handler {
+	return handler{db, validator, hub, notifier, authenticator}
 }
 
 // Helper function for generating message from ValidationErrors.
@@ -44,3 +54,27 @@ func ValidatorErrorMessageBuilder(err error) string {
 	}
 	return errorMessage.String()
 }
+
+// Helper function for responding with a CommonResponse carrying the given message.
+func writeErrorMessage(w http.ResponseWriter, statusCode int, message string) {
+	var errResponse CommonResponse
+	errResponse.Message = message
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(errResponse)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Writes an event about the account to the outbox. Pass the transaction which
+// makes the change the event is about, so the event isn't lost or sent for a
+// change which was rolled back.
+func writeEvent(tx *gorm.DB, eventType string, account string, data interface{}) error {
+	event, err := events.New(eventType, account, data)
+	if err != nil {
+		return err
+	}
+	return outbox.Write(tx, event)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/db"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Connects to the database set up by `make start_db init_db`, configured by
+// the same POSTGRES_* env variables as the service. Tests needing it are
+// skipped if it can't be reached.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	testDB, err := gorm.Open(postgres.Open(db.DSN()+" connect_timeout=2"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Skipf("test database isn't available: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := testDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return testDB
+}
+
+// Returns an account name with the prefix which no other test run uses.
+// Accounts are alphanumeric.
+func newTestAccount(t *testing.T, prefix string) string {
+	t.Helper()
+	suffix, err := auth.GenerateRandomToken(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prefix + strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(suffix))
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// MetricsHandler godoc
+// @Description Get the runtime metrics of this server published with expvar, e.g. memstats and the websocket counters
+// @Description messages_dropped_oldest, messages_dropped_newest, slow_consumers_disconnected, publish_dropped and
+// @Description backplane_dropped under websocket.
+// @Tags metrics
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Router /v1/admin/metrics [get]
+func (h handler) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	expvar.Handler().ServeHTTP(w, r)
+}
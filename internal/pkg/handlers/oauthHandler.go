@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OAuth error codes(RFC 6749 section 5.2)
+const (
+	oauthErrInvalidRequest       = "invalid_request"
+	oauthErrInvalidClient        = "invalid_client"
+	oauthErrInvalidGrant         = "invalid_grant"
+	oauthErrUnauthorizedClient   = "unauthorized_client"
+	oauthErrUnsupportedGrantType = "unsupported_grant_type"
+	oauthErrInvalidScope         = "invalid_scope"
+)
+
+// swagger:handlers registerOauthClientRequest
+// @Description JSON request body for registering an OAuth client
+type registerOauthClientRequest struct {
+	// Name shown to users on consent(Length: min=1, max=50)
+	Name string `json:"name" validate:"required,min=1,max=50"`
+	// Redirect URIs for the authorization code grant, https or http on localhost
+	RedirectURIs []string `json:"redirectUris" validate:"required,min=1,max=10,dive,url,max=2000"`
+	// Scopes the client may request(users:read, users:write)
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=users:read users:write"`
+	// Whether the client can keep a secret, e.g. a server side application.
+	// Only confidential clients can use the client credentials grant.
+	Confidential bool `json:"confidential"`
+}
+
+// swagger:handlers oauthClientResponse
+// @Description Registered OAuth client
+type oauthClientResponse struct {
+	// Client ID
+	ClientID string `json:"clientId"`
+	// Client secret of confidential clients, shown only once on registration
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// Name shown to users on consent
+	Name string `json:"name"`
+	// Registered redirect URIs
+	RedirectURIs []string `json:"redirectUris"`
+	// Scopes the client may request
+	Scopes []string `json:"scopes"`
+	// Whether the client has a secret
+	Confidential bool `json:"confidential"`
+	// The time when the client was registered
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newOauthClientResponse(client models.OauthClients) oauthClientResponse {
+	return oauthClientResponse{
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIList(),
+		Scopes:       client.ScopeList(),
+		Confidential: client.IsConfidential(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// RegisterOauthClientHandler godoc
+// @Description Register an OAuth client owned by the token owner
+// @Tags oauth
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param Body body registerOauthClientRequest true "Client details"
+// @Success 201 {object} oauthClientResponse
+// @Failure 400 {object} CommonResponse "Invalid request body or redirect URI"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Token issued to an OAuth client can't register clients"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/oauth/clients [post]
+func (h handler) RegisterOauthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var rocRequest registerOauthClientRequest
+
+	err := json.NewDecoder(r.Body).Decode(&rocRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(rocRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+	for _, redirectURI := range rocRequest.RedirectURIs {
+		if err := auth.ValidateRedirectURI(redirectURI); err != nil {
+			writeErrorMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	scopes, _ := auth.ParseScope(strings.Join(rocRequest.Scopes, " "), auth.SupportedScopes)
+
+	clientID, err := auth.GenerateRandomToken(16)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var clientSecret, secretHash string
+	if rocRequest.Confidential {
+		clientSecret, err = auth.GenerateRandomToken(32)
+		if err != nil {
+			log.Println(err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		secretHash = auth.HashToken(clientSecret)
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	client := models.OauthClients{
+		ClientID:     clientID,
+		SecretHash:   secretHash,
+		Name:         rocRequest.Name,
+		OwnerAcct:    tokenOwner,
+		RedirectURIs: strings.Join(rocRequest.RedirectURIs, " "),
+		Scopes:       strings.Join(scopes, " "),
+	}
+	if result := h.DB.Create(&client); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r, audit.ActionOauthClientRegistered, tokenOwner, clientID)
+
+	ocResponse := newOauthClientResponse(client)
+	ocResponse.ClientSecret = clientSecret
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(ocResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListOauthClientsHandler godoc
+// @Description List OAuth clients owned by the token owner
+// @Tags oauth
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Success 200 {array} oauthClientResponse
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Token issued to an OAuth client can't manage clients"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/oauth/clients [get]
+func (h handler) ListOauthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+
+	var clients []models.OauthClients
+	if result := h.DB.Where("owner_acct = ?", tokenOwner).Order("created_at").Find(&clients); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var ocResponses = []oauthClientResponse{}
+	for _, client := range clients {
+		ocResponses = append(ocResponses, newOauthClientResponse(client))
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(ocResponses)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteOauthClientHandler godoc
+// @Description Delete an OAuth client owned by the token owner. Tokens issued to the client stop working.
+// @Tags oauth
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param clientId path string true "Client ID"
+// @Success 200 "Successfully deleted the client"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Token issued to an OAuth client can't manage clients"
+// @Failure 404 "Client doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/oauth/clients/{clientId} [delete]
+func (h handler) DeleteOauthClientHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientId"]
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+
+	result := h.DB.Where("client_id = ? AND owner_acct = ?", clientID, tokenOwner).Delete(&models.OauthClients{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	audit.Log(r, audit.ActionOauthClientDeleted, tokenOwner, clientID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// swagger:handlers authorizeOauthClientRequest
+// @Description Authorization request(RFC 6749 section 4.1.1) approved by the token owner
+type authorizeOauthClientRequest struct {
+	// Must be "code"
+	ResponseType string `json:"responseType" validate:"required,eq=code"`
+	// Client ID
+	ClientID string `json:"clientId" validate:"required"`
+	// One of the client's registered redirect URIs
+	RedirectURI string `json:"redirectUri" validate:"required"`
+	// Space separated scopes, defaults to all scopes of the client
+	Scope string `json:"scope"`
+	// Opaque value passed back to the client
+	State string `json:"state" validate:"max=500"`
+	// PKCE code challenge(RFC 7636)
+	CodeChallenge string `json:"codeChallenge" validate:"required,len=43"`
+	// PKCE code challenge method, must be "S256"
+	CodeChallengeMethod string `json:"codeChallengeMethod" validate:"required,eq=S256"`
+}
+
+// swagger:handlers authorizeOauthClientResponse
+// @Description Where to send the user agent to complete the authorization
+type authorizeOauthClientResponse struct {
+	// Redirect URI with the authorization code and state
+	RedirectTo string `json:"redirectTo"`
+}
+
+// AuthorizeOauthClientHandler godoc
+// @Description Grant an OAuth client access on behalf of the token owner, after the user consented in our UI.
+// @Description Returns the redirect URI carrying the authorization code for the client.
+// @Tags oauth
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param Body body authorizeOauthClientRequest true "Authorization request of the client"
+// @Success 200 {object} authorizeOauthClientResponse
+// @Failure 400 {object} CommonResponse "Invalid request, client, redirect URI or scope"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Token issued to an OAuth client can't authorize clients"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/oauth/authorize [post]
+func (h handler) AuthorizeOauthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var aocRequest authorizeOauthClientRequest
+
+	err := json.NewDecoder(r.Body).Decode(&aocRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(aocRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	var client models.OauthClients
+	if result := h.DB.Where("client_id = ?", aocRequest.ClientID).First(&client); result.Error != nil {
+		log.Println(result.Error)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			writeErrorMessage(w, http.StatusBadRequest, "unknown client")
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if !containsString(client.RedirectURIList(), aocRequest.RedirectURI) {
+		writeErrorMessage(w, http.StatusBadRequest, "redirect URI isn't registered for the client")
+		return
+	}
+	scopes, err := auth.ParseScope(aocRequest.Scope, client.ScopeList())
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	code, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	if result := h.DB.Create(&models.OauthAuthorizationCodes{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ClientID,
+		Acct:          tokenOwner,
+		RedirectURI:   aocRequest.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: aocRequest.CodeChallenge,
+		ExpiresAt:     time.Now().Add(auth.OAuthAuthorizationCodeTTL)}); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r, audit.ActionOauthClientAuthorized, tokenOwner, client.ClientID)
+
+	redirectTo, _ := url.Parse(aocRequest.RedirectURI)
+	query := redirectTo.Query()
+	query.Set("code", code)
+	if len(aocRequest.State) > 0 {
+		query.Set("state", aocRequest.State)
+	}
+	redirectTo.RawQuery = query.Encode()
+
+	var aocResponse authorizeOauthClientResponse
+	aocResponse.RedirectTo = redirectTo.String()
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(aocResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// swagger:handlers oauthTokenResponse
+// @Description Successful token response(RFC 6749 section 5.1)
+type oauthTokenResponse struct {
+	// Access token
+	AccessToken string `json:"access_token"`
+	// Always "Bearer"
+	TokenType string `json:"token_type"`
+	// Lifetime of the access token in seconds
+	ExpiresIn int64 `json:"expires_in"`
+	// Space separated granted scopes
+	Scope string `json:"scope"`
+}
+
+// swagger:handlers oauthErrorResponse
+// @Description Error response(RFC 6749 section 5.2)
+type oauthErrorResponse struct {
+	// Error code
+	Error string `json:"error"`
+	// Human readable description
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OauthTokenHandler godoc
+// @Description OAuth token endpoint supporting the authorization_code grant with PKCE and the client_credentials grant.
+// @Description Clients authenticate with HTTP Basic or client_id/client_secret form parameters.
+// @Tags oauth
+// @Accept application/x-www-form-urlencoded
+// @Produce application/json
+// @Param grant_type formData string true "authorization_code or client_credentials"
+// @Param code formData string false "Authorization code(authorization_code)"
+// @Param redirect_uri formData string false "Redirect URI of the authorization request(authorization_code)"
+// @Param code_verifier formData string false "PKCE code verifier(authorization_code)"
+// @Param scope formData string false "Space separated scopes(client_credentials)"
+// @Param client_id formData string false "Client ID, if not using HTTP Basic"
+// @Param client_secret formData string false "Client secret, if not using HTTP Basic"
+// @Success 200 {object} oauthTokenResponse
+// @Failure 400 {object} oauthErrorResponse "Invalid request or grant"
+// @Failure 401 {object} oauthErrorResponse "Client authentication failed"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/oauth/token [post]
+func (h handler) OauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOauthError(w, http.StatusBadRequest, oauthErrInvalidRequest, "malformed form body")
+		return
+	}
+
+	clientID, clientSecret, usedBasicAuth := r.BasicAuth()
+	if !usedBasicAuth {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	var client models.OauthClients
+	if result := h.DB.Where("client_id = ?", clientID).First(&client); result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			log.Println(result.Error)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeOauthClientError(w, usedBasicAuth)
+		return
+	}
+	if client.IsConfidential() && !auth.IsClientSecretMatched(client.SecretHash, clientSecret) {
+		writeOauthClientError(w, usedBasicAuth)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		h.exchangeAuthorizationCode(w, r, client)
+	case "client_credentials":
+		if !client.IsConfidential() {
+			writeOauthError(w, http.StatusBadRequest, oauthErrUnauthorizedClient, "public clients can't use client_credentials")
+			return
+		}
+		scopes, err := auth.ParseScope(r.PostForm.Get("scope"), client.ScopeList())
+		if err != nil {
+			writeOauthError(w, http.StatusBadRequest, oauthErrInvalidScope, err.Error())
+			return
+		}
+		writeOauthToken(w, "", client.ClientID, scopes)
+	default:
+		writeOauthError(w, http.StatusBadRequest, oauthErrUnsupportedGrantType, "")
+	}
+}
+
+// Redeems an authorization code. Codes are single-use and bound to the client,
+// redirect URI and PKCE challenge of the authorization request.
+func (h handler) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client models.OauthClients) {
+	var codes []models.OauthAuthorizationCodes
+	result := h.DB.Clauses(clause.Returning{}).
+		Where("code_hash = ? AND client_id = ?", auth.HashToken(r.PostForm.Get("code")), client.ClientID).
+		Delete(&codes)
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(codes) != 1 || codes[0].ExpiresAt.Before(time.Now()) {
+		writeOauthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "invalid or expired authorization code")
+		return
+	}
+	code := codes[0]
+
+	if code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeOauthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "redirect_uri doesn't match the authorization request")
+		return
+	}
+	if !auth.VerifyPKCE(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		writeOauthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "invalid code_verifier")
+		return
+	}
+
+	writeOauthToken(w, code.Acct, client.ClientID, strings.Fields(code.Scope))
+}
+
+func writeOauthToken(w http.ResponseWriter, account string, clientID string, scopes []string) {
+	accessToken, expiresAt, err := auth.CreateScopedAccessToken(account, clientID, scopes, auth.OAuthAccessTokenTTL)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var otResponse oauthTokenResponse
+	otResponse.AccessToken = accessToken
+	otResponse.TokenType = "Bearer"
+	otResponse.ExpiresIn = expiresAt - time.Now().Unix()
+	otResponse.Scope = strings.Join(scopes, " ")
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(otResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeOauthClientError(w http.ResponseWriter, usedBasicAuth bool) {
+	if usedBasicAuth {
+		w.Header().Add("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	writeOauthError(w, http.StatusUnauthorized, oauthErrInvalidClient, "client authentication failed")
+}
+
+func writeOauthError(w http.ResponseWriter, statusCode int, errorCode string, description string) {
+	var oeResponse oauthErrorResponse
+	oeResponse.Error = errorCode
+	oeResponse.ErrorDescription = description
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(oeResponse)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	testOauthRedirectURI = "http://localhost:8080/callback"
+	testOauthVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// Registers a client allowed users:read for a new account. Confidential
+// clients get the returned secret.
+func newTestOauthClient(t *testing.T, h handler, confidential bool) (client models.OauthClients, secret string) {
+	t.Helper()
+	account := newTestAccount(t, "oauth")
+	if result := h.DB.Create(&models.Users{Acct: account, Password: "-", FullName: "OAuth Test"}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("acct = ?", account).Delete(&models.Users{})
+	})
+
+	client = models.OauthClients{
+		ClientID:     newTestAccount(t, "client"),
+		Name:         "Test Client",
+		OwnerAcct:    account,
+		RedirectURIs: testOauthRedirectURI,
+		Scopes:       auth.ScopeUsersRead,
+	}
+	if confidential {
+		secret = "secret-of-" + client.ClientID
+		client.SecretHash = auth.HashToken(secret)
+	}
+	if result := h.DB.Create(&client); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	return client, secret
+}
+
+// Approves an authorization request of the client as its owner and returns
+// the issued code.
+func authorizeTestOauthClient(t *testing.T, h handler, client models.OauthClients) string {
+	t.Helper()
+	body, _ := json.Marshal(authorizeOauthClientRequest{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         testOauthRedirectURI,
+		CodeChallenge:       auth.PKCEChallenge(testOauthVerifier),
+		CodeChallengeMethod: "S256",
+	})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/oauth/authorize", strings.NewReader(string(body)))
+	r = r.WithContext(context.WithValue(r.Context(), "tokenOwner", client.OwnerAcct))
+	w := httptest.NewRecorder()
+	h.AuthorizeOauthClientHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("authorization got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var aocResponse authorizeOauthClientResponse
+	if err := json.NewDecoder(w.Body).Decode(&aocResponse); err != nil {
+		t.Fatal(err)
+	}
+	redirectTo, err := url.Parse(aocResponse.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redirectTo.Query().Get("code")
+}
+
+func requestOauthToken(h handler, form url.Values) (int, oauthErrorResponse) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.OauthTokenHandler(w, r)
+
+	var oeResponse oauthErrorResponse
+	json.NewDecoder(w.Body).Decode(&oeResponse)
+	return w.Code, oeResponse
+}
+
+func codeExchangeForm(client models.OauthClients, code string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {client.ClientID},
+		"code":          {code},
+		"redirect_uri":  {testOauthRedirectURI},
+		"code_verifier": {testOauthVerifier},
+	}
+}
+
+// Authorization codes can be exchanged once only.
+func TestOauthCodeCantBeReused(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	client, _ := newTestOauthClient(t, h, false)
+	code := authorizeTestOauthClient(t, h, client)
+
+	if status, oeResponse := requestOauthToken(h, codeExchangeForm(client, code)); status != http.StatusOK {
+		t.Fatalf("first exchange got status %d: %+v", status, oeResponse)
+	}
+	status, oeResponse := requestOauthToken(h, codeExchangeForm(client, code))
+	if status != http.StatusBadRequest || oeResponse.Error != oauthErrInvalidGrant {
+		t.Errorf("second exchange got status %d: %+v", status, oeResponse)
+	}
+}
+
+func TestOauthExpiredCodeIsRejected(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	client, _ := newTestOauthClient(t, h, false)
+	code := authorizeTestOauthClient(t, h, client)
+	if result := h.DB.Model(&models.OauthAuthorizationCodes{}).Where("code_hash = ?", auth.HashToken(code)).
+		Update("expires_at", time.Now().Add(-time.Second)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	status, oeResponse := requestOauthToken(h, codeExchangeForm(client, code))
+	if status != http.StatusBadRequest || oeResponse.Error != oauthErrInvalidGrant {
+		t.Errorf("expired code got status %d: %+v", status, oeResponse)
+	}
+}
+
+// A failed exchange burns the code, so each case authorizes again.
+func TestOauthCodeExchangeChecksTheAuthorizationRequest(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	client, _ := newTestOauthClient(t, h, false)
+	other, _ := newTestOauthClient(t, h, false)
+
+	for name, tamper := range map[string]func(form url.Values){
+		"mismatched redirect_uri": func(form url.Values) { form.Set("redirect_uri", "http://localhost:8080/other") },
+		"missing code_verifier":   func(form url.Values) { form.Del("code_verifier") },
+		"plain code_verifier":     func(form url.Values) { form.Set("code_verifier", auth.PKCEChallenge(testOauthVerifier)) },
+		"code of another client":  func(form url.Values) { form.Set("client_id", other.ClientID) },
+	} {
+		form := codeExchangeForm(client, authorizeTestOauthClient(t, h, client))
+		tamper(form)
+		status, oeResponse := requestOauthToken(h, form)
+		if status != http.StatusBadRequest || oeResponse.Error != oauthErrInvalidGrant {
+			t.Errorf("%s got status %d: %+v", name, status, oeResponse)
+		}
+	}
+}
+
+func TestOauthClientCredentialsGrant(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	publicClient, _ := newTestOauthClient(t, h, false)
+	confidentialClient, secret := newTestOauthClient(t, h, true)
+
+	status, oeResponse := requestOauthToken(h, url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {publicClient.ClientID},
+	})
+	if status != http.StatusBadRequest || oeResponse.Error != oauthErrUnauthorizedClient {
+		t.Errorf("public client got status %d: %+v", status, oeResponse)
+	}
+
+	status, oeResponse = requestOauthToken(h, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {confidentialClient.ClientID},
+		"client_secret": {"wrong"},
+	})
+	if status != http.StatusUnauthorized || oeResponse.Error != oauthErrInvalidClient {
+		t.Errorf("wrong secret got status %d: %+v", status, oeResponse)
+	}
+
+	status, oeResponse = requestOauthToken(h, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {confidentialClient.ClientID},
+		"client_secret": {secret},
+		"scope":         {auth.ScopeUsersWrite},
+	})
+	if status != http.StatusBadRequest || oeResponse.Error != oauthErrInvalidScope {
+		t.Errorf("scope outside the allowed ones got status %d: %+v", status, oeResponse)
+	}
+
+	status, oeResponse = requestOauthToken(h, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {confidentialClient.ClientID},
+		"client_secret": {secret},
+		"scope":         {auth.ScopeUsersRead},
+	})
+	if status != http.StatusOK {
+		t.Errorf("allowed scope got status %d: %+v", status, oeResponse)
+	}
+}
+
+// Authorization requests can't ask for more than the client may request.
+func TestOauthAuthorizeRejectsScopeOutsideClient(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+	client, _ := newTestOauthClient(t, h, false)
+
+	body, _ := json.Marshal(authorizeOauthClientRequest{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         testOauthRedirectURI,
+		Scope:               auth.ScopeUsersRead + " " + auth.ScopeUsersWrite,
+		CodeChallenge:       auth.PKCEChallenge(testOauthVerifier),
+		CodeChallengeMethod: "S256",
+	})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/oauth/authorize", strings.NewReader(string(body)))
+	r = r.WithContext(context.WithValue(r.Context(), "tokenOwner", client.OwnerAcct))
+	w := httptest.NewRecorder()
+	h.AuthorizeOauthClientHandler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/oidc"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+const oidcLoginCookie = "oidc_login"
+
+var (
+	errFederatedUserNotLinked = errors.New("no local account is linked to this identity")
+	errFederatedAccountTaken  = errors.New("account or email derived from this identity is already taken")
+)
+
+// swagger:handlers oidcProviderResponse
+// @Description Upstream OIDC provider users can log in with
+type oidcProviderResponse struct {
+	// Provider name
+	Name string `json:"name"`
+	// Path starting the login
+	LoginPath string `json:"loginPath"`
+}
+
+// ListOidcProvidersHandler godoc
+// @Description List the configured upstream OIDC providers
+// @Tags oidc
+// @Produce application/json
+// @Success 200 {array} oidcProviderResponse
+// @Failure 500 "Internal error caused by JSON parsing failure"
+// @Router /v1/oidc/providers [get]
+func (h handler) ListOidcProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	var opResponses = []oidcProviderResponse{}
+	for name := range oidc.Providers {
+		opResponses = append(opResponses, oidcProviderResponse{
+			Name:      name,
+			LoginPath: "/api/v1/oidc/" + name + "/login",
+		})
+	}
+	sort.Slice(opResponses, func(i, j int) bool { return opResponses[i].Name < opResponses[j].Name })
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(opResponses)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// BeginOidcLoginHandler godoc
+// @Description Start logging in with an upstream OIDC provider. The browser is redirected to the provider,
+// @Description which sends it back to /v1/oidc/{provider}/callback. With ?session=cookie the callback starts
+// @Description a browser session instead of returning an access token.
+// @Tags oidc
+// @Param provider path string true "Provider name"
+// @Param session query string false "cookie for starting a browser session"
+// @Success 302 "Redirect to the provider's authorization endpoint"
+// @Failure 404 "Provider isn't configured"
+// @Failure 502 "Provider's discovery document can't be fetched"
+// @Failure 500 "Internal error"
+// @Router /v1/oidc/{provider}/login [get]
+func (h handler) BeginOidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provider, ok := oidc.Providers[vars["provider"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	loginState, claims, err := auth.CreateOIDCLoginState(provider.Config.Name, isCookieSessionRequested(r))
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), claims.State, claims.Nonce, auth.PKCEChallenge(claims.CodeVerifier))
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    loginState,
+		Path:     "/api/v1/oidc/",
+		MaxAge:   int(auth.OIDCLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax, so the cookie comes along with the redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// FinishOidcLoginHandler godoc
+// @Description Complete logging in with an upstream OIDC provider. The identity is linked to a local account
+// @Description by a previous login, a verified email or, if the provider allows it, the account name.
+// @Description Unknown users are created if the provider allows provisioning.
+// @Tags oidc
+// @Produce application/json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State of the login"
+// @Success 200 {object} createAccessTokenResponse
+// @Success 202 {object} twoFactorChallengeResponse "Second factor required"
+// @Failure 400 {object} CommonResponse "Login failed or was denied at the provider"
+// @Failure 403 {object} CommonResponse "No local account is linked and provisioning is disabled"
+// @Failure 404 "Provider isn't configured"
+// @Failure 409 {object} CommonResponse "Account or email for provisioning is already taken"
+// @Failure 502 "Provider can't be reached"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/oidc/{provider}/callback [get]
+func (h handler) FinishOidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provider, ok := oidc.Providers[vars["provider"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The login state is single-use
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Path:     "/api/v1/oidc/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if errorCode := query.Get("error"); len(errorCode) > 0 {
+		writeErrorMessage(w, http.StatusBadRequest, "login denied by provider: "+errorCode)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcLoginCookie)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, auth.ErrInvalidOIDCLoginState.Error())
+		return
+	}
+	loginState, err := auth.ParseOIDCLoginState(cookie.Value, provider.Config.Name)
+	if err != nil || loginState.State != query.Get("state") {
+		writeErrorMessage(w, http.StatusBadRequest, auth.ErrInvalidOIDCLoginState.Error())
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, oidc.ErrInvalidIDToken) || errors.Is(err, oidc.ErrTokenExchangeFailed) {
+			writeErrorMessage(w, http.StatusBadRequest, "login with provider failed")
+		} else {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+
+	account, err := h.resolveFederatedUser(r, provider.Config, claims)
+	if err != nil {
+		log.Println(err.Error())
+		switch {
+		case errors.Is(err, errFederatedUserNotLinked):
+			writeErrorMessage(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, errFederatedAccountTaken):
+			writeErrorMessage(w, http.StatusConflict, err.Error())
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.writeLoginResponse(w, r, account, loginState.CookieSession)
+}
+
+// Finds the local account of an upstream identity, linking or provisioning it
+// on the first login.
+func (h handler) resolveFederatedUser(r *http.Request, config oidc.Config, claims *oidc.IDTokenClaims) (string, error) {
+	now := time.Now()
+
+	var identity models.UserIdentities
+	result := h.DB.Where(&models.UserIdentities{Provider: config.Name, Subject: claims.Subject}).First(&identity)
+	if result.Error == nil {
+		h.DB.Model(&identity).Update("last_login_at", now)
+		return identity.Acct, nil
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return "", result.Error
+	}
+
+	email := strings.ToLower(claims.Email)
+	account := claims.Account()
+
+	// Link an existing user. Emails count only if both sides have verified them.
+	var user models.Users
+	query := h.DB.Where("1 = 0")
+	if claims.EmailVerified && len(email) > 0 {
+		query = h.DB.Where("email = ? AND email_verified", email)
+	}
+	if config.LinkByAccount && len(account) > 0 {
+		query = query.Or("acct = ?", account)
+	}
+	result = query.First(&user)
+	if result.Error == nil {
+		identity = models.UserIdentities{Provider: config.Name, Subject: claims.Subject, Acct: user.Acct, LastLoginAt: &now}
+		if result := h.DB.Create(&identity); result.Error != nil {
+			return "", result.Error
+		}
+		audit.Log(r, audit.ActionIdentityLinked, user.Acct, config.Name)
+		return user.Acct, nil
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return "", result.Error
+	}
+
+	if !config.AutoProvision {
+		return "", errFederatedUserNotLinked
+	}
+	if len(account) == 0 {
+		return "", errFederatedAccountTaken
+	}
+
+	// Provisioned users have no password. They can set one with a password reset.
+	user = models.Users{
+		Acct:     account,
+		FullName: federatedFullName(claims, account),
+	}
+	if claims.EmailVerified && len(email) > 0 {
+		user.Email = &email
+		user.EmailVerified = true
+	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(&user); result.Error != nil {
+			return result.Error
+		}
+		identity = models.UserIdentities{Provider: config.Name, Subject: claims.Subject, Acct: user.Acct, LastLoginAt: &now}
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return "", errFederatedAccountTaken
+		}
+		return "", err
+	}
+
+	audit.Log(r, audit.ActionUserProvisioned, user.Acct, config.Name)
+	return user.Acct, nil
+}
+
+// Full name of a provisioned user, limited to the length of the column.
+func federatedFullName(claims *oidc.IDTokenClaims, account string) string {
+	fullName := []rune(strings.TrimSpace(claims.Name))
+	if len(fullName) == 0 {
+		return account
+	}
+	if len(fullName) > 50 {
+		fullName = fullName[:50]
+	}
+	return string(fullName)
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/oidc"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+)
+
+const (
+	testOIDCProvider = "fake"
+	testOIDCClientID = "uiassignment"
+)
+
+// Authorization granted by the fake provider, redeemable once with the code
+// verifier of its challenge.
+type fakeAuthorization struct {
+	codeChallenge string
+	nonce         string
+	claims        jwt.MapClaims
+}
+
+// In-process OpenID Connect provider issuing ES256 signed ID tokens.
+type fakeOIDCProvider struct {
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+
+	mutex          sync.Mutex
+	authorizations map[string]fakeAuthorization
+}
+
+// Starts the fake provider and registers it as the "fake" provider for the
+// duration of the test.
+func startFakeOIDCProvider(t *testing.T, config oidc.Config) *fakeOIDCProvider {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &fakeOIDCProvider{key: key, authorizations: make(map[string]fakeAuthorization)}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/.well-known/openid-configuration", provider.discovery)
+	router.HandleFunc("/jwks", provider.jwks)
+	router.HandleFunc("/token", provider.token).Methods(http.MethodPost)
+	provider.server = httptest.NewServer(router)
+	t.Cleanup(provider.server.Close)
+
+	config.Name = testOIDCProvider
+	config.Issuer = provider.server.URL
+	config.ClientID = testOIDCClientID
+	config.RedirectURL = "http://localhost/api/v1/oidc/fake/callback"
+	oidc.Providers[testOIDCProvider] = oidc.NewProvider(config)
+	t.Cleanup(func() { delete(oidc.Providers, testOIDCProvider) })
+	return provider
+}
+
+func (p *fakeOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.server.URL,
+		"authorization_endpoint": p.server.URL + "/authorize",
+		"token_endpoint":         p.server.URL + "/token",
+		"jwks_uri":               p.server.URL + "/jwks",
+	})
+}
+
+func (p *fakeOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	x, y := make([]byte, 32), make([]byte, 32)
+	p.key.X.FillBytes(x)
+	p.key.Y.FillBytes(y)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC", "kid": "test", "use": "sig", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(x),
+			"y": base64.RawURLEncoding.EncodeToString(y),
+		}},
+	})
+}
+
+// Redeems an authorization code, checking the PKCE code verifier against the
+// challenge of the authorization request(RFC 7636 section 4.6).
+func (p *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	writeError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("client_id") != testOIDCClientID {
+		writeError("invalid_request")
+		return
+	}
+
+	p.mutex.Lock()
+	authorization, ok := p.authorizations[r.PostFormValue("code")]
+	delete(p.authorizations, r.PostFormValue("code"))
+	p.mutex.Unlock()
+	if !ok || auth.PKCEChallenge(r.PostFormValue("code_verifier")) != authorization.codeChallenge {
+		writeError("invalid_grant")
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   testOIDCClientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": authorization.nonce,
+	}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// Plays the user approving the login at the provider. Returns the
+// authorization code the provider redirects back with.
+func (p *fakeOIDCProvider) authorize(t *testing.T, authURL *url.URL, claims jwt.MapClaims) string {
+	t.Helper()
+	query := authURL.Query()
+	if !strings.HasPrefix(authURL.String(), p.server.URL+"/authorize?") {
+		t.Fatalf("login redirected to %s", authURL)
+	}
+	if query.Get("code_challenge_method") != "S256" || len(query.Get("code_challenge")) == 0 {
+		t.Fatalf("login didn't use PKCE: %s", authURL)
+	}
+	if query.Get("client_id") != testOIDCClientID || query.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization request: %s", authURL)
+	}
+
+	code, err := auth.GenerateRandomToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mutex.Lock()
+	p.authorizations[code] = fakeAuthorization{
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+		claims:        claims,
+	}
+	p.mutex.Unlock()
+	return code
+}
+
+// Starts a login, returning the login state cookie and the provider's
+// authorization URL the browser is redirected to.
+func beginTestOidcLogin(t *testing.T, h handler) (*http.Cookie, *url.URL) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/oidc/fake/login", nil)
+	r = mux.SetURLVars(r, map[string]string{"provider": testOIDCProvider})
+	w := httptest.NewRecorder()
+	h.BeginOidcLoginHandler(w, r)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("login responded %d", w.Code)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcLoginCookie {
+			return cookie, authURL
+		}
+	}
+	t.Fatal("login state cookie wasn't set")
+	return nil, nil
+}
+
+// Completes a login the way the provider redirects the browser back.
+func finishTestOidcLogin(h handler, cookie *http.Cookie, code string, state string) *httptest.ResponseRecorder {
+	query := url.Values{"code": {code}, "state": {state}}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/oidc/fake/callback?"+query.Encode(), nil)
+	r = mux.SetURLVars(r, map[string]string{"provider": testOIDCProvider})
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.FinishOidcLoginHandler(w, r)
+	return w
+}
+
+// Redeems the code with the verifier and nonce of the login directly, as
+// control that only the tampered part makes a callback fail.
+func exchangeTestOidcLogin(t *testing.T, cookie *http.Cookie, code string) error {
+	t.Helper()
+	loginState, err := auth.ParseOIDCLoginState(cookie.Value, testOIDCProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = oidc.Providers[testOIDCProvider].Exchange(context.Background(), code, loginState.CodeVerifier, loginState.Nonce)
+	return err
+}
+
+func TestOidcLoginRejectsStateMismatch(t *testing.T) {
+	provider := startFakeOIDCProvider(t, oidc.Config{})
+	h := handler{}
+
+	cookie, authURL := beginTestOidcLogin(t, h)
+	code := provider.authorize(t, authURL, jwt.MapClaims{"sub": "alice"})
+
+	if w := finishTestOidcLogin(h, cookie, code, "forged"); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with another state responded %d", w.Code)
+	}
+	if w := finishTestOidcLogin(h, nil, code, authURL.Query().Get("state")); w.Code != http.StatusBadRequest {
+		t.Errorf("callback without login state cookie responded %d", w.Code)
+	}
+
+	// The rejected callbacks didn't redeem the code
+	if err := exchangeTestOidcLogin(t, cookie, code); err != nil {
+		t.Errorf("code of the login was rejected: %v", err)
+	}
+}
+
+func TestOidcLoginRejectsNonceMismatch(t *testing.T) {
+	provider := startFakeOIDCProvider(t, oidc.Config{})
+	h := handler{}
+
+	cookie, authURL := beginTestOidcLogin(t, h)
+	// A token issued for another login, e.g. replayed by an attacker
+	code := provider.authorize(t, authURL, jwt.MapClaims{"sub": "alice", "nonce": "other"})
+
+	if w := finishTestOidcLogin(h, cookie, code, authURL.Query().Get("state")); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with ID token of another nonce responded %d", w.Code)
+	}
+
+	code = provider.authorize(t, authURL, jwt.MapClaims{"sub": "alice"})
+	if err := exchangeTestOidcLogin(t, cookie, code); err != nil {
+		t.Errorf("ID token with the login's nonce was rejected: %v", err)
+	}
+}
+
+func TestOidcLoginRequiresPKCEVerifier(t *testing.T) {
+	provider := startFakeOIDCProvider(t, oidc.Config{})
+	h := handler{}
+
+	// The code of the victim's login is injected into the attacker's login,
+	// whose code verifier doesn't match the victim's challenge
+	victimCookie, victimAuthURL := beginTestOidcLogin(t, h)
+	code := provider.authorize(t, victimAuthURL, jwt.MapClaims{"sub": "alice"})
+	attackerCookie, attackerAuthURL := beginTestOidcLogin(t, h)
+
+	if w := finishTestOidcLogin(h, attackerCookie, code, attackerAuthURL.Query().Get("state")); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with code of another login responded %d", w.Code)
+	}
+
+	code = provider.authorize(t, victimAuthURL, jwt.MapClaims{"sub": "alice"})
+	if err := exchangeTestOidcLogin(t, victimCookie, code); err != nil {
+		t.Errorf("code with the login's own verifier was rejected: %v", err)
+	}
+}
+
+func TestOidcLoginLinksVerifiedEmail(t *testing.T) {
+	h := handler{DB: openTestDB(t)}
+	provider := startFakeOIDCProvider(t, oidc.Config{AutoProvision: false})
+
+	suffix, err := auth.GenerateRandomToken(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := "oidc" + strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(suffix))
+	email := account + "@example.com"
+	user := models.Users{Acct: account, FullName: "OIDC Test", Email: &email, EmailVerified: true}
+	if result := h.DB.Create(&user); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() { h.DB.Delete(&models.Users{Acct: account}) })
+
+	login := func(claims jwt.MapClaims) *httptest.ResponseRecorder {
+		cookie, authURL := beginTestOidcLogin(t, h)
+		code := provider.authorize(t, authURL, claims)
+		return finishTestOidcLogin(h, cookie, code, authURL.Query().Get("state"))
+	}
+
+	// Unverified at the provider, so anyone could have claimed the address
+	w := login(jwt.MapClaims{"sub": account + "-unverified", "email": email, "email_verified": false})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("login with unverified email responded %d", w.Code)
+	}
+
+	w = login(jwt.MapClaims{"sub": account, "email": strings.ToUpper(email), "email_verified": true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login with verified email responded %d: %s", w.Code, w.Body)
+	}
+	var catResponse createAccessTokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&catResponse); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.ParseAccessToken(catResponse.AccessToken)
+	if err != nil || claims.Account != account {
+		t.Fatalf("got token for %v, %v", claims, err)
+	}
+
+	var identity models.UserIdentities
+	if result := h.DB.Where(&models.UserIdentities{Provider: testOIDCProvider, Subject: account}).First(&identity); result.Error != nil || identity.Acct != account {
+		t.Errorf("identity wasn't linked: %v", result.Error)
+	}
+}
@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/authenticator"
+	"uiassignment/internal/pkg/config"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/notify"
+	"uiassignment/internal/pkg/websocket"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// swagger:handlers changePasswordRequest
+// @Description JSON request body for changing password
+type changePasswordRequest struct {
+	// Current password of the account
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	// New password, must follow the password policy
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+// ChangePasswordHandler godoc
+// @Description Change password of the selected account. The session of the current request is kept, every other
+// @Description session and access tokens without a session, e.g. of OAuth clients, are revoked.
+// @Tags user
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Param Body body changePasswordRequest true "Current and new password"
+// @Success 204 "Password changed"
+// @Failure 400 {object} CommonResponse "Invalid request body or new password violates the password policy"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource or current password is wrong"
+// @Failure 404 "Account doesn't exist"
+// @Failure 409 {object} CommonResponse "Password of the account is managed by the LDAP directory"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/password [put]
+func (h handler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var cpRequest changePasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&cpRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(cpRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	var user models.Users
+	if result := h.DB.Where(&models.Users{Acct: account}).First(&user); result.Error != nil {
+		log.Println(result.Error)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if user.IsDirectoryUser() {
+		writeErrorMessage(w, http.StatusConflict, errPasswordManagedByDirectory.Error())
+		return
+	}
+	if !auth.IsPasswordMatched(user.Password, cpRequest.CurrentPassword) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if cpRequest.NewPassword == cpRequest.CurrentPassword {
+		writeErrorMessage(w, http.StatusBadRequest, auth.ErrPasswordNotChanged.Error())
+		return
+	}
+	if err := auth.ValidatePasswordPolicy(cpRequest.NewPassword, account); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	encryptedPassword, err := auth.EncryptPassword(cpRequest.NewPassword)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the session of this request and revoke the others. Tokens without a
+	// session can only be revoked all at once.
+	tokensValidAfter := time.Now()
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("acct = ? AND id <> ?", account, claims.SessionID).
+			Delete(&models.Sessions{}); result.Error != nil {
+			return result.Error
+		}
+		return tx.Model(&user).Updates(models.Users{
+			Password:         encryptedPassword,
+			TokensValidAfter: &tokensValidAfter}).Error
+	})
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r, audit.ActionPasswordChanged, account, account)
+	notificationMsg := fmt.Sprintf("Password changed for account: %s", account)
+	if err := h.Hub.SendToUser(r.Context(), account, websocket.NewEvent(websocket.EventPasswordChanged, account, notificationMsg)); err != nil {
+		log.Println(err.Error())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Verifies the password of the user against where it's kept, the users table
+// or the LDAP directory.
+func (h handler) isPasswordMatched(ctx context.Context, user models.Users, password string) (bool, error) {
+	if !user.IsDirectoryUser() {
+		return auth.IsPasswordMatched(user.Password, password), nil
+	}
+	identity, err := h.Authenticator.Authenticate(ctx, user.Acct, password)
+	if errors.Is(err, authenticator.ErrInvalidCredentials) || errors.Is(err, authenticator.ErrUserNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return identity.Account == user.Acct, nil
+}
+
+var (
+	// Time after issuing a reset token during which no other one is issued
+	// for the account, unless the token has been used
+	passwordResetInterval = config.GetDuration("PASSWORD_RESET_INTERVAL", 5*time.Minute)
+
+	// Password resets issued at once. Further requests are dropped meanwhile.
+	passwordResetSlots = make(chan struct{}, config.GetIntInRange("PASSWORD_RESET_CONCURRENCY", 8, 1, 1024))
+)
+
+var errPasswordResetsBusy = errors.New("too many password resets in progress, dropped a request")
+
+// swagger:handlers createPasswordResetRequest
+// @Description JSON request body for requesting a password reset
+type createPasswordResetRequest struct {
+	// User account
+	Acct string `json:"account" validate:"required,alphanum"`
+}
+
+// CreatePasswordResetHandler godoc
+// @Description Request a password reset token. The token is delivered to the account owner out of band.
+// @Description The response is the same whether the account exists or not.
+// @Tags passwordReset
+// @Produce application/json
+// @Param Body body createPasswordResetRequest true "Account to reset"
+// @Success 202 "Reset token will be delivered if the account exists"
+// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Router /v1/passwordResets [post]
+func (h handler) CreatePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var cprRequest createPasswordResetRequest
+
+	err := json.NewDecoder(r.Body).Decode(&cprRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(cprRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	// Issue the token in background so the response time doesn't reveal
+	// whether the account exists. Dropped requests get the same response.
+	select {
+	case passwordResetSlots <- struct{}{}:
+		go func() {
+			defer func() { <-passwordResetSlots }()
+			h.issuePasswordReset(cprRequest.Acct)
+		}()
+	default:
+		log.Println(errPasswordResetsBusy.Error())
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h handler) issuePasswordReset(account string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var user models.Users
+	if result := h.DB.WithContext(ctx).Where(&models.Users{Acct: account}).First(&user); result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			log.Println(result.Error)
+		}
+		return
+	}
+	// A local password would be ignored, directory users reset it in the directory
+	if user.IsDirectoryUser() {
+		return
+	}
+
+	token, tokenHash, expiresAt, err := auth.CreatePasswordResetToken()
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	// At most one token per interval, so requests can't flood the owner with
+	// messages. Locking the user serializes concurrent requests.
+	var throttled bool
+	err = h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("acct").
+			Where("acct = ?", user.Acct).First(&models.Users{}); result.Error != nil {
+			return result.Error
+		}
+		var recent int64
+		if result := tx.Model(&models.PasswordResets{}).
+			Where("acct = ? AND used_at IS NULL AND expires_at > ? AND created_at > ?",
+				user.Acct, time.Now(), time.Now().Add(-passwordResetInterval)).
+			Count(&recent); result.Error != nil {
+			return result.Error
+		}
+		if recent > 0 {
+			throttled = true
+			return nil
+		}
+		return tx.Create(&models.PasswordResets{
+			TokenHash: tokenHash,
+			Acct:      user.Acct,
+			ExpiresAt: expiresAt}).Error
+	})
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if throttled {
+		log.Println("Skipped password reset of account requested again: ", user.Acct)
+		return
+	}
+
+	err = h.Notifier.Send(ctx, notify.Message{
+		To:      recipientOf(user),
+		Subject: "Password reset",
+		Body: fmt.Sprintf("A password reset was requested for account %s.\n\n"+
+			"Reset token: %s\n\n"+
+			"Submit it with your new password to POST /api/v1/passwordResets/{token} before %s.\n"+
+			"If you didn't request this, you can ignore this message.",
+			user.Acct, token, expiresAt.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// swagger:handlers redeemPasswordResetRequest
+// @Description JSON request body for redeeming a password reset token
+type redeemPasswordResetRequest struct {
+	// New password, must follow the password policy
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+// RedeemPasswordResetHandler godoc
+// @Description Set a new password with a password reset token. The token can be used only once
+// @Description and all previously issued access tokens of the account are revoked.
+// @Tags passwordReset
+// @Produce application/json
+// @Param token path string true "Password reset token"
+// @Param Body body redeemPasswordResetRequest true "New password"
+// @Success 200 "Password has been reset"
+// @Failure 400 {object} CommonResponse "Invalid request body, invalid or expired token, or new password violates the password policy"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/passwordResets/{token} [post]
+func (h handler) RedeemPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var rprRequest redeemPasswordResetRequest
+
+	err := json.NewDecoder(r.Body).Decode(&rprRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(rprRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	tokenHash := auth.HashToken(vars["token"])
+
+	var passwordReset models.PasswordResets
+	if result := h.DB.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).
+		First(&passwordReset); result.Error != nil {
+		log.Println(result.Error)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			writeErrorMessage(w, http.StatusBadRequest, errInvalidPasswordResetToken.Error())
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := auth.ValidatePasswordPolicy(rprRequest.NewPassword, passwordReset.Acct); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	encryptedPassword, err := auth.EncryptPassword(rprRequest.NewPassword)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	tokensValidAfter := now
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// Claim the token first so concurrent requests can't redeem it twice
+		result := tx.Model(&models.PasswordResets{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errInvalidPasswordResetToken
+		}
+
+		// Outstanding tokens of the same account are invalidated as well
+		if result := tx.Model(&models.PasswordResets{}).
+			Where("acct = ? AND used_at IS NULL", passwordReset.Acct).
+			Update("used_at", now); result.Error != nil {
+			return result.Error
+		}
+
+		// The sessions are revoked along with the tokens
+		if result := tx.Where(&models.Sessions{Acct: passwordReset.Acct}).Delete(&models.Sessions{}); result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Model(&models.Users{Acct: passwordReset.Acct}).Updates(models.Users{
+			Password:         encryptedPassword,
+			TokensValidAfter: &tokensValidAfter}).Error
+	})
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, errInvalidPasswordResetToken) {
+			writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	audit.Log(r, audit.ActionPasswordReset, passwordReset.Acct, passwordReset.Acct)
+	notificationMsg := fmt.Sprintf("Password reset for account: %s", passwordReset.Acct)
+	if err := h.Hub.SendToUser(r.Context(), passwordReset.Acct, websocket.NewEvent(websocket.EventPasswordReset, passwordReset.Acct, notificationMsg)); err != nil {
+		log.Println(err.Error())
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+var (
+	errInvalidPasswordResetToken  = errors.New("invalid or expired password reset token")
+	errPasswordManagedByDirectory = errors.New("password is managed by the LDAP directory")
+)
+
+// Address for delivering notifications to the given user. Unverified emails
+// aren't trusted, the account based address is used instead.
+func recipientOf(user models.Users) string {
+	if user.Email != nil && user.EmailVerified {
+		return *user.Email
+	}
+	return user.Acct + "@" + notify.RecipientDomain
+}
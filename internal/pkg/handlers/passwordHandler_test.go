@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/middlewares"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/notify"
+	"uiassignment/internal/pkg/websocket"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+// Changing the password ends the other sessions of the account only.
+func TestChangePasswordKeepsCurrentSession(t *testing.T) {
+	h := handler{
+		DB:        openTestDB(t),
+		Validator: validator.New(),
+		Hub:       websocket.NewHub(nil, websocket.NewLocalBackplane(), nil, nil, nil),
+	}
+
+	account := newTestAccount(t, "password")
+	password, err := auth.EncryptPassword("Current-Passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := h.DB.Create(&models.Users{Acct: account, Password: password, FullName: "Password Test"}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("acct = ?", account).Delete(&models.Sessions{})
+		h.DB.Where("acct = ?", account).Delete(&models.Users{})
+	})
+
+	var sessionIDs []string
+	for i := 0; i < 2; i++ {
+		session, err := newSession(httptest.NewRequest(http.MethodPost, "/api/v1/accessToken", nil),
+			account, auth.SessionKindToken, time.Hour, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := h.DB.Create(&session); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		sessionIDs = append(sessionIDs, session.ID)
+	}
+	current, other := sessionIDs[0], sessionIDs[1]
+
+	// Tokens without a session, issued in the same second as the change
+	if time.Now().Nanosecond() > 500*int(time.Millisecond) {
+		time.Sleep(time.Second - time.Duration(time.Now().Nanosecond()))
+	}
+	oldToken, _, err := auth.CreateAccessTokenForUser(account, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldClaims, err := auth.ParseAccessToken(oldToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/api/v1/users/"+account+"/password",
+		strings.NewReader(`{"currentPassword":"Current-Passw0rd","newPassword":"Changed-Passw0rd"}`))
+	r = mux.SetURLVars(r, map[string]string{"account": account})
+	claims := &auth.Claims{Account: account, SessionID: current}
+	r = r.WithContext(context.WithValue(r.Context(), "tokenClaims", claims))
+	w := httptest.NewRecorder()
+	h.ChangePasswordHandler(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("responded %d: %s", w.Code, w.Body.String())
+	}
+
+	var remaining []string
+	if result := h.DB.Model(&models.Sessions{}).Where("acct = ?", account).Pluck("id", &remaining); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if len(remaining) != 1 || remaining[0] != current {
+		t.Errorf("got sessions %v, want only %s of %s", remaining, current, other)
+	}
+
+	var user models.Users
+	if result := h.DB.Where("acct = ?", account).First(&user); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if !auth.IsPasswordMatched(user.Password, "Changed-Passw0rd") {
+		t.Error("password wasn't changed")
+	}
+	// Logging in again right after the change works, even in the same second
+	newToken, _, err := auth.CreateAccessTokenForUser(account, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClaims, err := auth.ParseAccessToken(newToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newClaims.IssuedAt != oldClaims.IssuedAt {
+		t.Logf("tokens weren't issued in the same second")
+	}
+
+	currentToken, _, err := auth.CreateAccessTokenForUser(account, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _, err := auth.CreateAccessTokenForUser(account, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := middlewares.AccessTokenValidator(h.DB)
+	for name, want := range map[string]bool{
+		oldToken:     false,
+		newToken:     true,
+		currentToken: true,
+		otherToken:   false,
+	} {
+		if _, err := validate(name); (err == nil) != want {
+			t.Errorf("token %s... got error %v, want valid %t", name[len(name)-8:], err, want)
+		}
+	}
+}
+
+// A route missing the access check doesn't panic the request.
+func TestChangePasswordRequiresClaims(t *testing.T) {
+	h := handler{Validator: validator.New()}
+	r := httptest.NewRequest(http.MethodPut, "/api/v1/users/alice/password",
+		strings.NewReader(`{"currentPassword":"Current-Passw0rd","newPassword":"Changed-Passw0rd"}`))
+	r = mux.SetURLVars(r, map[string]string{"account": "alice"})
+	w := httptest.NewRecorder()
+	h.ChangePasswordHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("responded %d", w.Code)
+	}
+}
+
+// Records the messages it's asked to send
+type recordingNotifier struct {
+	mutex    sync.Mutex
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, message notify.Message) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+func (n *recordingNotifier) sent() []notify.Message {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return append([]notify.Message(nil), n.messages...)
+}
+
+// Repeated requests don't send the owner another token until the interval
+// passed or the token was used.
+func TestPasswordResetIsThrottled(t *testing.T) {
+	notifier := &recordingNotifier{}
+	h := handler{DB: openTestDB(t), Notifier: notifier}
+
+	account := newTestAccount(t, "reset")
+	if result := h.DB.Create(&models.Users{Acct: account, FullName: "Reset Test"}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("acct = ?", account).Delete(&models.PasswordResets{})
+		h.DB.Where("acct = ?", account).Delete(&models.Users{})
+	})
+	countTokens := func() int64 {
+		var tokens int64
+		if result := h.DB.Model(&models.PasswordResets{}).Where("acct = ?", account).Count(&tokens); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		return tokens
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.issuePasswordReset(account)
+		}()
+	}
+	wg.Wait()
+	if sent, tokens := len(notifier.sent()), countTokens(); sent != 1 || tokens != 1 {
+		t.Fatalf("concurrent requests sent %d messages and issued %d tokens, want 1", sent, tokens)
+	}
+
+	// A used token doesn't hold back the next one
+	if result := h.DB.Model(&models.PasswordResets{}).Where("acct = ?", account).
+		Update("used_at", time.Now()); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	h.issuePasswordReset(account)
+	if sent, tokens := len(notifier.sent()), countTokens(); sent != 2 || tokens != 2 {
+		t.Fatalf("got %d messages and %d tokens after using the token, want 2", sent, tokens)
+	}
+
+	// Neither does one issued before the interval
+	if result := h.DB.Model(&models.PasswordResets{}).Where("acct = ?", account).
+		Update("created_at", time.Now().Add(-passwordResetInterval-time.Second)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	h.issuePasswordReset(account)
+	if sent := len(notifier.sent()); sent != 3 {
+		t.Errorf("got %d messages after the interval, want 3", sent)
+	}
+}
+
+// Requests beyond the concurrent resets get the same response without
+// starting another one.
+func TestPasswordResetsAreBounded(t *testing.T) {
+	previous := passwordResetSlots
+	passwordResetSlots = make(chan struct{}, 1)
+	t.Cleanup(func() { passwordResetSlots = previous })
+	passwordResetSlots <- struct{}{}
+
+	// Without a database, a reset started anyway would panic
+	notifier := &recordingNotifier{}
+	h := handler{Validator: validator.New(), Notifier: notifier}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.CreatePasswordResetHandler(w, httptest.NewRequest(http.MethodPost, "/api/v1/passwordResets",
+			strings.NewReader(`{"account":"alice"}`)))
+		if w.Code != http.StatusAccepted {
+			t.Errorf("responded %d", w.Code)
+		}
+	}
+	if len(passwordResetSlots) != 1 || len(notifier.sent()) > 0 {
+		t.Error("dropped requests started resets")
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ListPresenceHandler godoc
+// @Description List accounts connected to the websocket hub of any replica, with their presence.
+// @Description Connections are idle when they sent nothing for PRESENCE_IDLE_AFTER. Changes are published
+// @Description as events to the websocket topic presence.
+// @Tags presence
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Success 200 {array} websocket.Presence
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 500 "Internal error caused by the websocket hub or JSON parsing failure"
+// @Router /v1/presence [get]
+func (h handler) ListPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	presence, err := h.Hub.Presence(r.Context())
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(presence)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// swagger:handlers createSessionResponse
+// @Description Session created for a browser
+type createSessionResponse struct {
+	// CSRF token to be sent in X-CSRF-Token with state-changing requests, also readable from the CSRF cookie
+	CSRFToken string `json:"csrfToken"`
+	// The time when the session expires unless it's used
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CreateSessionHandler godoc
+// @Description Start a browser session for the token owner. The session is kept in an HttpOnly cookie, so the
+// @Description access token from any login flow can be discarded afterwards. Logins can also start a session
+// @Description directly with ?session=cookie. State-changing requests of the session must send the returned
+// @Description CSRF token in X-CSRF-Token.
+// @Tags session
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Success 201 {object} createSessionResponse
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Token issued to an OAuth client or API key can't start sessions"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/sessions [post]
+func (h handler) CreateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	h.writeCookieSession(w, r, tokenOwner, http.StatusCreated)
+}
+
+// Reports whether a login asks for a browser session cookie instead of an
+// access token in the response body.
+func isCookieSessionRequested(r *http.Request) bool {
+	return r.URL.Query().Get("session") == "cookie"
+}
+
+// Starts a browser session of the account, setting its cookies and responding
+// with its CSRF token. The session token never appears in the body.
+func (h handler) writeCookieSession(w http.ResponseWriter, r *http.Request, account string, status int) {
+	sessionToken, sessionTokenHash, id, err := auth.CreateSessionToken()
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session, err := newSession(r, account, auth.SessionKindCookie, auth.SessionIdleTTL, auth.SessionMaxTTL)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	session.ID = id
+	session.TokenHash = &sessionTokenHash
+	if result := h.DB.Create(&session); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	csrfToken := auth.CSRFTokenFor(id)
+	setSessionCookies(w, sessionToken, csrfToken, int(auth.SessionMaxTTL.Seconds()))
+
+	var csResponse createSessionResponse
+	csResponse.CSRFToken = csrfToken
+	csResponse.ExpiresAt = session.ExpiresAt
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(csResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteCurrentSessionHandler godoc
+// @Description Log out of the current browser session
+// @Tags session
+// @Param X-CSRF-Token header string true "CSRF token of the session"
+// @Success 200 "Successfully logged out"
+// @Failure 400 "Request isn't authenticated by a session"
+// @Failure 401 "Missing valid session"
+// @Failure 403 "Invalid CSRF token"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/sessions/current [delete]
+func (h handler) DeleteCurrentSessionHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if len(claims.SessionID) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if result := h.DB.Delete(&models.Sessions{ID: claims.SessionID}); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookies(w, "", "", -1)
+	w.WriteHeader(http.StatusOK)
+}
+
+// swagger:handlers sessionResponse
+// @Description Login session of a user
+type sessionResponse struct {
+	models.Sessions
+	// Whether the request was made with this session
+	Current bool `json:"current"`
+}
+
+// ListSessionsHandler godoc
+// @Description List active sessions of the selected account, i.e. logins which issued an access token and browser sessions.
+// @Description Admins can list sessions of any account at /v1/admin/users/{account}/sessions.
+// @Tags session
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param account path string true "User account"
+// @Success 200 {array} sessionResponse
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/sessions [get]
+func (h handler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var sessions []models.Sessions
+	if result := h.DB.Where("acct = ? AND expires_at > ?", account, time.Now()).
+		Order("last_seen_at desc").Find(&sessions); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var sResponses = []sessionResponse{}
+	for _, session := range sessions {
+		sResponses = append(sResponses, sessionResponse{
+			Sessions: session,
+			Current:  session.ID == claims.SessionID,
+		})
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(sResponses)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// RevokeSessionHandler godoc
+// @Description Revoke a session of the selected account. Its access token or cookie is rejected from then on.
+// @Description Admins can revoke sessions of any account at /v1/admin/users/{account}/sessions/{id}.
+// @Tags session
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param account path string true "User account"
+// @Param id path string true "Session ID"
+// @Success 200 "Successfully revoked the session"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 404 "Session doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/users/{account}/sessions/{id} [delete]
+func (h handler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+	id := vars["id"]
+
+	result := h.DB.Where(&models.Sessions{ID: id, Acct: account}).Delete(&models.Sessions{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	audit.Log(r, audit.ActionSessionRevoked, tokenOwner, account)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Describes a new session of the account for the client of the request. The
+// session expires after being idle for idleTTL, or after maxTTL at the latest.
+func newSession(r *http.Request, account string, kind string, idleTTL time.Duration, maxTTL time.Duration) (models.Sessions, error) {
+	id, err := auth.NewSessionID()
+	if err != nil {
+		return models.Sessions{}, err
+	}
+
+	now := time.Now()
+	session := models.Sessions{
+		ID:           id,
+		Acct:         account,
+		Kind:         kind,
+		Device:       describeDevice(r.UserAgent()),
+		IP:           audit.RemoteIP(r),
+		UserAgent:    truncate(r.UserAgent(), 255),
+		CreatedAt:    now,
+		LastSeenAt:   now,
+		ExpiresAt:    now.Add(idleTTL),
+		MaxExpiresAt: now.Add(maxTTL),
+	}
+	if session.ExpiresAt.After(session.MaxExpiresAt) {
+		session.ExpiresAt = session.MaxExpiresAt
+	}
+	return session, nil
+}
+
+// Browsers and systems recognized in user agents, most specific first
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"}, {"Go-http-client/", "Go"}, {"python-requests/", "Python"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// Short human readable description of the device of a user agent, e.g. Firefox on Linux.
+func describeDevice(userAgent string) string {
+	var browser, system string
+	for _, candidate := range userAgentBrowsers {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+	for _, candidate := range userAgentSystems {
+		if strings.Contains(userAgent, candidate.token) {
+			system = candidate.name
+			break
+		}
+	}
+
+	switch {
+	case len(browser) > 0 && len(system) > 0:
+		return browser + " on " + system
+	case len(browser) > 0:
+		return browser
+	case len(system) > 0:
+		return system
+	}
+	return "Unknown device"
+}
+
+// Sets or, with a negative max age, clears the session and CSRF cookies. The
+// CSRF cookie is readable by scripts of our origin, which is what proves a
+// request came from them.
+func setSessionCookies(w http.ResponseWriter, sessionToken string, csrfToken string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookieName,
+		Value:    sessionToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   auth.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   auth.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func truncate(value string, maxLength int) string {
+	if len(value) > maxLength {
+		return strings.ToValidUTF8(value[:maxLength], "")
+	}
+	return value
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Handlers behind a misconfigured route answer 401 rather than panic.
+func TestSessionHandlersRequireClaims(t *testing.T) {
+	h := handler{}
+
+	w := httptest.NewRecorder()
+	h.DeleteCurrentSessionHandler(w, httptest.NewRequest(http.MethodDelete, "/api/v1/sessions/current", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("deleting the current session got status %d", w.Code)
+	}
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v1/users/alice/sessions", nil),
+		map[string]string{"account": "alice"})
+	w = httptest.NewRecorder()
+	h.ListSessionsHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("listing sessions got status %d", w.Code)
+	}
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/websocket"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Number of recovery codes issued when enabling TOTP
+const recoveryCodeCount = 10
+
+var (
+	errTotpAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	errTotpNotEnrolled    = errors.New("two-factor authentication enrollment hasn't been started")
+	errTotpNotEnabled     = errors.New("two-factor authentication isn't enabled")
+	errTotpLocked         = errors.New("too many failed attempts, try again later")
+	errInvalidTotpCode    = errors.New("invalid two-factor code")
+)
+
+// swagger:handlers enrollTotpResponse
+// @Description TOTP secret to be added to an authenticator app
+type enrollTotpResponse struct {
+	// Base32 encoded shared secret
+	Secret string `json:"secret"`
+	// otpauth:// URI, usually shown as QR code
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+// EnrollTotpHandler godoc
+// @Description Start TOTP enrollment for the selected account. Enrollment has to be confirmed with a first code.
+// @Tags twoFactor
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Success 201 {object} enrollTotpResponse
+// @Failure 400 {object} CommonResponse "TOTP is already enabled"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/totp [post]
+func (h handler) EnrollTotpHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	var credential models.TotpCredentials
+	result := h.DB.Where(&models.TotpCredentials{Acct: account}).Limit(1).Find(&credential)
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if credential.Enabled {
+		writeErrorMessage(w, http.StatusBadRequest, errTotpAlreadyEnabled.Error())
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Restarting enrollment replaces the pending secret
+	if result := h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "acct"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "last_used_step", "failed_attempts", "locked_until", "updated_at"}),
+	}).Create(&models.TotpCredentials{Acct: account, Secret: secret}); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var etResponse enrollTotpResponse
+	etResponse.Secret = secret
+	etResponse.OtpauthURI = auth.TOTPProvisioningURI(account, secret)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(etResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// swagger:handlers confirmTotpRequest
+// @Description JSON request body for confirming TOTP enrollment
+type confirmTotpRequest struct {
+	// Current code from the authenticator app
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+// swagger:handlers confirmTotpResponse
+// @Description One-time recovery codes, shown only once
+type confirmTotpResponse struct {
+	// Recovery codes usable in place of a TOTP code, each one only once
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// ConfirmTotpHandler godoc
+// @Description Confirm TOTP enrollment with a first code. Enables two-factor login and returns recovery codes.
+// @Tags twoFactor
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Param Body body confirmTotpRequest true "First TOTP code"
+// @Success 200 {object} confirmTotpResponse
+// @Failure 400 {object} CommonResponse "Invalid request body, invalid code or enrollment not started"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/totp/confirm [post]
+func (h handler) ConfirmTotpHandler(w http.ResponseWriter, r *http.Request) {
+	var ctRequest confirmTotpRequest
+
+	err := json.NewDecoder(r.Body).Decode(&ctRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(ctRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var credential models.TotpCredentials
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.TotpCredentials{Acct: account}).First(&credential); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return errTotpNotEnrolled
+			}
+			return result.Error
+		}
+		if credential.Enabled {
+			return errTotpAlreadyEnabled
+		}
+
+		step, ok := auth.ValidateTOTPCode(credential.Secret, ctRequest.Code, time.Now(), credential.LastUsedStep)
+		if !ok {
+			return errInvalidTotpCode
+		}
+		if result := tx.Model(&credential).Updates(map[string]interface{}{
+			"enabled":        true,
+			"last_used_step": step,
+		}); result.Error != nil {
+			return result.Error
+		}
+
+		return replaceRecoveryCodes(tx, account, recoveryCodes)
+	})
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, errTotpNotEnrolled) || errors.Is(err, errTotpAlreadyEnabled) || errors.Is(err, errInvalidTotpCode) {
+			writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	audit.Log(r, audit.ActionTotpEnabled, account, account)
+
+	var ctResponse confirmTotpResponse
+	ctResponse.RecoveryCodes = recoveryCodes
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(ctResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// swagger:handlers disableTotpRequest
+// @Description JSON request body for disabling TOTP, requires re-authentication
+type disableTotpRequest struct {
+	// Current password of the account
+	Password string `json:"password" validate:"required"`
+	// Current TOTP code or an unused recovery code
+	Code string `json:"code" validate:"required"`
+}
+
+// DisableTotpHandler godoc
+// @Description Disable two-factor login for the selected account. Requires the password and a current code.
+// @Tags twoFactor
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Param Body body disableTotpRequest true "Password and TOTP or recovery code"
+// @Success 200 "Two-factor login disabled"
+// @Failure 400 {object} CommonResponse "Invalid request body or TOTP isn't enabled"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 {object} CommonResponse "Current token owner has no right to access this resource, or wrong password or code"
+// @Failure 429 {object} CommonResponse "Too many failed attempts"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/users/{account}/totp [delete]
+func (h handler) DisableTotpHandler(w http.ResponseWriter, r *http.Request) {
+	var dtRequest disableTotpRequest
+
+	err := json.NewDecoder(r.Body).Decode(&dtRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(dtRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	var user models.Users
+	if result := h.DB.Where(&models.Users{Acct: account}).First(&user); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	passwordMatched, err := h.isPasswordMatched(r.Context(), user, dtRequest.Password)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !passwordMatched {
+		writeErrorMessage(w, http.StatusForbidden, "wrong password")
+		return
+	}
+
+	if err := h.verifySecondFactor(account, dtRequest.Code); err != nil {
+		h.writeSecondFactorError(w, err)
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("acct = ?", account).Delete(&models.TotpRecoveryCodes{}); result.Error != nil {
+			return result.Error
+		}
+		return tx.Where("acct = ?", account).Delete(&models.TotpCredentials{}).Error
+	})
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r, audit.ActionTotpDisabled, account, account)
+	notificationMsg := fmt.Sprintf("Two-factor authentication disabled for account: %s", account)
+	if err := h.Hub.SendToUser(r.Context(), account, websocket.NewEvent(websocket.EventTotpDisabled, account, notificationMsg)); err != nil {
+		log.Println(err.Error())
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Stores hashes of new recovery codes, dropping the previous ones.
+func replaceRecoveryCodes(tx *gorm.DB, account string, recoveryCodes []string) error {
+	if result := tx.Where("acct = ?", account).Delete(&models.TotpRecoveryCodes{}); result.Error != nil {
+		return result.Error
+	}
+	rows := make([]models.TotpRecoveryCodes, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		rows[i] = models.TotpRecoveryCodes{CodeHash: auth.HashToken(code), Acct: account}
+	}
+	return tx.Create(&rows).Error
+}
+
+// Checks a TOTP code or recovery code of an account with TOTP enabled.
+// Used codes can't be reused and verification is locked after too many failures.
+func (h handler) verifySecondFactor(account string, code string) error {
+	var verifyErr error
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var credential models.TotpCredentials
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.TotpCredentials{Acct: account, Enabled: true}).First(&credential); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				verifyErr = errTotpNotEnabled
+				return nil
+			}
+			return result.Error
+		}
+
+		now := time.Now()
+		if credential.LockedUntil != nil && credential.LockedUntil.After(now) {
+			verifyErr = errTotpLocked
+			return nil
+		}
+
+		if step, ok := auth.ValidateTOTPCode(credential.Secret, code, now, credential.LastUsedStep); ok {
+			return tx.Model(&credential).Updates(map[string]interface{}{
+				"last_used_step":  step,
+				"failed_attempts": 0,
+				"locked_until":    nil,
+			}).Error
+		}
+
+		result := tx.Model(&models.TotpRecoveryCodes{}).
+			Where("code_hash = ? AND acct = ? AND used_at IS NULL", auth.HashToken(auth.NormalizeRecoveryCode(code)), account).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			return tx.Model(&credential).Updates(map[string]interface{}{
+				"failed_attempts": 0,
+				"locked_until":    nil,
+			}).Error
+		}
+
+		verifyErr = errInvalidTotpCode
+		updates := map[string]interface{}{"failed_attempts": credential.FailedAttempts + 1}
+		if credential.FailedAttempts+1 >= auth.TOTPMaxFailedAttempts {
+			updates["failed_attempts"] = 0
+			updates["locked_until"] = now.Add(auth.TOTPLockout)
+		}
+		return tx.Model(&credential).Updates(updates).Error
+	})
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+// Responds with the status matching an error returned by verifySecondFactor.
+func (h handler) writeSecondFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTotpNotEnabled):
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errInvalidTotpCode):
+		writeErrorMessage(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errTotpLocked):
+		writeErrorMessage(w, http.StatusTooManyRequests, err.Error())
+	default:
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
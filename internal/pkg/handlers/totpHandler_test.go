@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+)
+
+// Creates an account with TOTP enabled and the given recovery codes.
+func newTestTotpAccount(t *testing.T, h handler, recoveryCodes []string) (account string, secret string) {
+	t.Helper()
+	account = newTestAccount(t, "totp")
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := h.DB.Create(&models.Users{Acct: account, FullName: "TOTP Test"}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("acct = ?", account).Delete(&models.TotpRecoveryCodes{})
+		h.DB.Where("acct = ?", account).Delete(&models.TotpCredentials{})
+		h.DB.Where("acct = ?", account).Delete(&models.Users{})
+	})
+	if result := h.DB.Create(&models.TotpCredentials{Acct: account, Secret: secret, Enabled: true}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if err := replaceRecoveryCodes(h.DB, account, recoveryCodes); err != nil {
+		t.Fatal(err)
+	}
+	return account, secret
+}
+
+func TestVerifySecondFactorRejectsReplays(t *testing.T) {
+	h := handler{DB: openTestDB(t)}
+	account, secret := newTestTotpAccount(t, h, []string{"aaaaaaaa-bbbbbbbb"})
+
+	code, err := auth.GenerateTOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.verifySecondFactor(account, code); err != nil {
+		t.Fatalf("current code got error %v", err)
+	}
+	if err := h.verifySecondFactor(account, code); !errors.Is(err, errInvalidTotpCode) {
+		t.Errorf("replayed code got error %v, want %v", err, errInvalidTotpCode)
+	}
+
+	var credential models.TotpCredentials
+	if result := h.DB.Where("acct = ?", account).First(&credential); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if credential.LastUsedStep != time.Now().Unix()/30 && credential.LastUsedStep != time.Now().Unix()/30-1 {
+		t.Errorf("got last used step %d", credential.LastUsedStep)
+	}
+}
+
+func TestVerifySecondFactorRecoveryCodesAreSingleUse(t *testing.T) {
+	h := handler{DB: openTestDB(t)}
+	account, _ := newTestTotpAccount(t, h, []string{"aaaaaaaa-bbbbbbbb", "cccccccc-dddddddd"})
+
+	// Typed without the dash and in upper case
+	if err := h.verifySecondFactor(account, "AAAAAAAABBBBBBBB"); err != nil {
+		t.Fatalf("recovery code got error %v", err)
+	}
+	if err := h.verifySecondFactor(account, "aaaaaaaa-bbbbbbbb"); !errors.Is(err, errInvalidTotpCode) {
+		t.Errorf("used recovery code got error %v, want %v", err, errInvalidTotpCode)
+	}
+	if err := h.verifySecondFactor(account, "cccccccc-dddddddd"); err != nil {
+		t.Errorf("other recovery code got error %v", err)
+	}
+
+	// Recovery codes of another account don't count
+	other, _ := newTestTotpAccount(t, h, []string{"eeeeeeee-ffffffff"})
+	if err := h.verifySecondFactor(account, "eeeeeeee-ffffffff"); !errors.Is(err, errInvalidTotpCode) {
+		t.Errorf("recovery code of %s got error %v", other, err)
+	}
+}
+
+func TestVerifySecondFactorLocksOut(t *testing.T) {
+	h := handler{DB: openTestDB(t)}
+	account, secret := newTestTotpAccount(t, h, []string{"aaaaaaaa-bbbbbbbb"})
+
+	for attempt := 1; attempt <= auth.TOTPMaxFailedAttempts; attempt++ {
+		if err := h.verifySecondFactor(account, "000000x"); !errors.Is(err, errInvalidTotpCode) {
+			t.Fatalf("attempt %d got error %v", attempt, err)
+		}
+	}
+
+	// Even valid codes are refused while locked
+	code, err := auth.GenerateTOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, valid := range []string{code, "aaaaaaaa-bbbbbbbb"} {
+		if err := h.verifySecondFactor(account, valid); !errors.Is(err, errTotpLocked) {
+			t.Errorf("code while locked got error %v, want %v", err, errTotpLocked)
+		}
+	}
+
+	// Once the lockout is over the codes work again
+	if result := h.DB.Model(&models.TotpCredentials{}).Where("acct = ?", account).
+		Update("locked_until", time.Now().Add(-time.Second)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if err := h.verifySecondFactor(account, code); err != nil {
+		t.Errorf("code after the lockout got error %v", err)
+	}
+}
+
+func TestVerifySecondFactorRequiresEnabledTotp(t *testing.T) {
+	h := handler{DB: openTestDB(t)}
+	if err := h.verifySecondFactor(newTestAccount(t, "nototp"), "123456"); !errors.Is(err, errTotpNotEnabled) {
+		t.Errorf("got error %v, want %v", err, errTotpNotEnabled)
+	}
+}
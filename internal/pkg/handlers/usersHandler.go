@@ -5,14 +5,18 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 	"uiassignment/internal/pkg/auth"
 	"uiassignment/internal/pkg/db"
+	"uiassignment/internal/pkg/events"
 	"uiassignment/internal/pkg/models"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"github.com/jackc/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var queryDecoder = schema.NewDecoder()
@@ -21,7 +25,9 @@ var queryDecoder = schema.NewDecoder()
 // @Description Get a list of user accounts and names with paging
 // @Tags user
 // @Produce application/json
-// @Param X-Accesstoken header string true "Access token"
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
 // @Param fullName query string false "Filter by user's full name"
 // @Param limit query int false "Max items per page(min=5, max=100, default=5)"
 // @Param page query int false "Requested page"
@@ -98,13 +104,63 @@ func (h handler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// swagger:handlers userResponse
+// @Description User data with presence. Other users only see the account, full name, times and presence.
+type userResponse struct {
+	// User account
+	Acct string `json:"account"`
+	// User's full name
+	FullName string `json:"fullName"`
+	// User's email address, shown to the owner only
+	Email *string `json:"email,omitempty"`
+	// Whether the owner has verified the email address, shown to the owner and admins only
+	EmailVerified *bool `json:"emailVerified,omitempty"`
+	// User's roles, space separated, shown to the owner and admins only
+	Roles string `json:"roles,omitempty"`
+	// The time when the account was created
+	CreatedAt time.Time `json:"createdAt"`
+	// The time when the account was last updated
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Whether the user is connected to the websocket hub of any replica
+	Online bool `json:"online"`
+}
+
+// Projects the user for the token of the claims. The owner sees the email
+// address, the owner and first-party tokens of admins see the roles and
+// whether the address is verified.
+func newUserResponse(user models.Users, claims *auth.Claims, online bool) userResponse {
+	uResponse := userResponse{
+		Acct:      user.Acct,
+		FullName:  user.FullName,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Online:    online,
+	}
+	if claims == nil {
+		return uResponse
+	}
+	isOwner := claims.Account == user.Acct
+	if isOwner {
+		uResponse.Email = user.Email
+	}
+	if isOwner || (claims.IsFirstParty() && claims.HasRole(auth.AdminRole)) {
+		emailVerified := user.EmailVerified
+		uResponse.EmailVerified = &emailVerified
+		uResponse.Roles = user.Roles
+	}
+	return uResponse
+}
+
 // GetUserByAccountHandler godoc
-// @Description Get user details by the selected account
+// @Description Get user details by the selected account. The email address is only shown to the owner,
+// @Description roles and whether the address is verified to the owner and admins.
 // @Tags user
 // @Produce application/json
-// @Param X-Accesstoken header string true "Access token"
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
 // @Param account path string true "User account"
-// @Success 200 {object} models.Users
+// @Success 200 {object} userResponse
 // @Failure 401 "Missing valid acces token for accessing this resource"
 // @Failure 404 "Account doesn't exist"
 // @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
@@ -124,15 +180,16 @@ func (h handler) GetUserByAccountHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Omit password field if the requester is not account owner
-	tokenOwner := r.Context().Value("tokenOwner")
-	if tokenOwner != user.Acct {
-		user.Password = ""
+	claims, _ := r.Context().Value("tokenClaims").(*auth.Claims)
+
+	online, err := h.Hub.IsOnline(r.Context(), user.Acct)
+	if err != nil {
+		log.Println(err.Error())
 	}
 
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	err := json.NewEncoder(w).Encode(user)
+	err = json.NewEncoder(w).Encode(newUserResponse(user, claims, online))
 	if err != nil {
 		log.Println(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -145,10 +202,12 @@ func (h handler) GetUserByAccountHandler(w http.ResponseWriter, r *http.Request)
 type createUserRequest struct {
 	// User account, alphanumeric only
 	Acct string `json:"account" validate:"required,alphanum"`
-	// Password, alphanumeric only(Length: min=6, max=40)
-	Password string `json:"password" validate:"required,alphanum,min=6,max=40"`
+	// Password, must follow the password policy
+	Password string `json:"password" validate:"required"`
 	// User's full name(Length: min=1, max=50)
 	FullName string `json:"fullName" validate:"required,min=1,max=50"`
+	// User's email address, optional. A verification link will be sent to it.
+	Email string `json:"email" validate:"omitempty,email,max=254"`
 }
 
 // CreateUserHandler godoc
@@ -184,6 +243,11 @@ func (h handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := auth.ValidatePasswordPolicy(cuRequest.Password, cuRequest.Acct); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	encryptedPassword, err := auth.EncryptPassword(cuRequest.Password)
 	if err != nil {
 		log.Println(err.Error())
@@ -191,14 +255,27 @@ func (h handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if result := h.DB.Create(&models.Users{
+	// Emails are unique once verified, so an unverified one may be in use by others
+	user := models.Users{
 		Acct:     cuRequest.Acct,
 		Password: encryptedPassword,
-		FullName: cuRequest.FullName}); result.Error != nil {
-		log.Println(result.Error)
+		FullName: cuRequest.FullName,
+	}
+	email := strings.ToLower(cuRequest.Email)
+	if len(email) > 0 {
+		user.Email = &email
+	}
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(&user); result.Error != nil {
+			return result.Error
+		}
+		return writeEvent(tx, events.UserCreated, cuRequest.Acct, events.UserData{FullName: cuRequest.FullName})
+	})
+	if err != nil {
+		log.Println(err.Error())
 
 		var duplicateEntryError = &pgconn.PgError{Code: "23505"}
-		if errors.As(result.Error, &duplicateEntryError) {
+		if errors.As(err, &duplicateEntryError) {
 			w.WriteHeader(http.StatusBadRequest)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -206,6 +283,10 @@ func (h handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(email) > 0 {
+		go h.sendEmailVerification(cuRequest.Acct, email)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -213,7 +294,9 @@ func (h handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 // @Description Delete user by the given account
 // @Tags user
 // @Produce application/json
-// @Param X-Accesstoken header string true "Access token"
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
 // @Param account path string true "User account"
 // @Success 200 "Successfully deleted the user"
 // @Failure 401 "Missing valid acces token for accessing this resource"
@@ -224,9 +307,16 @@ func (h handler) DeleteUserByAccountHandler(w http.ResponseWriter, r *http.Reque
 	vars := mux.Vars(r)
 	account := vars["account"]
 
-	if result := h.DB.Delete(&models.Users{Acct: account}); result.Error != nil {
-		log.Println(result.Error)
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Delete(&models.Users{Acct: account}); result.Error != nil {
+			return result.Error
+		}
+		return writeEvent(tx, events.UserDeleted, account, nil)
+	})
+	if err != nil {
+		log.Println(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -235,31 +325,37 @@ func (h handler) DeleteUserByAccountHandler(w http.ResponseWriter, r *http.Reque
 // swagger:handlers updateUserRequest
 // @Description JSON request body for updating user
 type updateUserRequest struct {
-	// Password, alphanumeric only(Length: min=6, max=40)
-	Password string `json:"password" validate:"omitempty,alphanum,min=6,max=40"`
 	// User's full name(Length: min=1, max=50)
 	FullName string `json:"fullName" validate:"omitempty,min=1,max=50"`
+	// User's email address, changing it requires verifying the new address
+	Email string `json:"email" validate:"omitempty,email,max=254"`
 }
 
 // UpdateUserHandler godoc
-// @Description Update selected account's user data
+// @Description Update selected account's user data. Password can only be changed through PUT /v1/users/{account}/password
 // @Tags user
 // @Produce application/json
-// @Param X-Accesstoken header string true "Access token"
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
 // @Param account path string true "User account"
 // @Param Body body updateUserRequest true "Data for updating the user"
 // @Success 200 "Successfully updated the user"
-// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Failure 400 {object} CommonResponse "Invalid request body or unsupported field"
 // @Failure 401 "Missing valid acces token for accessing this resource"
 // @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 404 "Account doesn't exist"
 // @Failure 500 "Internal error caused by DB connection issue"
 // @Router /v1/users/{account} [patch]
 func (h handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var uuRequest updateUserRequest
 
-	err := json.NewDecoder(r.Body).Decode(&uuRequest)
+	// Reject fields which can't be updated here, e.g. password
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&uuRequest)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -278,23 +374,43 @@ func (h handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var encryptedPassword string
-	encryptedPassword, err = auth.EncryptPassword(uuRequest.Password)
-	if err != nil {
-		log.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
 	vars := mux.Vars(r)
 	account := vars["account"]
 
-	user := models.Users{Acct: account}
-	if result := h.DB.Model(&user).Updates(models.Users{
-		Password: encryptedPassword,
-		FullName: uuRequest.FullName}); result.Error != nil {
-		log.Println(result.Error)
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	// Resubmitting the current email keeps it verified
+	var email string
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.Users
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("acct = ?", account).First(&user); result.Error != nil {
+			return result.Error
+		}
+
+		updates := map[string]interface{}{}
+		if len(uuRequest.FullName) > 0 && uuRequest.FullName != user.FullName {
+			updates["fullname"] = uuRequest.FullName
+		}
+		if len(uuRequest.Email) > 0 {
+			if normalized := strings.ToLower(uuRequest.Email); user.Email == nil || *user.Email != normalized {
+				email = normalized
+				updates["email"] = email
+				updates["email_verified"] = false
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		if result := tx.Model(&user).Updates(updates); result.Error != nil {
+			return result.Error
+		}
+		fullName, _ := updates["fullname"].(string)
+		return writeEvent(tx, events.UserUpdated, account,
+			events.UserData{FullName: fullName, EmailChanged: len(email) > 0})
+	})
+	if err != nil {
+		log.Println(err.Error())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -302,5 +418,9 @@ func (h handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(email) > 0 {
+		go h.sendEmailVerification(account, email)
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
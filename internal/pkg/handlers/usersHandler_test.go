@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+func TestCreateUserRequestEmail(t *testing.T) {
+	validate := validator.New()
+	for email, valid := range map[string]bool{
+		"":                  true,
+		"alice@example.com": true,
+		"alice":             false,
+		strings.Repeat("a", 243) + "@example.com": false,
+	} {
+		request := createUserRequest{Acct: "alice", Password: "Secret-Passw0rd", FullName: "Alice", Email: email}
+		if err := validate.Struct(request); (err == nil) != valid {
+			t.Errorf("email %q got error %v", email, err)
+		}
+	}
+}
+
+// Unverified emails can be shared, the first account to verify one keeps it.
+func TestVerifiedEmailsAreUnique(t *testing.T) {
+	h := handler{DB: openTestDB(t)}
+
+	suffix, err := auth.GenerateRandomToken(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suffix = strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(suffix))
+	email := "shared" + suffix + "@example.com"
+	accounts := []string{"first" + suffix, "second" + suffix}
+	for _, account := range accounts {
+		if result := h.DB.Create(&models.Users{Acct: account, FullName: "Email Test", Email: &email}); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	t.Cleanup(func() { h.DB.Where("acct IN ?", accounts).Delete(&models.Users{}) })
+
+	verify := func(account string) int {
+		token, err := auth.CreateEmailVerificationToken(account, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v1/emailVerifications/"+token, nil),
+			map[string]string{"token": token})
+		w := httptest.NewRecorder()
+		h.VerifyEmailHandler(w, r)
+		return w.Code
+	}
+
+	if code := verify(accounts[0]); code != http.StatusOK {
+		t.Fatalf("first verification responded %d", code)
+	}
+	if code := verify(accounts[1]); code != http.StatusConflict {
+		t.Errorf("second verification responded %d, want %d", code, http.StatusConflict)
+	}
+
+	var verified int64
+	if result := h.DB.Model(&models.Users{}).Where("email = ? AND email_verified", email).Count(&verified); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if verified != 1 {
+		t.Errorf("email is verified by %d accounts", verified)
+	}
+}
+
+// Only actual changes are written, resubmitting the current email keeps it
+// verified.
+func TestUpdateUserChangesOnlyWhatDiffers(t *testing.T) {
+	notifier := &recordingNotifier{}
+	h := handler{DB: openTestDB(t), Validator: validator.New(), Notifier: notifier}
+
+	account := newTestAccount(t, "update")
+	email := account + "@example.com"
+	if result := h.DB.Create(&models.Users{Acct: account, FullName: "Update Test", Email: &email, EmailVerified: true}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("aggregate = ?", account).Delete(&models.OutboxEntries{})
+		h.DB.Where("acct = ?", account).Delete(&models.Users{})
+	})
+
+	update := func(account string, body string) int {
+		r := httptest.NewRequest(http.MethodPatch, "/api/v1/users/"+account, strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"account": account})
+		w := httptest.NewRecorder()
+		h.UpdateUserHandler(w, r)
+		return w.Code
+	}
+	userEvents := func() int64 {
+		var count int64
+		if result := h.DB.Model(&models.OutboxEntries{}).Where("aggregate = ?", account).Count(&count); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		return count
+	}
+	reload := func() models.Users {
+		var user models.Users
+		if result := h.DB.Where("acct = ?", account).First(&user); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		return user
+	}
+
+	if code := update(account, `{"email":"`+strings.ToUpper(email)+`","fullName":"Update Test"}`); code != http.StatusOK {
+		t.Fatalf("responded %d", code)
+	}
+	if user := reload(); !user.EmailVerified || *user.Email != email {
+		t.Errorf("resubmitting the email changed it: %+v", user)
+	}
+	if count := userEvents(); count != 0 {
+		t.Errorf("unchanged user wrote %d events", count)
+	}
+
+	if code := update(account, `{"email":"other`+email+`"}`); code != http.StatusOK {
+		t.Fatalf("responded %d", code)
+	}
+	if user := reload(); user.EmailVerified || *user.Email != "other"+email {
+		t.Errorf("got user %+v after changing the email", user)
+	}
+	if count := userEvents(); count != 1 {
+		t.Errorf("changed email wrote %d events", count)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(notifier.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := notifier.sent(); len(sent) != 1 || sent[0].To != "other"+email {
+		t.Errorf("got verification messages %+v", sent)
+	}
+
+	if code := update(newTestAccount(t, "missing"), `{"fullName":"Nobody"}`); code != http.StatusNotFound {
+		t.Errorf("missing account responded %d", code)
+	}
+}
+
+func TestUserResponseProjection(t *testing.T) {
+	email := "alice@example.com"
+	user := models.Users{Acct: "alice", Password: "hash", FullName: "Alice", Email: &email, EmailVerified: true, Roles: "admin"}
+
+	for name, tt := range map[string]struct {
+		claims       *auth.Claims
+		email        bool
+		roles        bool
+		emailChecked bool
+	}{
+		"owner":                    {&auth.Claims{Account: "alice"}, true, true, true},
+		"owner through a client":   {&auth.Claims{Account: "alice", ClientID: "client"}, true, true, true},
+		"other user":               {&auth.Claims{Account: "bob"}, false, false, false},
+		"client with users:read":   {&auth.Claims{ClientID: "client", Scope: auth.ScopeUsersRead}, false, false, false},
+		"admin":                    {&auth.Claims{Account: "root", Roles: []string{auth.AdminRole}}, false, true, true},
+		"admin through a client":   {&auth.Claims{Account: "root", ClientID: "client", Roles: []string{auth.AdminRole}}, false, false, false},
+		"admin through an API key": {&auth.Claims{Account: "root", APIKeyID: "key", Roles: []string{auth.AdminRole}}, false, false, false},
+		"missing claims":           {nil, false, false, false},
+	} {
+		uResponse := newUserResponse(user, tt.claims, true)
+		if uResponse.Acct != "alice" || uResponse.FullName != "Alice" || !uResponse.Online {
+			t.Errorf("%s: public fields missing: %+v", name, uResponse)
+		}
+		if (uResponse.Email != nil) != tt.email {
+			t.Errorf("%s: got email %v", name, uResponse.Email)
+		}
+		if (len(uResponse.Roles) > 0) != tt.roles || (uResponse.EmailVerified != nil) != tt.emailChecked {
+			t.Errorf("%s: got roles %q, email verified %v", name, uResponse.Roles, uResponse.EmailVerified)
+		}
+	}
+}
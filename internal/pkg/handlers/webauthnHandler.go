@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/webauthn"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebAuthn ceremonies
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+var (
+	errInvalidWebauthnChallenge  = errors.New("unknown or expired WebAuthn challenge")
+	errUnknownWebauthnCredential = errors.New("unknown WebAuthn credential")
+)
+
+// swagger:handlers webauthnCreationOptionsResponse
+// @Description Options for navigator.credentials.create()
+type webauthnCreationOptionsResponse struct {
+	// PublicKeyCredentialCreationOptions with binary values base64url encoded
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+// BeginWebauthnRegistrationHandler godoc
+// @Description Start registering a WebAuthn credential(passkey or security key) for the selected account
+// @Tags webauthn
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Success 200 {object} webauthnCreationOptionsResponse
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/webauthn/registrations/options [post]
+func (h handler) BeginWebauthnRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	var user models.Users
+	if result := h.DB.Where(&models.Users{Acct: account}).First(&user); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	existingIDs, err := h.webauthnCredentialIDs(account)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := h.createWebauthnChallenge(account, ceremonyRegistration)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var wcoResponse webauthnCreationOptionsResponse
+	wcoResponse.PublicKey = webauthn.NewCreationOptions(webauthn.DefaultConfig, challenge, user.Acct, user.FullName, existingIDs)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(wcoResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// swagger:handlers finishWebauthnRegistrationRequest
+// @Description JSON request body for finishing WebAuthn registration
+type finishWebauthnRegistrationRequest struct {
+	// Name of the credential for the user to recognize it(Length: min=1, max=50)
+	Name string `json:"name" validate:"required,min=1,max=50"`
+	// PublicKeyCredential returned by navigator.credentials.create() with binary values base64url encoded
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+// FinishWebauthnRegistrationHandler godoc
+// @Description Finish registering a WebAuthn credential with the authenticator's response
+// @Tags webauthn
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Param Body body finishWebauthnRegistrationRequest true "Credential name and authenticator response"
+// @Success 201 {object} models.WebauthnCredentials
+// @Failure 400 {object} CommonResponse "Invalid request body, challenge or authenticator response"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/webauthn/registrations [post]
+func (h handler) FinishWebauthnRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	var fwrRequest finishWebauthnRegistrationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&fwrRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(fwrRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	challenge, err := webauthn.ChallengeOf(fwrRequest.Credential.Response.ClientDataJSON)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pending, err := h.consumeWebauthnChallenge(challenge, ceremonyRegistration)
+	if err != nil {
+		h.writeWebauthnError(w, err)
+		return
+	}
+	if pending.Acct != account {
+		writeErrorMessage(w, http.StatusBadRequest, errInvalidWebauthnChallenge.Error())
+		return
+	}
+
+	credential, err := webauthn.VerifyRegistration(webauthn.DefaultConfig, challenge, fwrRequest.Credential)
+	if err != nil {
+		log.Println(err.Error())
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	storedCredential := models.WebauthnCredentials{
+		ID:        webauthn.EncodeID(credential.ID),
+		Acct:      account,
+		Name:      fwrRequest.Name,
+		PublicKey: credential.PublicKey,
+		SignCount: int64(credential.SignCount),
+		Aaguid:    credential.AAGUID,
+	}
+	if result := h.DB.Create(&storedCredential); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r, audit.ActionWebauthnRegistered, account, account)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(storedCredential)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListWebauthnCredentialsHandler godoc
+// @Description List WebAuthn credentials registered by the selected account
+// @Tags webauthn
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Success 200 {array} models.WebauthnCredentials
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/users/{account}/webauthn/credentials [get]
+func (h handler) ListWebauthnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	var credentials = []models.WebauthnCredentials{}
+	if result := h.DB.Where("acct = ?", account).Order("created_at").Find(&credentials); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(credentials)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteWebauthnCredentialHandler godoc
+// @Description Remove a WebAuthn credential of the selected account
+// @Tags webauthn
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Security ApiKeyAuth
+// @Param account path string true "User account"
+// @Param id path string true "Credential ID"
+// @Success 200 "Successfully removed the credential"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner has no right to access this resource"
+// @Failure 404 "Credential doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/users/{account}/webauthn/credentials/{id} [delete]
+func (h handler) DeleteWebauthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	result := h.DB.Where("credential_id = ? AND acct = ?", vars["id"], account).Delete(&models.WebauthnCredentials{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	audit.Log(r, audit.ActionWebauthnRemoved, account, account)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Purpose of the IDs of stand-in credentials offered for accounts without any
+const standInCredentialPurpose = "webauthn-stand-in-credential"
+
+// swagger:handlers beginWebauthnLoginRequest
+// @Description JSON request body for starting WebAuthn login
+type beginWebauthnLoginRequest struct {
+	// User account, omit it to use a discoverable credential(passkey)
+	Acct string `json:"account" validate:"omitempty,alphanum"`
+}
+
+// swagger:handlers webauthnRequestOptionsResponse
+// @Description Options for navigator.credentials.get()
+type webauthnRequestOptionsResponse struct {
+	// PublicKeyCredentialRequestOptions with binary values base64url encoded
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+// BeginWebauthnLoginHandler godoc
+// @Description Start login with a WebAuthn credential. The options look the same whether the account exists
+// @Description and has credentials or not.
+// @Tags accessToken
+// @Produce application/json
+// @Param Body body beginWebauthnLoginRequest false "Account to log in"
+// @Success 200 {object} webauthnRequestOptionsResponse
+// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/webauthn/assertions/options [post]
+func (h handler) BeginWebauthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var bwlRequest beginWebauthnLoginRequest
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&bwlRequest)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.Validator.Struct(bwlRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	var allowedIDs [][]byte
+	if len(bwlRequest.Acct) > 0 {
+		allowedIDs, err = h.webauthnCredentialIDs(bwlRequest.Acct)
+		if err != nil {
+			log.Println(err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// Unknown accounts and accounts without credentials get a stand-in
+		// credential, so the options don't reveal which accounts have any.
+		// The assertion fails like one of an unknown credential.
+		if len(allowedIDs) == 0 {
+			allowedIDs = [][]byte{auth.DeriveID(standInCredentialPurpose, bwlRequest.Acct)}
+		}
+	}
+
+	challenge, err := h.createWebauthnChallenge(bwlRequest.Acct, ceremonyLogin)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var wroResponse webauthnRequestOptionsResponse
+	wroResponse.PublicKey = webauthn.NewRequestOptions(webauthn.DefaultConfig, challenge, allowedIDs)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(wroResponse)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// FinishWebauthnLoginHandler godoc
+// @Description Create user access token with a WebAuthn assertion, or a browser session with ?session=cookie
+// @Tags accessToken
+// @Produce application/json
+// @Param Body body webauthn.AssertionResponse true "PublicKeyCredential returned by navigator.credentials.get() with binary values base64url encoded"
+// @Param session query string false "cookie for starting a browser session"
+// @Success 200 {object} createAccessTokenResponse
+// @Failure 400 {object} CommonResponse "Invalid request body, challenge, credential or assertion"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/webauthn/assertions [post]
+func (h handler) FinishWebauthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var assertion webauthn.AssertionResponse
+
+	err := json.NewDecoder(r.Body).Decode(&assertion)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := webauthn.ChallengeOf(assertion.Response.ClientDataJSON)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pending, err := h.consumeWebauthnChallenge(challenge, ceremonyLogin)
+	if err != nil {
+		h.writeWebauthnError(w, err)
+		return
+	}
+
+	var credential models.WebauthnCredentials
+	if result := h.DB.Where("credential_id = ?", assertion.ID).First(&credential); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			h.writeWebauthnError(w, errUnknownWebauthnCredential)
+		} else {
+			h.writeWebauthnError(w, result.Error)
+		}
+		return
+	}
+	// The credential must belong to the account the login was started for, and
+	// to the user the authenticator returned for discoverable credentials.
+	if len(pending.Acct) > 0 && pending.Acct != credential.Acct {
+		h.writeWebauthnError(w, errUnknownWebauthnCredential)
+		return
+	}
+	if len(assertion.Response.UserHandle) > 0 {
+		userHandle, err := webauthn.DecodeID(assertion.Response.UserHandle)
+		if err != nil || string(userHandle) != credential.Acct {
+			h.writeWebauthnError(w, errUnknownWebauthnCredential)
+			return
+		}
+	}
+
+	signCount, err := webauthn.VerifyAssertion(webauthn.DefaultConfig, challenge, assertion, webauthn.Credential{
+		PublicKey: credential.PublicKey,
+		SignCount: uint32(credential.SignCount),
+	})
+	if err != nil {
+		log.Println(err.Error())
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if result := h.DB.Model(&credential).Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"last_used_at": time.Now(),
+	}); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.writeAccessToken(w, r, credential.Acct, isCookieSessionRequested(r))
+}
+
+// IDs of the WebAuthn credentials registered by the account.
+func (h handler) webauthnCredentialIDs(account string) ([][]byte, error) {
+	var encodedIDs []string
+	if result := h.DB.Model(&models.WebauthnCredentials{}).
+		Where("acct = ?", account).Pluck("credential_id", &encodedIDs); result.Error != nil {
+		return nil, result.Error
+	}
+	ids := make([][]byte, 0, len(encodedIDs))
+	for _, encodedID := range encodedIDs {
+		id, err := webauthn.DecodeID(encodedID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Creates and stores a challenge for a new ceremony. Expired challenges are
+// cleaned up on the way.
+func (h handler) createWebauthnChallenge(account string, ceremony string) ([]byte, error) {
+	if result := h.DB.Where("expires_at < ?", time.Now()).Delete(&models.WebauthnChallenges{}); result.Error != nil {
+		log.Println(result.Error)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	if result := h.DB.Create(&models.WebauthnChallenges{
+		ChallengeHash: auth.HashToken(webauthn.EncodeID(challenge)),
+		Acct:          account,
+		Ceremony:      ceremony,
+		ExpiresAt:     time.Now().Add(webauthn.DefaultConfig.Timeout)}); result.Error != nil {
+		return nil, result.Error
+	}
+	return challenge, nil
+}
+
+// Removes a pending challenge so it can be used only once, and returns it.
+func (h handler) consumeWebauthnChallenge(challenge []byte, ceremony string) (*models.WebauthnChallenges, error) {
+	var pending []models.WebauthnChallenges
+	result := h.DB.Clauses(clause.Returning{}).
+		Where("challenge_hash = ? AND ceremony = ? AND expires_at > ?",
+			auth.HashToken(webauthn.EncodeID(challenge)), ceremony, time.Now()).
+		Delete(&pending)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(pending) != 1 {
+		return nil, errInvalidWebauthnChallenge
+	}
+	return &pending[0], nil
+}
+
+// Responds with the status matching a WebAuthn ceremony error.
+func (h handler) writeWebauthnError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidWebauthnChallenge), errors.Is(err, errUnknownWebauthnCredential):
+		writeErrorMessage(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"uiassignment/internal/pkg/models"
+	"uiassignment/internal/pkg/webauthn"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Login options don't tell whether an account exists or has credentials.
+func TestBeginWebauthnLoginIsUniform(t *testing.T) {
+	h := handler{DB: openTestDB(t), Validator: validator.New()}
+
+	withCredential := newTestAccount(t, "passkey")
+	withoutCredential := newTestAccount(t, "nopasskey")
+	unknown := newTestAccount(t, "unknown")
+	credentialID := webauthn.EncodeID([]byte("credential-of-" + withCredential))
+	for _, account := range []string{withCredential, withoutCredential} {
+		if result := h.DB.Create(&models.Users{Acct: account, FullName: "WebAuthn Test"}); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if result := h.DB.Create(&models.WebauthnCredentials{ID: credentialID, Acct: withCredential,
+		PublicKey: []byte{0}, Name: "test"}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	t.Cleanup(func() {
+		h.DB.Where("acct IN ?", []string{withCredential, withoutCredential}).Delete(&models.WebauthnCredentials{})
+		h.DB.Where("acct IN ?", []string{withCredential, withoutCredential, unknown}).Delete(&models.WebauthnChallenges{})
+		h.DB.Where("acct IN ?", []string{withCredential, withoutCredential}).Delete(&models.Users{})
+	})
+
+	allowedIDs := func(account string) []string {
+		w := httptest.NewRecorder()
+		h.BeginWebauthnLoginHandler(w, httptest.NewRequest(http.MethodPost, "/api/v1/webauthn/assertions/options",
+			strings.NewReader(`{"account":"`+account+`"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s responded %d", account, w.Code)
+		}
+		var response struct {
+			PublicKey struct {
+				AllowCredentials []struct {
+					Type string `json:"type"`
+					ID   string `json:"id"`
+				} `json:"allowCredentials"`
+			} `json:"publicKey"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, descriptor := range response.PublicKey.AllowCredentials {
+			ids = append(ids, descriptor.ID)
+		}
+		return ids
+	}
+
+	if ids := allowedIDs(withCredential); len(ids) != 1 || ids[0] != credentialID {
+		t.Errorf("got credentials %v, want %s", ids, credentialID)
+	}
+	standIns := map[string]string{}
+	for _, account := range []string{withoutCredential, unknown} {
+		ids := allowedIDs(account)
+		if len(ids) != 1 || len(ids[0]) != len(webauthn.EncodeID(make([]byte, 32))) {
+			t.Fatalf("%s got credentials %v", account, ids)
+		}
+		// The same on every request, like real credentials
+		if again := allowedIDs(account); again[0] != ids[0] {
+			t.Errorf("%s got stand-in %s, then %s", account, ids[0], again[0])
+		}
+		standIns[account] = ids[0]
+	}
+	if standIns[withoutCredential] == standIns[unknown] {
+		t.Error("accounts got the same stand-in credential")
+	}
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/audit"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/db"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// swagger:handlers createWebhookRequest
+// @Description JSON request body for subscribing a webhook to events
+type createWebhookRequest struct {
+	// URL events are posted to, http or https
+	URL string `json:"url" validate:"required,url,max=2000"`
+	// Event types to deliver(user.created, user.updated, user.deleted, user.login_failed) or * for all
+	Events []string `json:"events" validate:"required,min=1,dive,oneof=* user.created user.updated user.deleted user.login_failed"`
+	// Key for signing deliveries(Length: min=16, max=64), generated if omitted
+	Secret string `json:"secret" validate:"omitempty,min=16,max=64"`
+}
+
+// swagger:handlers webhookResponse
+// @Description Webhook subscription
+type webhookResponse struct {
+	models.WebhookSubscriptions
+	// Event types delivered, * for all
+	Events []string `json:"events"`
+	// Key for signing deliveries, shown only once on creation
+	Secret string `json:"secret,omitempty"`
+}
+
+// CreateWebhookHandler godoc
+// @Description Subscribe a webhook to events. Every event is posted as JSON with the headers X-Webhook-Event-Id,
+// @Description X-Webhook-Event, X-Webhook-Timestamp and X-Webhook-Signature: sha256= followed by the hex encoded
+// @Description HMAC-SHA256 of "{timestamp}.{body}" keyed with the secret. Failed deliveries are retried with backoff.
+// @Tags webhook
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param Body body createWebhookRequest true "Subscription details"
+// @Success 201 {object} webhookResponse
+// @Failure 400 {object} CommonResponse "Invalid request body"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/admin/webhooks [post]
+func (h handler) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var cwRequest createWebhookRequest
+
+	err := json.NewDecoder(r.Body).Decode(&cwRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(cwRequest)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+	if webhookURL, err := url.Parse(cwRequest.URL); err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") {
+		writeErrorMessage(w, http.StatusBadRequest, "URL must be http or https")
+		return
+	}
+
+	id, err := auth.GenerateRandomToken(12)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	secret := cwRequest.Secret
+	if len(secret) == 0 {
+		if secret, err = auth.GenerateRandomToken(32); err != nil {
+			log.Println(err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	subscription := models.WebhookSubscriptions{
+		ID:        id,
+		URL:       cwRequest.URL,
+		Events:    strings.Join(cwRequest.Events, " "),
+		Secret:    secret,
+		CreatedBy: &tokenOwner,
+		CreatedAt: time.Now(),
+	}
+	if result := h.DB.Create(&subscription); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r, audit.ActionWebhookCreated, tokenOwner, id)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(webhookResponse{
+		WebhookSubscriptions: subscription,
+		Events:               subscription.EventList(),
+		Secret:               secret,
+	})
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListWebhooksHandler godoc
+// @Description List webhook subscriptions
+// @Tags webhook
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Success 200 {array} webhookResponse
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/admin/webhooks [get]
+func (h handler) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var subscriptions []models.WebhookSubscriptions
+	if result := h.DB.Order("created_at").Find(&subscriptions); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var wResponses = []webhookResponse{}
+	for _, subscription := range subscriptions {
+		wResponses = append(wResponses, webhookResponse{
+			WebhookSubscriptions: subscription,
+			Events:               subscription.EventList(),
+		})
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(wResponses)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteWebhookHandler godoc
+// @Description Delete a webhook subscription along with its deliveries
+// @Tags webhook
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param id path string true "Subscription ID"
+// @Success 200 "Successfully deleted the subscription"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 404 "Subscription doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/admin/webhooks/{id} [delete]
+func (h handler) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	result := h.DB.Where(&models.WebhookSubscriptions{ID: id}).Delete(&models.WebhookSubscriptions{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	tokenOwner := r.Context().Value("tokenOwner").(string)
+	audit.Log(r, audit.ActionWebhookDeleted, tokenOwner, id)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListWebhookDeliveriesHandler godoc
+// @Description Get the delivery log of a webhook subscription with paging, latest first
+// @Tags webhook
+// @Produce application/json
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param id path string true "Subscription ID"
+// @Param status query string false "Filter by status(pending, succeeded, dead)"
+// @Param limit query int false "Max items per page(min=5, max=100, default=5)"
+// @Param page query int false "Requested page"
+// @Success 200 {object} db.Pagination{rows=[]models.WebhookDeliveries}
+// @Failure 400 {object} CommonResponse "Invalid query parameter"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 500 "Internal error caused by DB connection issue or JSON parsing failure"
+// @Router /v1/admin/webhooks/{id}/deliveries [get]
+func (h handler) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	type listDeliveriesQuery struct {
+		Status string `schema:"status" validate:"omitempty,oneof=pending succeeded dead"`
+		Limit  int    `schema:"limit" validate:"omitempty,gte=5,lte=100"`
+		Page   int    `schema:"page" validate:"omitempty,gt=0"`
+	}
+	var ldQuery listDeliveriesQuery
+
+	err := queryDecoder.Decode(&ldQuery, r.URL.Query())
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Validator.Struct(ldQuery)
+	if err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, ValidatorErrorMessageBuilder(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	deliveriesQuery := h.DB.Where(&models.WebhookDeliveries{SubscriptionID: vars["id"], Status: ldQuery.Status})
+
+	pagination := db.Pagination{Limit: ldQuery.Limit, Page: ldQuery.Page}
+	var deliveries = []models.WebhookDeliveries{}
+	if result := deliveriesQuery.Session(&gorm.Session{}).
+		Scopes(db.Paginate(models.WebhookDeliveries{}, &pagination, deliveriesQuery.Session(&gorm.Session{}))).
+		Order("created_at desc").Find(&deliveries); result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	pagination.Rows = deliveries
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(pagination)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// RetryWebhookDeliveryHandler godoc
+// @Description Attempt a delivery again right away, e.g. a dead one after the receiver was fixed.
+// @Description It gets the full number of attempts again.
+// @Tags webhook
+// @Security BearerAuth
+// @Security AccessTokenAuth
+// @Param id path string true "Subscription ID"
+// @Param deliveryId path string true "Delivery ID"
+// @Success 200 "Delivery scheduled"
+// @Failure 401 "Missing valid acces token for accessing this resource"
+// @Failure 403 "Current token owner isn't an admin"
+// @Failure 404 "Delivery doesn't exist"
+// @Failure 500 "Internal error caused by DB connection issue"
+// @Router /v1/admin/webhooks/{id}/deliveries/{deliveryId}/retry [post]
+func (h handler) RetryWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	now := time.Now()
+	result := h.DB.Model(&models.WebhookDeliveries{}).
+		Where(&models.WebhookDeliveries{ID: vars["deliveryId"], SubscriptionID: vars["id"]}).
+		Updates(map[string]interface{}{
+			"status":          models.DeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		log.Println(result.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -2,49 +2,320 @@ package middlewares
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/models"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
-func AccessTokenCheckMW() mux.MiddlewareFunc {
+// Realm advertised in WWW-Authenticate challenges
+const authRealm = "uiassignment"
+
+func AccessTokenCheckMW(db *gorm.DB) mux.MiddlewareFunc {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			accesstoken := r.Header.Get("X-Accesstoken")
-			isTokenValid, tokenOwner := auth.IsAccessTokenValid(accesstoken)
+			accessToken := AccessTokenExtractor(r)
+			claims, isTokenValid := isAccessTokenValid(db, accessToken)
 			if !isTokenValid {
-				w.WriteHeader(http.StatusUnauthorized)
+				writeUnauthorized(w, accessToken)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), "tokenOwner", tokenOwner)
-			r = r.WithContext(ctx)
-			h.ServeHTTP(w, r)
+			h.ServeHTTP(w, withTokenContext(r, claims, accessToken))
 		})
 	}
 }
 
-func OwnerAccessCheckMW() mux.MiddlewareFunc {
+func OwnerAccessCheckMW(db *gorm.DB) mux.MiddlewareFunc {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			accesstoken := r.Header.Get("X-Accesstoken")
-			isTokenValid, tokenOwner := auth.IsAccessTokenValid(accesstoken)
+			accessToken := AccessTokenExtractor(r)
+			claims, isTokenValid := isAccessTokenValid(db, accessToken)
 			if !isTokenValid {
-				w.WriteHeader(http.StatusUnauthorized)
+				writeUnauthorized(w, accessToken)
 				return
 			}
 
 			vars := mux.Vars(r)
 			account := vars["account"]
-			if account != tokenOwner {
+			if account != claims.Account {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, withTokenContext(r, claims, accessToken))
+		})
+	}
+}
+
+// Passes a valid token, its owner and claims, and whether it came from a
+// cookie, to the following handlers.
+func withTokenContext(r *http.Request, claims *auth.Claims, accessToken string) *http.Request {
+	ctx := context.WithValue(r.Context(), "tokenOwner", claims.Account)
+	ctx = context.WithValue(ctx, "tokenClaims", claims)
+	ctx = context.WithValue(ctx, "cookieToken", isCookieToken(r, accessToken))
+	ctx = context.WithValue(ctx, "accessToken", accessToken)
+	return r.WithContext(ctx)
+}
+
+// Requires the token checked by a preceding middleware to grant the scope.
+func RequireScopeMW(scope string) mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+			if !ok || !claims.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`, authRealm, scope))
 				w.WriteHeader(http.StatusForbidden)
 				return
 			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
 
-			ctx := context.WithValue(r.Context(), "tokenOwner", tokenOwner)
-			r = r.WithContext(ctx)
+// Requires the token checked by a preceding middleware to be a first-party
+// token. Used for managing credentials, which third-party clients can't do
+// whatever scope they're granted.
+func FirstPartyOnlyMW() mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+			if !ok || !claims.IsFirstParty() {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
 			h.ServeHTTP(w, r)
 		})
 	}
 }
+
+// Requires the account of the token checked by a preceding middleware to have the role.
+func RequireRoleMW(role string) mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+			if !ok || !claims.HasRole(role) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Requires requests authenticated by a cookie, the session cookie or the
+// access token cookie, to carry the CSRF token of the token's session in
+// X-CSRF-Token, unless they're safe methods. Tokens without a session have no
+// CSRF token, so they can't change state from a cookie. Other credentials,
+// including login tokens of the session, aren't sent by browsers on their own,
+// so they need no CSRF protection.
+func CSRFProtectionMW() mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				h.ServeHTTP(w, r)
+				return
+			}
+			if cookieToken, _ := r.Context().Value("cookieToken").(bool); cookieToken {
+				claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+				if !ok {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if len(claims.SessionID) == 0 || !auth.IsCSRFTokenValid(claims.SessionID, r.Header.Get("X-CSRF-Token")) {
+					log.Println("Received a cookie request with invalid CSRF token for session: ", claims.SessionID)
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Responds 401 with a bearer challenge(RFC 6750 section 3). The error is
+// only given if a token was presented.
+func writeUnauthorized(w http.ResponseWriter, accessToken string) {
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, authRealm)
+	if len(accessToken) > 0 {
+		challenge += `, error="invalid_token", error_description="The access token is invalid, expired or revoked"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// Returns a function validating access tokens, API keys and session tokens the
+// same way the access checking middlewares do, e.g. for re-checking long-lived
+// connections. It returns auth.ErrInvalidAccessToken for tokens which are
+// invalid, expired or revoked, and other errors if the token couldn't be
+// checked, e.g. while the DB is unavailable.
+func AccessTokenValidator(db *gorm.DB) func(accessToken string) (*auth.Claims, error) {
+	return func(accessToken string) (*auth.Claims, error) {
+		return checkAccessToken(db, accessToken)
+	}
+}
+
+// Reports whether the access token is valid for the access checking
+// middlewares. Tokens which couldn't be checked are rejected as well.
+func isAccessTokenValid(db *gorm.DB, accessToken string) (claims *auth.Claims, isTokenValid bool) {
+	claims, err := checkAccessToken(db, accessToken)
+	if err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// Validates access token and checks that its owner, client and session still
+// exist and haven't revoked it.
+func checkAccessToken(db *gorm.DB, accessToken string) (*auth.Claims, error) {
+	if auth.IsAPIKey(accessToken) {
+		return checkAPIKey(db, accessToken)
+	}
+	if auth.IsSessionToken(accessToken) {
+		return checkSession(db, accessToken)
+	}
+
+	claims, err := auth.ParseAccessToken(accessToken)
+	if err != nil {
+		return nil, auth.ErrInvalidAccessToken
+	}
+
+	if len(claims.Account) > 0 {
+		if err := checkTokenOwner(db, claims); err != nil {
+			return nil, err
+		}
+	} else if claims.IsFirstParty() {
+		return nil, auth.ErrInvalidAccessToken
+	}
+
+	// Tokens issued before sessions were recorded have no session
+	if len(claims.SessionID) > 0 {
+		var session models.Sessions
+		if result := db.Where(&models.Sessions{ID: claims.SessionID, Acct: claims.Account}).
+			First(&session); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				log.Println("Received a token of revoked session: ", claims.SessionID)
+				return nil, auth.ErrInvalidAccessToken
+			}
+			log.Println(result.Error)
+			return nil, result.Error
+		}
+		touchSession(db, &session, false)
+	}
+
+	if !claims.IsFirstParty() {
+		var clients int64
+		if result := db.Model(&models.OauthClients{}).
+			Where("client_id = ?", claims.ClientID).Count(&clients); result.Error != nil {
+			log.Println(result.Error)
+			return nil, result.Error
+		}
+		if clients == 0 {
+			log.Println("Received a token of unknown client: ", claims.ClientID)
+			return nil, auth.ErrInvalidAccessToken
+		}
+	}
+
+	return claims, nil
+}
+
+// Checks that the account of the claims exists and hasn't revoked its tokens
+// since they were issued, and loads the account's roles into the claims.
+// Tokens of sessions are revoked by deleting the session instead.
+func checkTokenOwner(db *gorm.DB, claims *auth.Claims) error {
+	var user models.Users
+	if result := db.Select("acct", "roles", "tokens_valid_after").
+		Where(&models.Users{Acct: claims.Account}).First(&user); result.Error != nil {
+		log.Println(result.Error)
+		return lookupError(result.Error)
+	}
+	if len(claims.SessionID) == 0 && auth.IsIssuedBefore(claims, user.TokensValidAfter) {
+		log.Println("Received a revoked token of account: ", claims.Account)
+		return auth.ErrInvalidAccessToken
+	}
+	claims.Roles = user.RoleList()
+	return nil
+}
+
+// Validates an API key and records its use. Keys are revoked by deleting them,
+// and with their owner.
+func checkAPIKey(db *gorm.DB, apiKey string) (*auth.Claims, error) {
+	var key models.ApiKeys
+	if result := db.Where("key_hash = ?", auth.HashToken(apiKey)).First(&key); result.Error != nil {
+		log.Println(result.Error)
+		return nil, lookupError(result.Error)
+	}
+	now := time.Now()
+	if key.IsExpired(now) {
+		log.Println("Received an expired API key: ", key.ID)
+		return nil, auth.ErrInvalidAccessToken
+	}
+
+	// Last use is tracked at minute precision to spare a write per request
+	if result := db.Model(&models.ApiKeys{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-time.Minute)).
+		Update("last_used_at", now); result.Error != nil {
+		log.Println(result.Error)
+	}
+
+	return auth.NewAPIKeyClaims(key.Acct, key.ID, key.ScopeList()), nil
+}
+
+// Validates a session token and slides its expiry. Sessions are revoked by
+// deleting them, and with their owner.
+func checkSession(db *gorm.DB, sessionToken string) (*auth.Claims, error) {
+	var session models.Sessions
+	if result := db.Where("token_hash = ?", auth.HashToken(sessionToken)).First(&session); result.Error != nil {
+		log.Println(result.Error)
+		return nil, lookupError(result.Error)
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		log.Println("Received an expired session: ", session.ID)
+		return nil, auth.ErrInvalidAccessToken
+	}
+
+	claims := auth.NewSessionClaims(session.Acct, session.ID, session.CreatedAt)
+	if err := checkTokenOwner(db, claims); err != nil {
+		return nil, err
+	}
+
+	touchSession(db, &session, true)
+	return claims, nil
+}
+
+// Tells records which don't exist, i.e. revoked credentials, from failed
+// lookups.
+func lookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return auth.ErrInvalidAccessToken
+	}
+	return err
+}
+
+// Records the use of a session, sliding its expiry if asked to. Updates happen
+// at minute precision to spare a write per request.
+func touchSession(db *gorm.DB, session *models.Sessions, slideExpiry bool) {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) <= time.Minute {
+		return
+	}
+
+	updates := models.Sessions{LastSeenAt: now}
+	if slideExpiry {
+		updates.ExpiresAt = now.Add(auth.SessionIdleTTL)
+		if updates.ExpiresAt.After(session.MaxExpiresAt) {
+			updates.ExpiresAt = session.MaxExpiresAt
+		}
+	}
+	if result := db.Model(session).Updates(updates); result.Error != nil {
+		log.Println(result.Error)
+	}
+}
@@ -0,0 +1,175 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"uiassignment/internal/pkg/auth"
+)
+
+func TestIsCookieToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/users/alice", nil)
+	r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: "uias_cookie"})
+	if !isCookieToken(r, AccessTokenExtractor(r)) {
+		t.Error("session cookie wasn't recognized")
+	}
+
+	r.Header.Set("Authorization", "Bearer jwt")
+	if isCookieToken(r, AccessTokenExtractor(r)) {
+		t.Error("bearer token sent along with the cookie was taken for the cookie")
+	}
+}
+
+func setAccessTokenCookie(t *testing.T, name string) {
+	t.Helper()
+	previous := accessTokenCookie
+	accessTokenCookie = name
+	t.Cleanup(func() { accessTokenCookie = previous })
+}
+
+// A state-changing request authenticated by the access token cookie is a
+// cross-site request as much as one with the session cookie.
+func TestAccessTokenCookieRequiresCSRFToken(t *testing.T) {
+	setAccessTokenCookie(t, "uia_access_token")
+	claims := &auth.Claims{Account: "alice", SessionID: "session"}
+
+	handler := CSRFProtectionMW()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, withTokenContext(r, claims, AccessTokenExtractor(r)))
+		return w.Code
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/api/v1/users/alice", nil)
+	r.AddCookie(&http.Cookie{Name: "uia_access_token", Value: "jwt"})
+	if code := serve(r); code != http.StatusForbidden {
+		t.Errorf("access token cookie without CSRF token got status %d", code)
+	}
+
+	r.Header.Set("X-CSRF-Token", auth.CSRFTokenFor("session"))
+	if code := serve(r); code != http.StatusOK {
+		t.Errorf("access token cookie with CSRF token got status %d", code)
+	}
+
+	// Tokens without a session have no CSRF token to send
+	claims = &auth.Claims{Account: "alice"}
+	r.Header.Set("X-CSRF-Token", auth.CSRFTokenFor(""))
+	if code := serve(r); code != http.StatusForbidden {
+		t.Errorf("access token cookie without a session got status %d", code)
+	}
+
+	r = httptest.NewRequest(http.MethodPut, "/api/v1/users/alice", nil)
+	r.AddCookie(&http.Cookie{Name: "uia_access_token", Value: "jwt"})
+	r.Header.Set("X-Accesstoken", "jwt")
+	if code := serve(r); code != http.StatusOK {
+		t.Errorf("header token sent along with the cookie got status %d", code)
+	}
+}
+
+func TestCSRFProtectionMWRequiresClaims(t *testing.T) {
+	handler := CSRFProtectionMW()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	r := httptest.NewRequest(http.MethodPut, "/api/v1/users/alice", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "cookieToken", true))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d", w.Code)
+	}
+}
+
+func TestCSRFProtectionMW(t *testing.T) {
+	claims := &auth.Claims{Account: "alice", SessionID: "session"}
+	tests := []struct {
+		name        string
+		method      string
+		cookieToken bool
+		csrfToken   string
+		want        int
+	}{
+		{"bearer token of a session", http.MethodPut, false, "", http.StatusOK},
+		{"cookie without CSRF token", http.MethodPut, true, "", http.StatusForbidden},
+		{"cookie with wrong CSRF token", http.MethodPut, true, auth.CSRFTokenFor("other"), http.StatusForbidden},
+		{"cookie with CSRF token", http.MethodPut, true, auth.CSRFTokenFor("session"), http.StatusOK},
+		{"cookie on safe method", http.MethodGet, true, "", http.StatusOK},
+	}
+
+	handler := CSRFProtectionMW()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/users/alice", nil)
+			ctx := context.WithValue(r.Context(), "tokenClaims", claims)
+			ctx = context.WithValue(ctx, "cookieToken", tt.cookieToken)
+			r = r.WithContext(ctx)
+			if len(tt.csrfToken) > 0 {
+				r.Header.Set("X-CSRF-Token", tt.csrfToken)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// The query parameter only authenticates the event stream, not other endpoints
+// requested with the same Accept header.
+func TestEventStreamQueryToken(t *testing.T) {
+	for target, want := range map[string]string{
+		"/api/v1/events?access_token=jwt":                "jwt",
+		"/api/v1/events?topic=presence&access_token=jwt": "jwt",
+		"/api/v1/users?access_token=jwt":                 "",
+		"/api/v1/users/alice/apiKeys?access_token=jwt":   "",
+		"/api/v1/events/../users?access_token=jwt":       "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept", "text/event-stream")
+		if got := AccessTokenExtractor(r); got != want {
+			t.Errorf("%s got token %q, want %q", target, got, want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/events?access_token=jwt", nil)
+	if got := AccessTokenExtractor(r); len(got) > 0 {
+		t.Errorf("request not accepting an event stream got token %q", got)
+	}
+}
+
+// Websocket connections and event streams act for the user, so they're
+// limited to first-party tokens.
+func TestFirstPartyOnlyMW(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		want   int
+	}{
+		{"first-party token", &auth.Claims{Account: "alice", SessionID: "session"}, http.StatusOK},
+		{"client credentials token", &auth.Claims{ClientID: "client", Scope: auth.ScopeUsersRead}, http.StatusForbidden},
+		{"scoped third-party token", &auth.Claims{Account: "alice", ClientID: "client", Scope: auth.ScopeUsersRead}, http.StatusForbidden},
+		{"API key", &auth.Claims{Account: "alice", APIKeyID: "key"}, http.StatusForbidden},
+	}
+
+	handler := FirstPartyOnlyMW()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/events", nil)
+			r = r.WithContext(context.WithValue(r.Context(), "tokenClaims", tt.claims))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// TokenExtractor reads an access token or API key from a request. It returns
+// an empty string if the request carries none.
+type TokenExtractor func(r *http.Request) string
+
+// Name of the cookie carrying the access token of browser sessions, disabled if empty
+var accessTokenCookie = config.Get("ACCESS_TOKEN_COOKIE", "")
+
+// Extractor used by the access checking middlewares. Tokens are looked up in
+// order: Authorization bearer token, X-Accesstoken, X-Api-Key, the session
+// cookie, the access token cookie if enabled, and the access_token query
+// parameter of websocket upgrades and event streams, since browsers can't set
+// headers on those.
+var AccessTokenExtractor = FirstTokenOf(
+	headerTokenExtractor,
+	sessionCookieExtractor,
+	accessTokenCookieExtractor,
+	WebsocketQueryTokenExtractor("access_token"),
+	EventStreamQueryTokenExtractor(eventStreamPath, "access_token"),
+)
+
+// Path of the event stream, the only one accepting tokens from the query
+// besides websocket upgrades
+const eventStreamPath = "/api/v1/events"
+
+// Headers browsers never send on their own
+var headerTokenExtractor = FirstTokenOf(
+	BearerTokenExtractor(),
+	HeaderTokenExtractor("X-Accesstoken"),
+	HeaderTokenExtractor("X-Api-Key"),
+)
+
+var sessionCookieExtractor = CookieTokenExtractor(auth.SessionCookieName)
+
+// Reads the cookie named by ACCESS_TOKEN_COOKIE when the request arrives
+func accessTokenCookieExtractor(r *http.Request) string {
+	return CookieTokenExtractor(accessTokenCookie)(r)
+}
+
+// Reports whether the token AccessTokenExtractor found came from a cookie, the
+// session cookie or the access token cookie, i.e. one browsers send along with
+// cross-site requests as well.
+func isCookieToken(r *http.Request, accessToken string) bool {
+	if len(accessToken) == 0 || len(headerTokenExtractor(r)) > 0 {
+		return false
+	}
+	return sessionCookieExtractor(r) == accessToken || accessTokenCookieExtractor(r) == accessToken
+}
+
+// Reads the token of an "Authorization: Bearer" header(RFC 6750 section 2.1).
+func BearerTokenExtractor() TokenExtractor {
+	return func(r *http.Request) string {
+		authorization := r.Header.Get("Authorization")
+		if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+			return strings.TrimSpace(authorization[7:])
+		}
+		return ""
+	}
+}
+
+// Reads the token from a custom header.
+func HeaderTokenExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// Reads the token from a cookie. An empty name disables the extractor.
+func CookieTokenExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		if len(name) == 0 {
+			return ""
+		}
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// Reads the token from a query parameter of websocket upgrade requests. Other
+// requests are ignored so tokens don't end up in logs and browser history.
+func WebsocketQueryTokenExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		if !websocket.IsWebSocketUpgrade(r) {
+			return ""
+		}
+		return r.URL.Query().Get(name)
+	}
+}
+
+// Reads the token from a query parameter of requests to the event stream at
+// path accepting only an event stream, e.g. from the browser's EventSource.
+// Other paths are ignored, so the parameter can't authenticate other endpoints
+// by sending the same Accept header.
+func EventStreamQueryTokenExtractor(path string, name string) TokenExtractor {
+	return func(r *http.Request) string {
+		if r.URL.Path != path || r.Header.Get("Accept") != "text/event-stream" {
+			return ""
+		}
+		return r.URL.Query().Get(name)
+	}
+}
+
+// Combines extractors, the first token found wins.
+func FirstTokenOf(extractors ...TokenExtractor) TokenExtractor {
+	return func(r *http.Request) string {
+		for _, extract := range extractors {
+			if token := extract(r); len(token) > 0 {
+				return token
+			}
+		}
+		return ""
+	}
+}
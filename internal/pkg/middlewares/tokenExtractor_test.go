@@ -0,0 +1,141 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"uiassignment/internal/pkg/auth"
+)
+
+// Each source is only used if none before it carries a token.
+func TestAccessTokenExtractorPrecedence(t *testing.T) {
+	setAccessTokenCookie(t, "uia_access_token")
+	sources := []struct {
+		token string
+		set   func(r *http.Request)
+	}{
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer bearer") }},
+		{"header", func(r *http.Request) { r.Header.Set("X-Accesstoken", "header") }},
+		{"uia_key", func(r *http.Request) { r.Header.Set("X-Api-Key", "uia_key") }},
+		{"session", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: "session"})
+		}},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "uia_access_token", Value: "cookie"}) }},
+		{"query", func(r *http.Request) {
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+		}},
+	}
+
+	for first := range sources {
+		r := httptest.NewRequest(http.MethodGet, "/ws?access_token=query", nil)
+		for _, source := range sources[first:] {
+			source.set(r)
+		}
+		if got := AccessTokenExtractor(r); got != sources[first].token {
+			t.Errorf("got token %q, want %q", got, sources[first].token)
+		}
+	}
+}
+
+func TestBearerTokenExtractor(t *testing.T) {
+	for authorization, want := range map[string]string{
+		"Bearer jwt":     "jwt",
+		"bearer jwt":     "jwt",
+		"BEARER  jwt ":   "jwt",
+		"Bearer ":        "",
+		"Basic dXNlcjpw": "",
+		"jwt":            "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		r.Header.Set("Authorization", authorization)
+		if got := BearerTokenExtractor()(r); got != want {
+			t.Errorf("%q got token %q, want %q", authorization, got, want)
+		}
+	}
+}
+
+// The query parameter isn't read from plain requests, so tokens don't end up
+// in logs and browser history.
+func TestWebsocketQueryTokenExtractor(t *testing.T) {
+	extract := WebsocketQueryTokenExtractor("access_token")
+	r := httptest.NewRequest(http.MethodGet, "/ws?access_token=jwt", nil)
+	if got := extract(r); len(got) > 0 {
+		t.Errorf("plain request got token %q", got)
+	}
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	if got := extract(r); got != "jwt" {
+		t.Errorf("websocket upgrade got token %q", got)
+	}
+}
+
+// The access token cookie is only read if ACCESS_TOKEN_COOKIE names it.
+func TestAccessTokenCookieDisabledByDefault(t *testing.T) {
+	setAccessTokenCookie(t, "")
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	r.AddCookie(&http.Cookie{Name: "uia_access_token", Value: "jwt"})
+	if got := AccessTokenExtractor(r); len(got) > 0 {
+		t.Errorf("got token %q", got)
+	}
+}
+
+// Requests without valid token get a bearer challenge(RFC 6750 section 3),
+// with an error only if they sent a token.
+func TestAccessTokenCheckMWChallenge(t *testing.T) {
+	handler := AccessTokenCheckMW(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for authorization, want := range map[string]string{
+		"":                   `Bearer realm="uiassignment"`,
+		"Bearer not.a.jwt":   `Bearer realm="uiassignment", error="invalid_token", error_description="The access token is invalid, expired or revoked"`,
+		"Basic dXNlcjpwYXNz": `Bearer realm="uiassignment"`,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		if len(authorization) > 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q got status %d", authorization, w.Code)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != want {
+			t.Errorf("%q got challenge %s, want %s", authorization, got, want)
+		}
+	}
+}
+
+func TestRequireScopeMWChallenge(t *testing.T) {
+	handler := RequireScopeMW(auth.ScopeUsersWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for name, tt := range map[string]struct {
+		claims *auth.Claims
+		want   int
+	}{
+		"first-party token":  {&auth.Claims{Account: "alice"}, http.StatusOK},
+		"granted scope":      {&auth.Claims{Account: "alice", ClientID: "client", Scope: auth.ScopeUsersWrite}, http.StatusOK},
+		"insufficient scope": {&auth.Claims{Account: "alice", ClientID: "client", Scope: auth.ScopeUsersRead}, http.StatusForbidden},
+		"API key read only":  {auth.NewAPIKeyClaims("alice", "key", []string{auth.ScopeUsersRead}), http.StatusForbidden},
+		"API key with scope": {auth.NewAPIKeyClaims("alice", "key", []string{auth.ScopeUsersWrite}), http.StatusOK},
+		"missing claims":     {nil, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodPatch, "/api/v1/users/alice", nil)
+		if tt.claims != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "tokenClaims", tt.claims))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s got status %d, want %d", name, w.Code, tt.want)
+		}
+		if w.Code == http.StatusForbidden {
+			want := `Bearer realm="uiassignment", error="insufficient_scope", scope="users:write"`
+			if got := w.Header().Get("WWW-Authenticate"); got != want {
+				t.Errorf("%s got challenge %s", name, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// swagger:models ApiKeys
+// @Description Long-lived API key of a user for automation
+type ApiKeys struct {
+	// Key ID
+	ID string `json:"id" gorm:"primaryKey; column:id"`
+	// Account the key acts for
+	Acct string `json:"account" gorm:"column:acct"`
+	// Name given by the user
+	Name string `json:"name" gorm:"column:name"`
+	// SHA-256 hash of the key
+	KeyHash string `json:"-" gorm:"column:key_hash"`
+	// Space separated scopes granted to the key
+	Scopes string `json:"-" gorm:"column:scopes"`
+	// The time when the key expires, never if empty
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// The time when the key was last used
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	// The time when the key was created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Scopes granted to the key
+func (k ApiKeys) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// Reports whether the key has expired at the given time.
+func (k ApiKeys) IsExpired(at time.Time) bool {
+	return k.ExpiresAt != nil && !at.Before(*k.ExpiresAt)
+}
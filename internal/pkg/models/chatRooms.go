@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// swagger:models ChatRooms
+// @Description Named chat room with persisted messages
+type ChatRooms struct {
+	// Room name
+	Name string `json:"name" gorm:"primaryKey; column:name"`
+	// Description of the room
+	Description string `json:"description" gorm:"column:description"`
+	// Account which created the room
+	CreatedBy *string `json:"createdBy" gorm:"column:created_by"`
+	// Messages older than this many days are deleted, never if empty
+	RetentionDays *int `json:"retentionDays" gorm:"column:retention_days"`
+	// Only this many latest messages are kept, all if empty
+	MaxMessages *int `json:"maxMessages" gorm:"column:max_messages"`
+	// The time when the room was created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Oldest time of messages kept by the retention of the room, zero if messages
+// don't expire.
+func (c ChatRooms) RetainedSince(now time.Time) time.Time {
+	if c.RetentionDays == nil {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -*c.RetentionDays)
+}
+
+// swagger:models ChatMessages
+// @Description Chat message sent to a room
+type ChatMessages struct {
+	// Message ID
+	ID string `json:"id" gorm:"primaryKey; column:id"`
+	// Room the message was sent to
+	Room string `json:"room" gorm:"column:room"`
+	// Account which sent the message
+	Acct string `json:"account" gorm:"column:acct"`
+	// Message text
+	Text string `json:"text" gorm:"column:text"`
+	// The time when the message was sent
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// swagger:models ChatMutes
+// @Description Account which can't send chat messages for a while
+type ChatMutes struct {
+	// Muted account
+	Acct string `json:"account" gorm:"primaryKey; column:acct"`
+	// The time when the account can send chat messages again
+	MutedUntil time.Time `json:"mutedUntil" gorm:"column:muted_until"`
+	// The time when the account was muted
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Message of the websocket backplane too large for a Postgres NOTIFY payload
+type BackplanePayloads struct {
+	// ID notified in place of the message
+	ID string `gorm:"primaryKey; column:id"`
+	// Encoded message
+	Payload string `gorm:"column:payload"`
+	// The time when the message was published
+	CreatedAt time.Time
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// swagger:models OauthClients
+// @Description OAuth client registered by a user
+type OauthClients struct {
+	// Client ID
+	ClientID string `json:"clientId" gorm:"primaryKey; column:client_id"`
+	// SHA-256 hash of the client secret, empty for public clients
+	SecretHash string `json:"-" gorm:"column:secret_hash"`
+	// Human readable name shown on consent
+	Name string `json:"name" gorm:"column:name"`
+	// Account which registered the client
+	OwnerAcct string `json:"ownerAccount" gorm:"column:owner_acct"`
+	// Space separated redirect URIs
+	RedirectURIs string `json:"-" gorm:"column:redirect_uris"`
+	// Space separated scopes the client may request
+	Scopes string `json:"-" gorm:"column:scopes"`
+	// The time when the client was registered
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Registered redirect URIs
+func (c OauthClients) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+// Scopes the client may request
+func (c OauthClients) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// Whether the client can keep a secret
+func (c OauthClients) IsConfidential() bool {
+	return len(c.SecretHash) > 0
+}
+
+// swagger:models OauthAuthorizationCodes
+// @Description Authorization code issued to an OAuth client, only its hash is stored
+type OauthAuthorizationCodes struct {
+	// SHA-256 hash of the code
+	CodeHash string `gorm:"primaryKey; column:code_hash"`
+	// Client the code was issued to
+	ClientID string `gorm:"column:client_id"`
+	// Account which authorized the client
+	Acct string `gorm:"column:acct"`
+	// Redirect URI of the authorization request
+	RedirectURI string `gorm:"column:redirect_uri"`
+	// Space separated granted scopes
+	Scope string `gorm:"column:scope"`
+	// PKCE S256 code challenge
+	CodeChallenge string `gorm:"column:code_challenge"`
+	// The time when the code expires
+	ExpiresAt time.Time
+	// The time when the code was created
+	CreatedAt time.Time
+}
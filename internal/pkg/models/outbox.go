@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Event waiting in the transactional outbox to be relayed
+type OutboxEntries struct {
+	// Increasing with every entry, events of an aggregate are relayed in this order
+	ID int64 `gorm:"primaryKey; column:id"`
+	// ID of the event
+	EventID string `gorm:"column:event_id"`
+	// Type of the event
+	EventType string `gorm:"column:event_type"`
+	// What the event is about, e.g. the user account
+	Aggregate string `gorm:"column:aggregate"`
+	// Encoded event
+	Payload string `gorm:"column:payload"`
+	// Number of failed attempts to relay the event
+	Attempts int `gorm:"column:attempts"`
+	// The time of the next attempt
+	NextAttemptAt time.Time `gorm:"column:next_attempt_at"`
+	// Why the last attempt failed
+	LastError string `gorm:"column:last_error"`
+	// The time when the event was written
+	CreatedAt time.Time
+	// The time when the event was relayed, empty while pending
+	DeliveredAt *time.Time `gorm:"column:delivered_at"`
+}
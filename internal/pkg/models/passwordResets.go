@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// swagger:models PasswordResets
+// @Description Single-use password reset token, only its hash is stored
+type PasswordResets struct {
+	// SHA-256 hash of the reset token
+	TokenHash string `gorm:"primaryKey; column:token_hash"`
+	// Account the token resets
+	Acct string `gorm:"column:acct"`
+	// The time when the token expires
+	ExpiresAt time.Time
+	// The time when the token was redeemed
+	UsedAt *time.Time
+	// The time when the token was created
+	CreatedAt time.Time
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// swagger:models Sessions
+// @Description Login session of a user
+type Sessions struct {
+	// Session ID
+	ID string `json:"id" gorm:"primaryKey; column:id"`
+	// Account the session belongs to
+	Acct string `json:"account" gorm:"column:acct"`
+	// cookie or token
+	Kind string `json:"kind" gorm:"column:kind"`
+	// SHA-256 hash of the session token, empty for token sessions
+	TokenHash *string `json:"-" gorm:"column:token_hash"`
+	// Device described by the user agent, e.g. Firefox on Linux
+	Device string `json:"device" gorm:"column:device"`
+	// Remote address which created the session
+	IP string `json:"ip" gorm:"column:ip"`
+	// User agent which created the session
+	UserAgent string `json:"userAgent" gorm:"column:user_agent"`
+	// The time when the session was created
+	CreatedAt time.Time `json:"createdAt"`
+	// The time when the session was last used
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	// The time when the session expires unless it's used
+	ExpiresAt time.Time `json:"expiresAt"`
+	// The time when the session expires at the latest
+	MaxExpiresAt time.Time `json:"-"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// swagger:models TotpCredentials
+// @Description TOTP second factor of an account
+type TotpCredentials struct {
+	// Account the secret belongs to
+	Acct string `gorm:"primaryKey; column:acct"`
+	// Base32 encoded shared secret
+	Secret string `gorm:"column:secret"`
+	// Whether enrollment has been confirmed with a first code
+	Enabled bool `gorm:"column:enabled"`
+	// Time step of the last accepted code, codes can't be reused
+	LastUsedStep int64 `gorm:"column:last_used_step"`
+	// Consecutive failed verifications
+	FailedAttempts int `gorm:"column:failed_attempts"`
+	// Verification is refused until this time after too many failures
+	LockedUntil *time.Time `gorm:"column:locked_until"`
+	// The time when enrollment started
+	CreatedAt time.Time
+	// The time when the credential was last updated
+	UpdatedAt time.Time
+}
+
+// swagger:models TotpRecoveryCodes
+// @Description One-time recovery code for accounts with TOTP, only its hash is stored
+type TotpRecoveryCodes struct {
+	// SHA-256 hash of the recovery code
+	CodeHash string `gorm:"primaryKey; column:code_hash"`
+	// Account the code belongs to
+	Acct string `gorm:"column:acct"`
+	// The time when the code was used
+	UsedAt *time.Time
+	// The time when the code was created
+	CreatedAt time.Time
+}
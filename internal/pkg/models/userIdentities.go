@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// swagger:models UserIdentities
+// @Description Link between a user and an identity at an upstream OIDC provider
+type UserIdentities struct {
+	// Name of the provider
+	Provider string `json:"provider" gorm:"primaryKey; column:provider"`
+	// Subject identifier issued by the provider
+	Subject string `json:"-" gorm:"primaryKey; column:subject"`
+	// Account the identity is linked to
+	Acct string `json:"account" gorm:"column:acct"`
+	// The time when the identity was linked
+	CreatedAt time.Time `json:"createdAt"`
+	// The time when the identity was last used for login
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
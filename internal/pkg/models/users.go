@@ -1,6 +1,15 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// Where the password of a user is verified
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLDAP  = "ldap"
+)
 
 // swagger:models Users
 // @Description Full user data
@@ -11,10 +20,31 @@ type Users struct {
 	Password string `json:"password,omitempty" gorm:"column:pwd"`
 	// User's full name
 	FullName string `json:"fullName" gorm:"column:fullname"`
+	// User's email address, lowercase
+	Email *string `json:"email,omitempty" gorm:"column:email"`
+	// Whether the owner has verified the email address
+	EmailVerified bool `json:"emailVerified" gorm:"column:email_verified"`
+	// User's roles, space separated
+	Roles string `json:"roles,omitempty" gorm:"column:roles"`
+	// Where the password is verified, local or ldap
+	AuthSource string `json:"-" gorm:"column:auth_source;default:local"`
 	// The time when the account was created
 	CreatedAt time.Time `json:"createdAt"`
 	// The time when the account was last updated
 	UpdatedAt time.Time `json:"updatedAt"`
+	// Access tokens without a session issued up to this time are revoked.
+	// Sessions and their tokens are revoked by deleting the session.
+	TokensValidAfter *time.Time `json:"-" gorm:"column:tokens_valid_after"`
+}
+
+// List of the user's roles
+func (u Users) RoleList() []string {
+	return strings.Fields(u.Roles)
+}
+
+// Reports whether the user's password is kept in the LDAP directory.
+func (u Users) IsDirectoryUser() bool {
+	return u.AuthSource == AuthSourceLDAP
 }
 
 // swagger:models UsersList
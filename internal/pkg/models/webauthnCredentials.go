@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// swagger:models WebauthnCredentials
+// @Description WebAuthn credential(passkey or security key) registered by a user
+type WebauthnCredentials struct {
+	// Credential ID, base64url encoded
+	ID string `json:"id" gorm:"primaryKey; column:credential_id"`
+	// Account the credential belongs to
+	Acct string `json:"account" gorm:"column:acct"`
+	// Name given by the user
+	Name string `json:"name" gorm:"column:name"`
+	// COSE encoded public key
+	PublicKey []byte `json:"-" gorm:"column:public_key"`
+	// Signature counter reported by the authenticator
+	SignCount int64 `json:"-" gorm:"column:sign_count"`
+	// Authenticator model identifier
+	Aaguid []byte `json:"-" gorm:"column:aaguid"`
+	// The time when the credential was registered
+	CreatedAt time.Time `json:"createdAt"`
+	// The time when the credential was last used for login
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// swagger:models WebauthnChallenges
+// @Description Pending WebAuthn ceremony, only the hash of its challenge is stored
+type WebauthnChallenges struct {
+	// SHA-256 hash of the challenge
+	ChallengeHash string `gorm:"primaryKey; column:challenge_hash"`
+	// Account performing the ceremony, empty for discoverable credential login
+	Acct string `gorm:"column:acct"`
+	// registration or login
+	Ceremony string `gorm:"column:ceremony"`
+	// The time when the challenge expires
+	ExpiresAt time.Time
+	// The time when the challenge was created
+	CreatedAt time.Time
+}
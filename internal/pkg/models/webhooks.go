@@ -0,0 +1,75 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// Failed every attempt, only retried on request
+	DeliveryDead = "dead"
+)
+
+// swagger:models WebhookSubscriptions
+// @Description Endpoint events are delivered to
+type WebhookSubscriptions struct {
+	// Subscription ID
+	ID string `json:"id" gorm:"primaryKey; column:id"`
+	// URL events are posted to
+	URL string `json:"url" gorm:"column:url"`
+	// Space separated event types delivered, * for all
+	Events string `json:"-" gorm:"column:events"`
+	// Key for signing deliveries
+	Secret string `json:"-" gorm:"column:secret"`
+	// Account which created the subscription
+	CreatedBy *string `json:"createdBy" gorm:"column:created_by"`
+	// The time when the subscription was created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Event types delivered to the subscription
+func (s WebhookSubscriptions) EventList() []string {
+	return strings.Fields(s.Events)
+}
+
+// Reports whether events of the type are delivered to the subscription.
+func (s WebhookSubscriptions) Matches(eventType string) bool {
+	for _, subscribed := range s.EventList() {
+		if subscribed == "*" || subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// swagger:models WebhookDeliveries
+// @Description Delivery of an event to a webhook subscription
+type WebhookDeliveries struct {
+	// Delivery ID
+	ID string `json:"id" gorm:"primaryKey; column:id"`
+	// Subscription the event is delivered to
+	SubscriptionID string `json:"subscriptionId" gorm:"column:subscription_id"`
+	// ID of the delivered event
+	EventID string `json:"eventId" gorm:"column:event_id"`
+	// Type of the delivered event
+	EventType string `json:"eventType" gorm:"column:event_type"`
+	// Request body
+	Payload string `json:"payload" gorm:"column:payload"`
+	// pending, succeeded or dead
+	Status string `json:"status" gorm:"column:status"`
+	// Number of attempts so far
+	Attempts int `json:"attempts" gorm:"column:attempts"`
+	// The time of the next attempt while pending
+	NextAttemptAt time.Time `json:"nextAttemptAt" gorm:"column:next_attempt_at"`
+	// HTTP status of the last attempt, empty if no response was received
+	LastStatusCode *int `json:"lastStatusCode" gorm:"column:last_status_code"`
+	// Why the last attempt failed
+	LastError string `json:"lastError" gorm:"column:last_error"`
+	// The time when the delivery was created
+	CreatedAt time.Time `json:"createdAt"`
+	// The time of the last attempt or change
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,15 @@
+package notify
+
+import (
+	"context"
+	"log"
+)
+
+// LogNotifier writes messages to the server log instead of delivering them.
+// Meant for development and demo deployments.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, message Message) error {
+	log.Printf("Notification to %s\nSubject: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"uiassignment/internal/pkg/config"
+)
+
+var (
+	notifierType = config.Get("NOTIFIER", "log")
+	smtpHost     = config.Get("SMTP_HOST", "localhost")
+	smtpPort     = config.Get("SMTP_PORT", "25")
+	smtpUser     = config.Get("SMTP_USER", "")
+	smtpPassword = config.Get("SMTP_PWD", "")
+	smtpFrom     = config.Get("SMTP_FROM", "no-reply@uiassignment.local")
+)
+
+// Base URL of the service used in links sent to users
+var PublicBaseURL = config.Get("PUBLIC_BASE_URL", "http://localhost")
+
+// Domain appended to an account to form its recipient address
+var RecipientDomain = config.Get("NOTIFY_RECIPIENT_DOMAIN", "uiassignment.local")
+
+// Message is a notification sent to a single recipient.
+type Message struct {
+	// Recipient address
+	To string
+	// Subject line
+	Subject string
+	// Plain text body
+	Body string
+}
+
+// Notifier delivers messages to users out of band, e.g. by email.
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Creates the notifier selected by the NOTIFIER env variable(log or smtp).
+func New() Notifier {
+	switch notifierType {
+	case "smtp":
+		return NewSMTPNotifier(smtpHost, smtpPort, smtpUser, smtpPassword, smtpFrom)
+	case "log":
+		return LogNotifier{}
+	default:
+		log.Printf("Unknown NOTIFIER %q, falling back to log", notifierType)
+		return LogNotifier{}
+	}
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPNotifier delivers messages as plain text emails through an SMTP server.
+// STARTTLS is used when the server offers it.
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// Creates an SMTP notifier. Authentication is skipped if no user is given.
+func NewSMTPNotifier(host string, port string, user string, password string, from string) *SMTPNotifier {
+	notifier := &SMTPNotifier{addr: net.JoinHostPort(host, port), from: from}
+	if len(user) > 0 {
+		notifier.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return notifier
+}
+
+func (s *SMTPNotifier) Send(ctx context.Context, message Message) error {
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return fmt.Errorf("invalid header value in message to %q", message.To)
+	}
+
+	var body strings.Builder
+	body.WriteString("From: " + s.from + "\r\n")
+	body.WriteString("To: " + message.To + "\r\n")
+	body.WriteString("Subject: " + message.Subject + "\r\n")
+	body.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(s.addr, s.auth, s.from, []string{message.To}, []byte(body.String()))
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// What the fake SMTP server received in a session
+type receivedMail struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// Serves a single SMTP session on a local port, without STARTTLS, and passes
+// on what the client sent.
+func startFakeSMTPServer(t *testing.T) (host string, port string, received <-chan receivedMail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan receivedMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		var mail receivedMail
+		text.PrintfLine("220 localhost ESMTP fake")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				text.PrintfLine("250-localhost\r\n250-8BITMIME\r\n250 AUTH PLAIN")
+			case "AUTH":
+				mail.auth = strings.TrimPrefix(line, "AUTH PLAIN ")
+				text.PrintfLine("235 2.7.0 Authentication successful")
+			case "MAIL":
+				mail.from = line
+				text.PrintfLine("250 2.1.0 Ok")
+			case "RCPT":
+				mail.to = append(mail.to, line)
+				text.PrintfLine("250 2.1.5 Ok")
+			case "DATA":
+				text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				mail.data = string(data)
+				text.PrintfLine("250 2.0.0 Ok: queued")
+			case "QUIT":
+				text.PrintfLine("221 2.0.0 Bye")
+				mails <- mail
+				return
+			default:
+				text.PrintfLine("502 5.5.2 Command not recognized")
+			}
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(listener.Addr().String())
+	return host, port, mails
+}
+
+func TestSMTPNotifierSend(t *testing.T) {
+	host, port, received := startFakeSMTPServer(t)
+	notifier := NewSMTPNotifier(host, port, "mailer", "secret", "no-reply@example.com")
+
+	err := notifier.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Verify your email",
+		Body:    "Hi alice,\nopen the link.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mail := <-received
+	if want := base64.StdEncoding.EncodeToString([]byte("\x00mailer\x00secret")); mail.auth != want {
+		t.Errorf("got auth %q, want %q", mail.auth, want)
+	}
+	if want := "MAIL FROM:<no-reply@example.com>"; !strings.HasPrefix(mail.from, want) {
+		t.Errorf("got %q, want %q", mail.from, want)
+	}
+	if len(mail.to) != 1 || mail.to[0] != "RCPT TO:<alice@example.com>" {
+		t.Errorf("got recipients %q", mail.to)
+	}
+
+	message, err := textproto.NewReader(bufio.NewReader(strings.NewReader(mail.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"From":         "no-reply@example.com",
+		"To":           "alice@example.com",
+		"Subject":      "Verify your email",
+		"Content-Type": "text/plain; charset=UTF-8",
+	} {
+		if got := message.Get(header); got != want {
+			t.Errorf("got %s %q, want %q", header, got, want)
+		}
+	}
+	if len(message.Get("Date")) == 0 {
+		t.Error("Date header is missing")
+	}
+	// ReadDotBytes turns CRLF into LF
+	if !strings.HasSuffix(mail.data, "\n\nHi alice,\nopen the link.\n") {
+		t.Errorf("got body %q", mail.data)
+	}
+}
+
+func TestSMTPNotifierRejectsHeaderInjection(t *testing.T) {
+	notifier := NewSMTPNotifier("127.0.0.1", "1", "", "", "no-reply@example.com")
+
+	for _, message := range []Message{
+		{To: "alice@example.com\r\nBcc: mallory@example.com", Subject: "Hi"},
+		{To: "alice@example.com", Subject: "Hi\nBcc: mallory@example.com"},
+	} {
+		if err := notifier.Send(context.Background(), message); err == nil {
+			t.Errorf("message %q was sent", message)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package oidc
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"uiassignment/internal/pkg/config"
+)
+
+// Config describes an upstream OpenID Connect provider users can log in with.
+type Config struct {
+	// Name used in our URLs, e.g. /api/v1/oidc/{name}/login
+	Name string
+	// Issuer identifier, the discovery document is read from {Issuer}/.well-known/openid-configuration
+	Issuer string
+	// Client ID registered at the provider
+	ClientID string
+	// Client secret registered at the provider
+	ClientSecret string
+	// Our callback URL registered at the provider
+	RedirectURL string
+	// Scopes requested in addition to openid
+	Scopes []string
+	// Whether unknown users are created on their first login
+	AutoProvision bool
+	// Whether an existing local user can be linked by a matching account name.
+	// Only enable it for providers that are authoritative for account names.
+	LinkByAccount bool
+}
+
+var providerNamePattern = regexp.MustCompile("^[a-z0-9]+$")
+
+// Configs of the providers named in OIDC_PROVIDERS(comma separated), each read
+// from OIDC_{NAME}_* env variables
+var DefaultConfigs = loadConfigs(config.Get("OIDC_PROVIDERS", ""))
+
+func loadConfigs(names string) []Config {
+	var configs []Config
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		if !providerNamePattern.MatchString(name) {
+			log.Printf("Ignoring OIDC provider %q, names must be lowercase alphanumeric", name)
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := Config{
+			Name:          name,
+			Issuer:        strings.TrimSuffix(config.Get(prefix+"ISSUER", ""), "/"),
+			ClientID:      config.Get(prefix+"CLIENT_ID", ""),
+			ClientSecret:  config.Get(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:   config.Get(prefix+"REDIRECT_URL", "http://localhost/api/v1/oidc/"+name+"/callback"),
+			Scopes:        strings.Fields(config.Get(prefix+"SCOPES", "email profile")),
+			AutoProvision: config.Get(prefix+"AUTO_PROVISION", "true") == "true",
+			LinkByAccount: config.Get(prefix+"LINK_BY_ACCOUNT", "false") == "true",
+		}
+		if len(config.Issuer) == 0 || len(config.ClientID) == 0 {
+			log.Printf("Ignoring OIDC provider %q, %sISSUER and %sCLIENT_ID are required", name, prefix, prefix)
+			continue
+		}
+		configs = append(configs, config)
+	}
+	return configs
+}
@@ -0,0 +1,86 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Clock skew tolerated when checking token timestamps
+const allowedClockSkew = time.Minute
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Claims of an ID token(OpenID Connect Core section 2) we rely on
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Checks the time based claims, called by the JWT parser.
+func (c *IDTokenClaims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(allowedClockSkew)) {
+		return ErrInvalidIDToken
+	}
+	if c.IssuedAt == 0 || now.Before(time.Unix(c.IssuedAt, 0).Add(-allowedClockSkew)) {
+		return ErrInvalidIDToken
+	}
+	return nil
+}
+
+// Local account name derived from the preferred username, or the local part of
+// the email if there is none. Characters other than letters and digits are
+// dropped as accounts are alphanumeric.
+func (c *IDTokenClaims) Account() string {
+	name := c.PreferredUsername
+	if len(name) == 0 {
+		name = c.Email
+	}
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// The aud claim is either a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// How long discovery documents and key sets are cached
+const metadataCacheTTL = time.Hour
+
+// Key sets are refetched on unknown key IDs at most this often
+const keyRefreshInterval = time.Minute
+
+var ErrTokenExchangeFailed = errors.New("authorization code exchange failed")
+
+// Subset of the provider metadata(OpenID Connect Discovery section 3) we use
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Provider is an upstream OpenID Connect provider. Its metadata and keys are
+// fetched on first use and cached.
+type Provider struct {
+	Config     Config
+	HTTPClient *http.Client
+
+	mutex         sync.Mutex
+	metadata      *metadata
+	metadataAt    time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func NewProvider(config Config) *Provider {
+	return &Provider{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Providers configured by env variables, indexed by name
+var Providers = newProviders(DefaultConfigs)
+
+func newProviders(configs []Config) map[string]*Provider {
+	providers := make(map[string]*Provider)
+	for _, config := range configs {
+		providers[config.Name] = NewProvider(config)
+	}
+	return providers
+}
+
+// Builds the URL of the provider's authorization endpoint to send the user to.
+// The code challenge is the S256 PKCE challenge of the verifier later given to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", p.Config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.Config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Redeems an authorization code at the token endpoint and returns the claims of
+// the validated ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*IDTokenClaims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if len(p.Config.ClientSecret) == 0 {
+		form.Set("client_id", p.Config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(p.Config.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenExchangeFailed, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s %s", ErrTokenExchangeFailed, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if len(tokenResponse.IDToken) == 0 {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrTokenExchangeFailed)
+	}
+
+	return p.VerifyIDToken(ctx, tokenResponse.IDToken, nonce)
+}
+
+// Validates the signature, issuer, audience, lifetime and nonce of an ID token.
+func (p *Provider) VerifyIDToken(ctx context.Context, idToken string, nonce string) (*IDTokenClaims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDTokenClaims{}
+	token, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, ErrInvalidIDToken
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, md, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Issuer != md.Issuer || len(claims.Subject) == 0 {
+		return nil, ErrInvalidIDToken
+	}
+	if !claims.Audience.contains(p.Config.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+	if len(claims.AuthorizedParty) > 0 && claims.AuthorizedParty != p.Config.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil && time.Since(p.metadataAt) < metadataCacheTTL {
+		return p.metadata, nil
+	}
+
+	var md metadata
+	if err := p.getJSON(ctx, p.Config.Issuer+"/.well-known/openid-configuration", &md); err != nil {
+		return nil, err
+	}
+	// The issuer in the document must be the one we're configured with(section 4.3)
+	if strings.TrimSuffix(md.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch in discovery document of %s: %s", p.Config.Name, md.Issuer)
+	}
+	if len(md.AuthorizationEndpoint) == 0 || len(md.TokenEndpoint) == 0 || len(md.JwksURI) == 0 {
+		return nil, fmt.Errorf("incomplete discovery document of %s", p.Config.Name)
+	}
+
+	p.metadata = &md
+	p.metadataAt = time.Now()
+	p.keys = nil
+	return p.metadata, nil
+}
+
+// Looks up the key with the given ID, refetching the key set if the ID is
+// unknown, e.g. after the provider rotated its keys.
+func (p *Provider) signingKey(ctx context.Context, md *metadata, kid string) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < keyRefreshInterval {
+		return nil, ErrInvalidIDToken
+	}
+
+	var set jsonWebKeySet
+	if err := p.getJSON(ctx, md.JwksURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = set.signingKeys()
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, ErrInvalidIDToken
+}
+
+// Tokens without a key ID are accepted only if the set has a single key.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if p.keys == nil || time.Since(p.keysFetchedAt) > metadataCacheTTL {
+		return nil, false
+	}
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,189 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/config"
+	"uiassignment/internal/pkg/events"
+	"uiassignment/internal/pkg/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	pollPeriod     = config.GetDuration("OUTBOX_POLL_PERIOD", time.Second)
+	retryBaseDelay = config.GetDuration("OUTBOX_RETRY_BASE_DELAY", time.Second)
+	retryMaxDelay  = config.GetDuration("OUTBOX_RETRY_MAX_DELAY", 5*time.Minute)
+	// How long delivered entries are kept, e.g. for troubleshooting
+	retention = config.GetDuration("OUTBOX_RETENTION", 24*time.Hour)
+	// How long claimed entries are left to a relay before others may claim
+	// them again, e.g. after it stopped while publishing
+	claimTimeout = config.GetDuration("OUTBOX_CLAIM_TIMEOUT", time.Minute)
+)
+
+const (
+	cleanupPeriod = time.Minute
+	// Events claimed at once
+	batchSize      = 50
+	maxErrorLength = 255
+)
+
+// The oldest pending entry of each aggregate
+const pendingEntryIDs = "SELECT min(id) FROM outbox_entries WHERE delivered_at IS NULL GROUP BY aggregate"
+
+// Write adds the event to the outbox using the given DB session. Passing the
+// transaction which makes the change the event is about guarantees the event
+// is relayed if, and only if, the change is committed.
+func Write(db *gorm.DB, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	result := db.Create(&models.OutboxEntries{
+		EventID:       event.ID,
+		EventType:     event.Type,
+		Aggregate:     event.Account,
+		Payload:       string(payload),
+		NextAttemptAt: event.OccurredAt,
+		CreatedAt:     event.OccurredAt,
+	})
+	return result.Error
+}
+
+// Relay publishes events written to the outbox at least once, in the order
+// they were written for each aggregate. An event which failed to be published
+// holds back later events of its aggregate until it's retried successfully.
+// An event is published again if marking it delivered fails, or its relay
+// stops before marking it.
+type Relay struct {
+	db      *gorm.DB
+	publish events.Handler
+}
+
+// NewRelay creates a relay passing events to publish, e.g. the Publish method
+// of an event bus.
+func NewRelay(db *gorm.DB, publish events.Handler) *Relay {
+	return &Relay{db: db, publish: publish}
+}
+
+// Run relays pending events and removes delivered ones older than
+// OUTBOX_RETENTION until the context is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollPeriod)
+	defer ticker.Stop()
+	cleanupTicker := time.NewTicker(cleanupPeriod)
+	defer cleanupTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				relayed, err := r.relayPending(ctx)
+				if err != nil {
+					log.Println(err.Error())
+				}
+				if relayed < batchSize {
+					break
+				}
+			}
+		case <-cleanupTicker.C:
+			if err := r.removeDelivered(ctx); err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}
+}
+
+// Relays the oldest pending event of each aggregate which is due. Entries are
+// claimed in a short transaction and published after it's committed, so no
+// row stays locked while handlers run. Claimed entries are due again after
+// OUTBOX_CLAIM_TIMEOUT, which keeps replicas from publishing them meanwhile
+// and later events of their aggregates waiting.
+func (r *Relay) relayPending(ctx context.Context) (int, error) {
+	entries, err := r.claimDue(ctx)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, claimTimeout)
+	defer cancel()
+	for i := range entries {
+		entry := &entries[i]
+		if err := r.relay(publishCtx, entry); err != nil {
+			entry.Attempts++
+			entry.NextAttemptAt = time.Now().Add(retryDelay(entry.Attempts))
+			entry.LastError = err.Error()
+			if len(entry.LastError) > maxErrorLength {
+				entry.LastError = strings.ToValidUTF8(entry.LastError[:maxErrorLength], "")
+			}
+			log.Printf("Relaying event %s failed: %s", entry.EventID, entry.LastError)
+		} else {
+			deliveredAt := time.Now()
+			entry.DeliveredAt = &deliveredAt
+		}
+		if result := r.db.WithContext(ctx).Save(entry); result.Error != nil {
+			return i, result.Error
+		}
+	}
+	return len(entries), nil
+}
+
+// Claims the oldest pending entry of each aggregate which is due by moving
+// its next attempt OUTBOX_CLAIM_TIMEOUT ahead. Entries locked by other relays
+// are skipped.
+func (r *Relay) claimDue(ctx context.Context) ([]models.OutboxEntries, error) {
+	var entries []models.OutboxEntries
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id IN ("+pendingEntryIDs+") AND delivered_at IS NULL AND next_attempt_at <= ?", now).
+			Order("id").Limit(batchSize).Find(&entries); result.Error != nil || len(entries) == 0 {
+			return result.Error
+		}
+
+		ids := make([]int64, len(entries))
+		claimedUntil := now.Add(claimTimeout)
+		for i := range entries {
+			ids[i] = entries[i].ID
+			entries[i].NextAttemptAt = claimedUntil
+		}
+		return tx.Model(&models.OutboxEntries{}).Where("id IN ?", ids).
+			Update("next_attempt_at", claimedUntil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Removes entries delivered longer than OUTBOX_RETENTION ago.
+func (r *Relay) removeDelivered(ctx context.Context) error {
+	return r.db.WithContext(ctx).Where("delivered_at < ?", time.Now().Add(-retention)).
+		Delete(&models.OutboxEntries{}).Error
+}
+
+func (r *Relay) relay(ctx context.Context, entry *models.OutboxEntries) error {
+	var event events.Event
+	if err := json.Unmarshal([]byte(entry.Payload), &event); err != nil {
+		return err
+	}
+	return r.publish(ctx, event)
+}
+
+// Doubles from OUTBOX_RETRY_BASE_DELAY up to OUTBOX_RETRY_MAX_DELAY with every
+// failed attempt.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,233 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/db"
+	"uiassignment/internal/pkg/events"
+	"uiassignment/internal/pkg/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Connects to the database set up by `make start_db init_db`. Tests needing
+// it are skipped if it can't be reached.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	testDB, err := gorm.Open(postgres.Open(db.DSN()+" connect_timeout=2"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Skipf("test database isn't available: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := testDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return testDB
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		9:  256 * time.Second,
+		10: 5 * time.Minute,
+		50: 5 * time.Minute,
+	} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("delay after %d attempts = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// Writes events about an aggregate no other test run uses and removes them
+// when the test ends.
+func writeTestEvents(t *testing.T, testDB *gorm.DB, count int) (aggregate string, eventIDs []string) {
+	t.Helper()
+	suffix, err := auth.GenerateRandomToken(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregate = "outbox_" + suffix
+	t.Cleanup(func() {
+		testDB.Where("aggregate = ?", aggregate).Delete(&models.OutboxEntries{})
+	})
+
+	for i := 0; i < count; i++ {
+		event, err := events.New(events.UserUpdated, aggregate, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Write(testDB, event); err != nil {
+			t.Fatal(err)
+		}
+		eventIDs = append(eventIDs, event.ID)
+	}
+	return aggregate, eventIDs
+}
+
+// Records published events of one aggregate, failing the first attempts of
+// the events in failures.
+type testPublisher struct {
+	mu        sync.Mutex
+	aggregate string
+	failures  map[string]int
+	published []string
+	// Called with each event of the aggregate before it's published
+	before func(event events.Event)
+}
+
+func (p *testPublisher) publish(ctx context.Context, event events.Event) error {
+	if event.Account != p.aggregate {
+		return nil
+	}
+	if p.before != nil {
+		p.before(event)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures[event.ID] > 0 {
+		p.failures[event.ID]--
+		return errors.New("subscriber unavailable")
+	}
+	p.published = append(p.published, event.ID)
+	return nil
+}
+
+func (p *testPublisher) publishedIDs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.published...)
+}
+
+// Makes failed entries of the aggregate due at once instead of waiting for
+// the backoff.
+func makeDue(t *testing.T, testDB *gorm.DB, aggregate string) {
+	t.Helper()
+	if result := testDB.Model(&models.OutboxEntries{}).Where("aggregate = ? AND delivered_at IS NULL", aggregate).
+		Update("next_attempt_at", time.Now().Add(-time.Second)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+}
+
+// A failed event is retried with backoff and holds back later events of its
+// aggregate until it's published.
+func TestRelayRetriesInAggregateOrder(t *testing.T) {
+	testDB := openTestDB(t)
+	aggregate, eventIDs := writeTestEvents(t, testDB, 3)
+	publisher := &testPublisher{aggregate: aggregate, failures: map[string]int{eventIDs[0]: 2}}
+	relay := NewRelay(testDB, publisher.publish)
+	ctx := context.Background()
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		startedAt := time.Now()
+		if _, err := relay.relayPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if published := publisher.publishedIDs(); len(published) > 0 {
+			t.Fatalf("events published while the first one fails: %v", published)
+		}
+
+		var entry models.OutboxEntries
+		if result := testDB.Where("event_id = ?", eventIDs[0]).First(&entry); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if entry.Attempts != attempt || !strings.Contains(entry.LastError, "subscriber unavailable") {
+			t.Errorf("attempt %d recorded %d attempts, error %q", attempt, entry.Attempts, entry.LastError)
+		}
+		if entry.NextAttemptAt.Before(startedAt.Add(retryDelay(attempt))) {
+			t.Errorf("attempt %d is retried at %s, before its backoff", attempt, entry.NextAttemptAt)
+		}
+
+		// Not due before the backoff passed
+		if _, err := relay.relayPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if published := publisher.publishedIDs(); len(published) > 0 {
+			t.Fatalf("events published before the backoff passed: %v", published)
+		}
+		makeDue(t, testDB, aggregate)
+	}
+
+	for i := 0; i < len(eventIDs); i++ {
+		if _, err := relay.relayPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	published := publisher.publishedIDs()
+	if strings.Join(published, ",") != strings.Join(eventIDs, ",") {
+		t.Errorf("published %v, want %v", published, eventIDs)
+	}
+}
+
+// Handlers run after the claim is committed, so the entry isn't locked while
+// they run, and it isn't due for other relays either.
+func TestRelayPublishesWithoutLocks(t *testing.T) {
+	testDB := openTestDB(t)
+	aggregate, eventIDs := writeTestEvents(t, testDB, 1)
+	publisher := &testPublisher{aggregate: aggregate}
+	publisher.before = func(event events.Event) {
+		err := testDB.Transaction(func(tx *gorm.DB) error {
+			var entry models.OutboxEntries
+			if result := tx.Raw("SELECT * FROM outbox_entries WHERE event_id = ? FOR UPDATE NOWAIT", event.ID).
+				Scan(&entry); result.Error != nil {
+				return result.Error
+			}
+			if !entry.NextAttemptAt.After(time.Now()) {
+				t.Errorf("entry is due again while it's published, at %s", entry.NextAttemptAt)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("entry is locked while it's published: %v", err)
+		}
+	}
+
+	if _, err := NewRelay(testDB, publisher.publish).relayPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if published := publisher.publishedIDs(); len(published) != 1 || published[0] != eventIDs[0] {
+		t.Errorf("published %v", published)
+	}
+	var entry models.OutboxEntries
+	if result := testDB.Where("event_id = ?", eventIDs[0]).First(&entry); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if entry.DeliveredAt == nil {
+		t.Error("published entry wasn't marked delivered")
+	}
+}
+
+// Only entries delivered longer than the retention ago are removed.
+func TestRemoveDelivered(t *testing.T) {
+	testDB := openTestDB(t)
+	aggregate, eventIDs := writeTestEvents(t, testDB, 3)
+	for i, deliveredAt := range []time.Time{time.Now().Add(-retention - time.Minute), time.Now()} {
+		if result := testDB.Model(&models.OutboxEntries{}).Where("event_id = ?", eventIDs[i]).
+			Update("delivered_at", deliveredAt); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	if err := NewRelay(testDB, nil).removeDelivered(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var remaining []string
+	if result := testDB.Model(&models.OutboxEntries{}).Where("aggregate = ?", aggregate).
+		Order("id").Pluck("event_id", &remaining); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if strings.Join(remaining, ",") != strings.Join(eventIDs[1:], ",") {
+		t.Errorf("remaining entries %v, want %v", remaining, eventIDs[1:])
+	}
+}
@@ -0,0 +1,27 @@
+package webauthn
+
+import (
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/config"
+)
+
+// Config describes the relying party, i.e. this service, to authenticators.
+type Config struct {
+	// Relying party ID, the effective domain of the origins
+	RPID string
+	// Human readable relying party name shown by authenticators
+	RPName string
+	// Origins allowed to perform ceremonies, e.g. https://example.com
+	Origins []string
+	// How long a ceremony can take
+	Timeout time.Duration
+}
+
+// Config read from WEBAUTHN_* env variables
+var DefaultConfig = Config{
+	RPID:    config.Get("WEBAUTHN_RP_ID", "localhost"),
+	RPName:  config.Get("WEBAUTHN_RP_NAME", "uiassignment"),
+	Origins: strings.Split(config.Get("WEBAUTHN_ORIGINS", "http://localhost,https://localhost"), ","),
+	Timeout: 5 * time.Minute,
+}
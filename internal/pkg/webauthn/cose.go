@@ -0,0 +1,116 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithm identifiers supported for credentials, in order of preference
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var supportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key types and parameters(RFC 8152)
+const (
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+
+	coseLabelKty = 1
+	coseLabelAlg = 3
+	// EC2/OKP: crv, x, y. RSA: n, e.
+	coseLabelParam1 = -1
+	coseLabelParam2 = -2
+	coseLabelParam3 = -3
+)
+
+var ErrUnsupportedKey = errors.New("unsupported credential public key")
+
+// Parses a COSE encoded public key of a supported algorithm.
+func parsePublicKey(coseKey []byte) (crypto.PublicKey, error) {
+	var key map[int]cbor.RawMessage
+	if err := cbor.Unmarshal(coseKey, &key); err != nil {
+		return nil, ErrUnsupportedKey
+	}
+
+	var kty, alg int
+	if cbor.Unmarshal(key[coseLabelKty], &kty) != nil || cbor.Unmarshal(key[coseLabelAlg], &alg) != nil {
+		return nil, ErrUnsupportedKey
+	}
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		var crv int
+		var x, y []byte
+		if cbor.Unmarshal(key[coseLabelParam1], &crv) != nil || crv != coseCrvP256 ||
+			cbor.Unmarshal(key[coseLabelParam2], &x) != nil ||
+			cbor.Unmarshal(key[coseLabelParam3], &y) != nil {
+			return nil, ErrUnsupportedKey
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, ErrUnsupportedKey
+		}
+		return publicKey, nil
+
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		var crv int
+		var x []byte
+		if cbor.Unmarshal(key[coseLabelParam1], &crv) != nil || crv != coseCrvEd25519 ||
+			cbor.Unmarshal(key[coseLabelParam2], &x) != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return ed25519.PublicKey(x), nil
+
+	case kty == coseKtyRSA && alg == AlgRS256:
+		var n, e []byte
+		if cbor.Unmarshal(key[coseLabelParam1], &n) != nil ||
+			cbor.Unmarshal(key[coseLabelParam2], &e) != nil || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: kty=%d alg=%d", ErrUnsupportedKey, kty, alg)
+	}
+}
+
+// Verifies a signature made by the COSE encoded public key over the data.
+func verifySignature(coseKey []byte, data []byte, signature []byte) error {
+	publicKey, err := parsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	var valid bool
+	switch publicKey := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = ecdsa.VerifyASN1(publicKey, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(publicKey, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies(https://www.w3.org/TR/webauthn-2/).
+//
+// Only "none" attestation is requested, so attestation statements aren't
+// verified. Binary values are exchanged with clients as base64url strings.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var (
+	ErrInvalidClientData    = errors.New("invalid client data")
+	ErrInvalidAuthData      = errors.New("invalid authenticator data")
+	ErrInvalidSignature     = errors.New("invalid assertion signature")
+	ErrUserNotPresent       = errors.New("user presence flag not set")
+	ErrSignCountNotIncrease = errors.New("signature counter didn't increase, credential may be cloned")
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+)
+
+var encoding = base64.RawURLEncoding
+
+// Generates a random challenge for a ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// Encodes binary values the way they're exchanged with clients.
+func EncodeID(id []byte) string {
+	return encoding.EncodeToString(id)
+}
+
+// Decodes binary values received from clients, padded or not.
+func DecodeID(id string) ([]byte, error) {
+	return encoding.DecodeString(strings.TrimRight(id, "="))
+}
+
+type relyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions
+// passed to navigator.credentials.create().
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     relyingParty           `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions
+// passed to navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+}
+
+// Builds registration options. The account is used as user handle, existing
+// credentials of the account are excluded from being registered again.
+func NewCreationOptions(config Config, challenge []byte, account string, displayName string, existingCredentialIDs [][]byte) CreationOptions {
+	options := CreationOptions{
+		Challenge: EncodeID(challenge),
+		RP:        relyingParty{ID: config.RPID, Name: config.RPName},
+		User: userEntity{
+			ID:          EncodeID([]byte(account)),
+			Name:        account,
+			DisplayName: displayName,
+		},
+		Timeout:     config.Timeout.Milliseconds(),
+		Attestation: "none",
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		ExcludeCredentials: descriptorsOf(existingCredentialIDs),
+	}
+	for _, alg := range supportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, credentialParameter{Type: "public-key", Alg: alg})
+	}
+	return options
+}
+
+// Builds authentication options. Without allowed credentials the client may
+// use any discoverable credential for this relying party.
+func NewRequestOptions(config Config, challenge []byte, allowedCredentialIDs [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        EncodeID(challenge),
+		RPID:             config.RPID,
+		Timeout:          config.Timeout.Milliseconds(),
+		UserVerification: "preferred",
+		AllowCredentials: descriptorsOf(allowedCredentialIDs),
+	}
+}
+
+func descriptorsOf(credentialIDs [][]byte) []credentialDescriptor {
+	descriptors := []credentialDescriptor{}
+	for _, id := range credentialIDs {
+		descriptors = append(descriptors, credentialDescriptor{Type: "public-key", ID: EncodeID(id)})
+	}
+	return descriptors
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.create().
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.get().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is a public key credential created by an authenticator.
+type Credential struct {
+	ID []byte
+	// COSE encoded public key
+	PublicKey []byte
+	SignCount uint32
+	// Authenticator model identifier, all zeros with "none" attestation
+	AAGUID []byte
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Returns the challenge a client response was created for, so the pending
+// ceremony can be looked up before verifying the response.
+func ChallengeOf(clientDataJSON string) ([]byte, error) {
+	raw, err := DecodeID(clientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidClientData
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidClientData
+	}
+	challenge, err := DecodeID(data.Challenge)
+	if err != nil {
+		return nil, ErrInvalidClientData
+	}
+	return challenge, nil
+}
+
+// Verifies client data and returns its raw bytes.
+func verifyClientData(config Config, clientDataJSON string, ceremonyType string, challenge []byte) ([]byte, error) {
+	raw, err := DecodeID(clientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidClientData
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidClientData
+	}
+	if data.Type != ceremonyType {
+		return nil, fmt.Errorf("%w: unexpected type %q", ErrInvalidClientData, data.Type)
+	}
+	receivedChallenge, err := DecodeID(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(receivedChallenge, challenge) != 1 {
+		return nil, fmt.Errorf("%w: challenge mismatch", ErrInvalidClientData)
+	}
+	originAllowed := false
+	for _, origin := range config.Origins {
+		if data.Origin == origin {
+			originAllowed = true
+		}
+	}
+	if !originAllowed {
+		return nil, fmt.Errorf("%w: origin %q not allowed", ErrInvalidClientData, data.Origin)
+	}
+	return raw, nil
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// Present in registration only
+	credential *Credential
+}
+
+// Parses authenticator data and checks it was created for this relying party
+// with the user present.
+func parseAuthenticatorData(config Config, raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrInvalidAuthData
+	}
+	data := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(config.RPID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, rpIDHash[:]) != 1 {
+		return nil, fmt.Errorf("%w: relying party ID mismatch", ErrInvalidAuthData)
+	}
+	if data.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+
+	if data.flags&flagAttestedData != 0 {
+		// aaguid(16) | credentialIdLength(2) | credentialId | credentialPublicKey(COSE)
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return nil, ErrInvalidAuthData
+		}
+		aaguid := rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return nil, ErrInvalidAuthData
+		}
+		credentialID := rest[:idLength]
+
+		var publicKey cbor.RawMessage
+		decoder := cbor.NewDecoder(bytes.NewReader(rest[idLength:]))
+		if err := decoder.Decode(&publicKey); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAuthData, err)
+		}
+
+		data.credential = &Credential{
+			ID:        append([]byte{}, credentialID...),
+			PublicKey: append([]byte{}, publicKey...),
+			SignCount: data.signCount,
+			AAGUID:    append([]byte{}, aaguid...),
+		}
+	}
+	return data, nil
+}
+
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// Verifies a registration response for the given challenge and returns the
+// new credential.
+func VerifyRegistration(config Config, challenge []byte, response RegistrationResponse) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("unsupported credential type %q", response.Type)
+	}
+	if _, err := verifyClientData(config, response.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := DecodeID(response.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	var attestation attestationObject
+	if err := cbor.Unmarshal(rawAttestation, &attestation); err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAuthenticatorData(config, attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.credential == nil {
+		return nil, fmt.Errorf("%w: missing attested credential data", ErrInvalidAuthData)
+	}
+
+	// Make sure the key is usable before storing it
+	if _, err := parsePublicKey(authData.credential.PublicKey); err != nil {
+		return nil, err
+	}
+	return authData.credential, nil
+}
+
+// Verifies an assertion response for the given challenge with the stored
+// credential. Returns the new signature counter to be stored.
+func VerifyAssertion(config Config, challenge []byte, response AssertionResponse, credential Credential) (signCount uint32, err error) {
+	if response.Type != "public-key" {
+		return 0, fmt.Errorf("unsupported credential type %q", response.Type)
+	}
+	rawClientData, err := verifyClientData(config, response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := DecodeID(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, ErrInvalidAuthData
+	}
+	authData, err := parseAuthenticatorData(config, rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	signature, err := DecodeID(response.Response.Signature)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	signedData := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := verifySignature(credential.PublicKey, signedData, signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators without counters always report zero
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCountNotIncrease
+	}
+	return authData.signCount, nil
+}
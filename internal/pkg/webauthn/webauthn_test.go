@@ -0,0 +1,339 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var testConfig = Config{
+	RPID:    "example.com",
+	RPName:  "Example",
+	Origins: []string{"https://example.com"},
+	Timeout: time.Minute,
+}
+
+// Software authenticator creating and using a single credential. The fields
+// describe what it puts into the next response, so tests can tamper with it.
+type softAuthenticator struct {
+	credentialID []byte
+	signer       func(data []byte) []byte
+	coseKey      []byte
+
+	rpID      string
+	origin    string
+	flags     byte
+	signCount uint32
+}
+
+func newES256Authenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	authenticator := newSoftAuthenticator(t, map[int]interface{}{
+		coseLabelKty: coseKtyEC2, coseLabelAlg: AlgES256,
+		coseLabelParam1: coseCrvP256, coseLabelParam2: x, coseLabelParam3: y,
+	})
+	authenticator.signer = func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	return authenticator
+}
+
+func newEd25519Authenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := newSoftAuthenticator(t, map[int]interface{}{
+		coseLabelKty: coseKtyOKP, coseLabelAlg: AlgEdDSA,
+		coseLabelParam1: coseCrvEd25519, coseLabelParam2: []byte(publicKey),
+	})
+	authenticator.signer = func(data []byte) []byte {
+		return ed25519.Sign(privateKey, data)
+	}
+	return authenticator
+}
+
+func newSoftAuthenticator(t *testing.T, coseKey map[int]interface{}) *softAuthenticator {
+	t.Helper()
+	encodedKey, err := cbor.Marshal(coseKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		credentialID: credentialID,
+		coseKey:      encodedKey,
+		rpID:         testConfig.RPID,
+		origin:       testConfig.Origins[0],
+		flags:        flagUserPresent,
+	}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremonyType string, challenge []byte) []byte {
+	t.Helper()
+	data, err := json.Marshal(clientData{Type: ceremonyType, Challenge: EncodeID(challenge), Origin: a.origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rpIdHash(32) | flags(1) | signCount(4) | attested credential data, if any
+func (a *softAuthenticator) authenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedData
+	}
+	data = append(data, flags)
+	signCount := make([]byte, 4)
+	binary.BigEndian.PutUint32(signCount, a.signCount)
+	data = append(data, signCount...)
+	if attested {
+		idLength := make([]byte, 2)
+		binary.BigEndian.PutUint16(idLength, uint16(len(a.credentialID)))
+		data = append(data, make([]byte, 16)...)
+		data = append(data, idLength...)
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey...)
+	}
+	return data
+}
+
+// Creates the credential like navigator.credentials.create() with "none" attestation.
+func (a *softAuthenticator) register(t *testing.T, challenge []byte) RegistrationResponse {
+	t.Helper()
+	attestation, err := cbor.Marshal(attestationObject{
+		Fmt:      "none",
+		AttStmt:  cbor.RawMessage{0xa0},
+		AuthData: a.authenticatorData(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response RegistrationResponse
+	response.ID = EncodeID(a.credentialID)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = EncodeID(a.clientData(t, "webauthn.create", challenge))
+	response.Response.AttestationObject = EncodeID(attestation)
+	return response
+}
+
+// Signs the challenge like navigator.credentials.get().
+func (a *softAuthenticator) assert(t *testing.T, challenge []byte) AssertionResponse {
+	t.Helper()
+	clientDataJSON := a.clientData(t, "webauthn.get", challenge)
+	authData := a.authenticatorData(false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	var response AssertionResponse
+	response.ID = EncodeID(a.credentialID)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = EncodeID(clientDataJSON)
+	response.Response.AuthenticatorData = EncodeID(authData)
+	response.Response.Signature = EncodeID(a.signer(append(authData, clientDataHash[:]...)))
+	return response
+}
+
+func newTestChallenge(t *testing.T) []byte {
+	t.Helper()
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	authenticators := map[string]func(t *testing.T) *softAuthenticator{
+		"ES256": newES256Authenticator,
+		"EdDSA": newEd25519Authenticator,
+	}
+	for name, newAuthenticator := range authenticators {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newAuthenticator(t)
+
+			challenge := newTestChallenge(t)
+			response := authenticator.register(t, challenge)
+			if got, err := ChallengeOf(response.Response.ClientDataJSON); err != nil || string(got) != string(challenge) {
+				t.Fatalf("ChallengeOf returned %x, %v", got, err)
+			}
+			credential, err := VerifyRegistration(testConfig, challenge, response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(credential.ID) != string(authenticator.credentialID) {
+				t.Errorf("got credential ID %x, want %x", credential.ID, authenticator.credentialID)
+			}
+
+			for _, signCount := range []uint32{1, 2, 5} {
+				authenticator.signCount = signCount
+				challenge := newTestChallenge(t)
+				newSignCount, err := VerifyAssertion(testConfig, challenge, authenticator.assert(t, challenge), *credential)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if newSignCount != signCount {
+					t.Errorf("got sign count %d, want %d", newSignCount, signCount)
+				}
+				credential.SignCount = newSignCount
+			}
+		})
+	}
+}
+
+func TestAuthenticatorsWithoutCounter(t *testing.T) {
+	authenticator := newES256Authenticator(t)
+	challenge := newTestChallenge(t)
+	credential, err := VerifyRegistration(testConfig, challenge, authenticator.register(t, challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		challenge := newTestChallenge(t)
+		if _, err := VerifyAssertion(testConfig, challenge, authenticator.assert(t, challenge), *credential); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, a *softAuthenticator, challenge []byte) RegistrationResponse
+		wantErr error
+	}{
+		{"other relying party", func(t *testing.T, a *softAuthenticator, challenge []byte) RegistrationResponse {
+			a.rpID = "evil.example"
+			return a.register(t, challenge)
+		}, ErrInvalidAuthData},
+		{"user not present", func(t *testing.T, a *softAuthenticator, challenge []byte) RegistrationResponse {
+			a.flags = 0
+			return a.register(t, challenge)
+		}, ErrUserNotPresent},
+		{"other challenge", func(t *testing.T, a *softAuthenticator, challenge []byte) RegistrationResponse {
+			return a.register(t, newTestChallenge(t))
+		}, ErrInvalidClientData},
+		{"other origin", func(t *testing.T, a *softAuthenticator, challenge []byte) RegistrationResponse {
+			a.origin = "https://evil.example"
+			return a.register(t, challenge)
+		}, ErrInvalidClientData},
+		{"assertion client data", func(t *testing.T, a *softAuthenticator, challenge []byte) RegistrationResponse {
+			response := a.register(t, challenge)
+			response.Response.ClientDataJSON = EncodeID(a.clientData(t, "webauthn.get", challenge))
+			return response
+		}, ErrInvalidClientData},
+		{"unsupported key", func(t *testing.T, a *softAuthenticator, challenge []byte) RegistrationResponse {
+			a.coseKey, _ = cbor.Marshal(map[int]interface{}{coseLabelKty: coseKtyEC2, coseLabelAlg: -35})
+			return a.register(t, challenge)
+		}, ErrUnsupportedKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge := newTestChallenge(t)
+			_, err := VerifyRegistration(testConfig, challenge, tt.tamper(t, newES256Authenticator(t), challenge))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse
+		wantErr error
+	}{
+		{"other relying party", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			a.rpID = "evil.example"
+			return a.assert(t, challenge)
+		}, ErrInvalidAuthData},
+		{"user not present", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			a.flags = 0
+			return a.assert(t, challenge)
+		}, ErrUserNotPresent},
+		{"other challenge", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			return a.assert(t, newTestChallenge(t))
+		}, ErrInvalidClientData},
+		{"other origin", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			a.origin = "https://evil.example"
+			return a.assert(t, challenge)
+		}, ErrInvalidClientData},
+		{"sign count not increased", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			a.signCount = 7
+			return a.assert(t, challenge)
+		}, ErrSignCountNotIncrease},
+		{"sign count decreased", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			a.signCount = 3
+			return a.assert(t, challenge)
+		}, ErrSignCountNotIncrease},
+		{"tampered signature", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			response := a.assert(t, challenge)
+			signature, _ := DecodeID(response.Response.Signature)
+			signature[len(signature)-1] ^= 0xff
+			response.Response.Signature = EncodeID(signature)
+			return response
+		}, ErrInvalidSignature},
+		{"tampered authenticator data", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			response := a.assert(t, challenge)
+			a.signCount = 100
+			response.Response.AuthenticatorData = EncodeID(a.authenticatorData(false))
+			return response
+		}, ErrInvalidSignature},
+		{"signed by another key", func(t *testing.T, a *softAuthenticator, challenge []byte) AssertionResponse {
+			a.signer = newES256Authenticator(t).signer
+			return a.assert(t, challenge)
+		}, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := newES256Authenticator(t)
+			challenge := newTestChallenge(t)
+			credential, err := VerifyRegistration(testConfig, challenge, authenticator.register(t, challenge))
+			if err != nil {
+				t.Fatal(err)
+			}
+			credential.SignCount = 7
+
+			authenticator.signCount = 8
+			challenge = newTestChallenge(t)
+			_, err = VerifyAssertion(testConfig, challenge, tt.tamper(t, authenticator, challenge), *credential)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
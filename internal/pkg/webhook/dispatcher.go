@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/config"
+	"uiassignment/internal/pkg/events"
+	"uiassignment/internal/pkg/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Headers of delivery requests
+const (
+	HeaderEventID   = "X-Webhook-Event-Id"
+	HeaderEventType = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// sha256= followed by the hex encoded HMAC-SHA256 of "{timestamp}.{body}" keyed with the secret
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+var (
+	maxAttempts    = config.GetInt("WEBHOOK_MAX_ATTEMPTS", 8)
+	retryBaseDelay = config.GetDuration("WEBHOOK_RETRY_BASE_DELAY", 30*time.Second)
+	retryMaxDelay  = config.GetDuration("WEBHOOK_RETRY_MAX_DELAY", time.Hour)
+	requestTimeout = config.GetDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	pollPeriod     = config.GetDuration("WEBHOOK_POLL_PERIOD", time.Second)
+)
+
+// Deliveries attempted per poll
+const batchSize = 10
+
+// Responses are only read up to this size for the delivery log.
+const maxErrorLength = 255
+
+// Dispatcher delivers events to the matching webhook subscriptions. Deliveries
+// are kept in the database, so pending ones survive restarts and are shared by
+// all replicas.
+type Dispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Handle records a pending delivery of the event for every matching
+// subscription. It's meant to be subscribed to the event bus.
+func (d *Dispatcher) Handle(ctx context.Context, event events.Event) error {
+	return d.Enqueue(d.db.WithContext(ctx), event)
+}
+
+// Enqueue records a pending delivery of the event for every matching
+// subscription using the given DB session, e.g. a transaction. Enqueuing the
+// same event again doesn't add deliveries.
+func (d *Dispatcher) Enqueue(db *gorm.DB, event events.Event) error {
+	var subscriptions []models.WebhookSubscriptions
+	if result := db.Find(&subscriptions); result.Error != nil {
+		return result.Error
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		if !subscription.Matches(event.Type) {
+			continue
+		}
+		id, err := auth.GenerateRandomToken(12)
+		if err != nil {
+			return err
+		}
+		delivery := models.WebhookDeliveries{
+			ID:             id,
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        string(payload),
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		if result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery); result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// Run attempts due deliveries until the context is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				attempted, err := d.attemptDue(ctx)
+				if err != nil {
+					log.Println(err.Error())
+				}
+				if attempted < batchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// Attempts a batch of due deliveries. They're claimed first by pushing their
+// next attempt past the request timeout, so replicas don't attempt the same
+// delivery at once and no transaction is held open during requests.
+func (d *Dispatcher) attemptDue(ctx context.Context) (int, error) {
+	var deliveries []models.WebhookDeliveries
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at").Limit(batchSize).Find(&deliveries); result.Error != nil {
+			return result.Error
+		}
+		for i := range deliveries {
+			deliveries[i].NextAttemptAt = now.Add(2 * requestTimeout)
+			if result := tx.Model(&deliveries[i]).Update("next_attempt_at", deliveries[i].NextAttemptAt); result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		var subscription models.WebhookSubscriptions
+		if result := d.db.WithContext(ctx).Where(&models.WebhookSubscriptions{ID: delivery.SubscriptionID}).
+			First(&subscription); result.Error != nil {
+			log.Println(result.Error)
+			continue
+		}
+
+		d.attempt(ctx, subscription, delivery)
+		if result := d.db.WithContext(ctx).Save(delivery); result.Error != nil {
+			log.Println(result.Error)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// Posts the delivery and records the outcome on it.
+func (d *Dispatcher) attempt(ctx context.Context, subscription models.WebhookSubscriptions, delivery *models.WebhookDeliveries) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.UpdatedAt = now
+	delivery.LastStatusCode = nil
+
+	statusCode, err := d.post(ctx, subscription, delivery)
+	if statusCode > 0 {
+		delivery.LastStatusCode = &statusCode
+	}
+	if err == nil {
+		delivery.Status = models.DeliverySucceeded
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > maxErrorLength {
+		delivery.LastError = strings.ToValidUTF8(delivery.LastError[:maxErrorLength], "")
+	}
+	if delivery.Attempts >= maxAttempts {
+		delivery.Status = models.DeliveryDead
+		return
+	}
+	delivery.NextAttemptAt = now.Add(RetryDelay(delivery.Attempts))
+}
+
+func (d *Dispatcher) post(ctx context.Context, subscription models.WebhookSubscriptions, delivery *models.WebhookDeliveries) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "uiassignment-webhook")
+	request.Header.Set(HeaderEventID, delivery.EventID)
+	request.Header.Set(HeaderEventType, delivery.EventType)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, []byte(delivery.Payload)))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorLength))
+		return response.StatusCode, fmt.Errorf("unexpected status %d: %s", response.StatusCode, body)
+	}
+	return response.StatusCode, nil
+}
+
+// Sign computes the signature header value of a delivery.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// RetryDelay is the time to wait after the given number of failed attempts,
+// doubling from WEBHOOK_RETRY_BASE_DELAY up to WEBHOOK_RETRY_MAX_DELAY.
+func RetryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
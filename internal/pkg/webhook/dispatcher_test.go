@@ -0,0 +1,217 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/models"
+)
+
+const testSecret = "whsec-test"
+
+// A request received by the test receiver
+type receivedDelivery struct {
+	header http.Header
+	body   string
+}
+
+// Starts a receiver responding with the given statuses in turn, repeating the
+// last one, and records what it received.
+func startTestReceiver(t *testing.T, responseBody string, statuses ...int) (*httptest.Server, func() []receivedDelivery) {
+	t.Helper()
+	var (
+		mutex    sync.Mutex
+		received []receivedDelivery
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		received = append(received, receivedDelivery{header: r.Header.Clone(), body: string(body)})
+		status := statuses[len(statuses)-1]
+		if len(received) <= len(statuses) {
+			status = statuses[len(received)-1]
+		}
+		mutex.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, responseBody)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []receivedDelivery {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]receivedDelivery(nil), received...)
+	}
+}
+
+func newTestDelivery() *models.WebhookDeliveries {
+	return &models.WebhookDeliveries{
+		ID:             "delivery1",
+		SubscriptionID: "subscription1",
+		EventID:        "event1",
+		EventType:      "user.created",
+		Payload:        `{"id":"event1","type":"user.created","account":"alice"}`,
+		Status:         models.DeliveryPending,
+	}
+}
+
+// Attempts run without the database, which only the polling needs.
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	server, received := startTestReceiver(t, "", http.StatusNoContent)
+	subscription := models.WebhookSubscriptions{ID: "subscription1", URL: server.URL, Secret: testSecret}
+	delivery := newTestDelivery()
+
+	before := time.Now().Unix()
+	NewDispatcher(nil).attempt(context.Background(), subscription, delivery)
+
+	if delivery.Status != models.DeliverySucceeded || delivery.Attempts != 1 || len(delivery.LastError) > 0 {
+		t.Errorf("got delivery %+v", delivery)
+	}
+	if delivery.LastStatusCode == nil || *delivery.LastStatusCode != http.StatusNoContent {
+		t.Errorf("got status code %v", delivery.LastStatusCode)
+	}
+
+	requests := received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests", len(requests))
+	}
+	request := requests[0]
+	if request.body != delivery.Payload {
+		t.Errorf("got body %q", request.body)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":  "application/json",
+		HeaderEventID:   "event1",
+		HeaderEventType: "user.created",
+	} {
+		if got := request.header.Get(header); got != want {
+			t.Errorf("got %s %q, want %q", header, got, want)
+		}
+	}
+
+	// Verify the signature the way a receiver would
+	timestamp := request.header.Get(HeaderTimestamp)
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sentAt < before || sentAt > time.Now().Unix() {
+		t.Errorf("got timestamp %q", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(timestamp + "." + request.body))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := request.header.Get(HeaderSignature); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+	if Sign("other", timestamp, []byte(request.body)) == want {
+		t.Error("signature doesn't depend on the secret")
+	}
+}
+
+func TestDispatcherRetriesUntilMaxAttempts(t *testing.T) {
+	setRetryPolicy(t, 4, time.Minute, 3*time.Minute)
+	server, received := startTestReceiver(t, strings.Repeat("unavailable ", 50), http.StatusServiceUnavailable)
+	subscription := models.WebhookSubscriptions{ID: "subscription1", URL: server.URL, Secret: testSecret}
+	delivery := newTestDelivery()
+	dispatcher := NewDispatcher(nil)
+
+	for attempt, wantDelay := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		before := time.Now()
+		dispatcher.attempt(context.Background(), subscription, delivery)
+		after := time.Now()
+
+		if delivery.Status != models.DeliveryPending || delivery.Attempts != attempt+1 {
+			t.Fatalf("attempt %d got delivery %+v", attempt+1, delivery)
+		}
+		if delivery.LastStatusCode == nil || *delivery.LastStatusCode != http.StatusServiceUnavailable {
+			t.Errorf("attempt %d got status code %v", attempt+1, delivery.LastStatusCode)
+		}
+		if !strings.HasPrefix(delivery.LastError, "unexpected status 503: unavailable") ||
+			len(delivery.LastError) > maxErrorLength {
+			t.Errorf("attempt %d got error %q", attempt+1, delivery.LastError)
+		}
+		if delivery.NextAttemptAt.Before(before.Add(wantDelay)) || delivery.NextAttemptAt.After(after.Add(wantDelay)) {
+			t.Errorf("attempt %d retries in %s, want %s", attempt+1, delivery.NextAttemptAt.Sub(before), wantDelay)
+		}
+	}
+
+	// The last attempt gives up instead of scheduling another one
+	nextAttemptAt := delivery.NextAttemptAt
+	dispatcher.attempt(context.Background(), subscription, delivery)
+	if delivery.Status != models.DeliveryDead || delivery.Attempts != 4 || !delivery.NextAttemptAt.Equal(nextAttemptAt) {
+		t.Errorf("got delivery %+v after the last attempt", delivery)
+	}
+	if requests := received(); len(requests) != 4 {
+		t.Errorf("got %d requests, want 4", len(requests))
+	}
+}
+
+func TestDispatcherRecoversAfterFailures(t *testing.T) {
+	setRetryPolicy(t, 4, time.Minute, time.Hour)
+	server, _ := startTestReceiver(t, "", http.StatusInternalServerError, http.StatusFound, http.StatusOK)
+	subscription := models.WebhookSubscriptions{ID: "subscription1", URL: server.URL, Secret: testSecret}
+	delivery := newTestDelivery()
+	dispatcher := NewDispatcher(nil)
+
+	// Redirects aren't 2xx either, the receiver has to be fixed instead
+	for _, wantStatus := range []int{http.StatusInternalServerError, http.StatusFound} {
+		dispatcher.attempt(context.Background(), subscription, delivery)
+		if delivery.Status != models.DeliveryPending || *delivery.LastStatusCode != wantStatus {
+			t.Fatalf("got delivery %+v, want pending after %d", delivery, wantStatus)
+		}
+	}
+	dispatcher.attempt(context.Background(), subscription, delivery)
+	if delivery.Status != models.DeliverySucceeded || delivery.Attempts != 3 || len(delivery.LastError) > 0 {
+		t.Errorf("got delivery %+v", delivery)
+	}
+}
+
+func TestDispatcherRetriesUnreachableReceivers(t *testing.T) {
+	setRetryPolicy(t, 2, time.Minute, time.Hour)
+	server, _ := startTestReceiver(t, "", http.StatusOK)
+	server.Close()
+	subscription := models.WebhookSubscriptions{ID: "subscription1", URL: server.URL, Secret: testSecret}
+	delivery := newTestDelivery()
+	dispatcher := NewDispatcher(nil)
+
+	dispatcher.attempt(context.Background(), subscription, delivery)
+	if delivery.Status != models.DeliveryPending || delivery.LastStatusCode != nil || len(delivery.LastError) == 0 {
+		t.Errorf("got delivery %+v", delivery)
+	}
+	dispatcher.attempt(context.Background(), subscription, delivery)
+	if delivery.Status != models.DeliveryDead {
+		t.Errorf("got delivery %+v after the last attempt", delivery)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	setRetryPolicy(t, 8, 30*time.Second, time.Hour)
+	for attempts, want := range map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		3: 2 * time.Minute,
+		7: 32 * time.Minute,
+		8: time.Hour,
+		9: time.Hour,
+	} {
+		if got := RetryDelay(attempts); got != want {
+			t.Errorf("RetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// Overrides the WEBHOOK_* retry settings for the test.
+func setRetryPolicy(t *testing.T, attempts int, baseDelay time.Duration, maxDelay time.Duration) {
+	previousAttempts, previousBaseDelay, previousMaxDelay := maxAttempts, retryBaseDelay, retryMaxDelay
+	maxAttempts, retryBaseDelay, retryMaxDelay = attempts, baseDelay, maxDelay
+	t.Cleanup(func() {
+		maxAttempts, retryBaseDelay, retryMaxDelay = previousAttempts, previousBaseDelay, previousMaxDelay
+	})
+}
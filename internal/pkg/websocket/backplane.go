@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"sync"
+	"uiassignment/internal/pkg/config"
+
+	"gorm.io/gorm"
+)
+
+var (
+	backplaneType    = config.Get("WEBSOCKET_BACKPLANE", "local")
+	backplaneChannel = config.Get("WEBSOCKET_BACKPLANE_CHANNEL", "websocket")
+)
+
+// Backplane relays published messages between the hubs of all replicas of the
+// service. A hub delivers messages to its clients only once they come back
+// from the backplane, so every replica sees them in the same order.
+type Backplane interface {
+	// Sends the encoded message to every subscribed hub, including this one.
+	Publish(ctx context.Context, data []byte) error
+	// Passes messages published by any hub to handle until the context is
+	// done. handle must not block.
+	Subscribe(ctx context.Context, handle func(data []byte)) error
+}
+
+// Creates the backplane selected by the WEBSOCKET_BACKPLANE env variable(local
+// or postgres). dsn is the connection string for listening to Postgres.
+func NewBackplane(db *gorm.DB, dsn string) Backplane {
+	switch backplaneType {
+	case "postgres":
+		return NewPostgresBackplane(db, dsn, backplaneChannel)
+	case "local":
+	default:
+		log.Printf("Unknown websocket backplane %q, falling back to local", backplaneType)
+	}
+	return NewLocalBackplane()
+}
+
+// LocalBackplane relays messages between hubs of the same process, which is
+// all a single replica needs.
+type LocalBackplane struct {
+	mu       sync.RWMutex
+	handlers map[*func([]byte)]bool
+}
+
+func NewLocalBackplane() *LocalBackplane {
+	return &LocalBackplane{handlers: make(map[*func([]byte)]bool)}
+}
+
+func (b *LocalBackplane) Publish(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for handle := range b.handlers {
+		(*handle)(data)
+	}
+	return nil
+}
+
+func (b *LocalBackplane) Subscribe(ctx context.Context, handle func(data []byte)) error {
+	b.mu.Lock()
+	b.handlers[&handle] = true
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.handlers, &handle)
+	b.mu.Unlock()
+	return ctx.Err()
+}
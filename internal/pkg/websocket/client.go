@@ -5,10 +5,17 @@
 package websocket
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/config"
 
 	"github.com/gorilla/websocket"
 )
@@ -22,30 +29,124 @@ const (
 
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
 )
 
 var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
+	// Maximum message size allowed from peer.
+	maxMessageSize = int64(config.GetInt("WEBSOCKET_MAX_MESSAGE_SIZE", 512))
+
+	// Number of messages queued for a client before the slow consumer policy
+	// applies.
+	sendBufferSize = config.GetInt("WEBSOCKET_SEND_BUFFER", 256)
+
+	// Chat and other messages a client may send per second on average, and at
+	// once. Unlimited if the rate is 0.
+	messageRateLimit = config.GetInt("WEBSOCKET_RATE_LIMIT", 5)
+	messageRateBurst = config.GetInt("WEBSOCKET_RATE_BURST", 10)
+
+	// Origins allowed to connect besides the origin of the service itself.
+	allowedOrigins = parseOrigins(config.Get("WEBSOCKET_ALLOWED_ORIGINS", ""))
 )
 
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	ReadBufferSize:  config.GetInt("WEBSOCKET_READ_BUFFER_SIZE", 1024),
+	WriteBufferSize: config.GetInt("WEBSOCKET_WRITE_BUFFER_SIZE", 1024),
+	// Negotiates permessage-deflate with clients supporting it
+	EnableCompression: config.Get("WEBSOCKET_COMPRESSION", "false") == "true",
+	CheckOrigin:       checkOrigin,
 }
 
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
+	// Unix time in nanoseconds when the client connected or last sent a
+	// message. First for 64-bit alignment of atomic access.
+	lastActive int64
+
+	// Unix time in nanoseconds until which the client can't send chat
+	// messages, set by the hub goroutine.
+	mutedUntil int64
+
 	hub *Hub
 
-	// The websocket connection.
+	// The websocket connection, nil for event streams.
 	conn *websocket.Conn
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// Account the client authenticated as.
+	account string
+
+	// Access token the client authenticated with, re-checked periodically.
+	accessToken string
+
+	// Time when the access token expires, zero if it doesn't.
+	expiresAt time.Time
+
+	// Topics the client subscribed to, owned by the hub goroutine.
+	topics map[string]bool
+
+	// Topics of the rooms the client joined, owned by the readPump goroutine.
+	rooms map[string]bool
+
+	// Why the hub disconnected the client, set before send is closed. Nil if
+	// the client left or the hub stopped.
+	disconnection *disconnection
+
+	// Messages the client may still send at once and when they were last
+	// refilled, owned by the readPump goroutine.
+	rateTokens     float64
+	rateRefilledAt time.Time
+}
+
+// The time when the client connected or last sent a message.
+func (c *Client) lastActiveAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActive))
+}
+
+// Account the client authenticated as.
+func (c *Client) Account() string {
+	return c.account
+}
+
+// Reports whether the client's access token is still valid, i.e. hasn't
+// expired or been revoked since the connection was established. Tokens which
+// can't be checked for now, e.g. while the DB is unavailable, are taken as
+// valid until the next check rather than disconnecting every client.
+func (c *Client) isAuthenticated() bool {
+	if !c.expiresAt.IsZero() && !time.Now().Before(c.expiresAt) {
+		return false
+	}
+	if _, err := c.hub.validateToken(c.accessToken); err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return false
+		}
+		log.Printf("Keeping the connection of %s, its access token couldn't be checked: %s", c.account, err.Error())
+	}
+	return true
+}
+
+// Reports whether the client is muted at the given time.
+func (c *Client) isMuted(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&c.mutedUntil)
+}
+
+// Takes a token from the client's bucket, refilled with WEBSOCKET_RATE_LIMIT
+// tokens per second up to WEBSOCKET_RATE_BURST. Reports false if it's empty.
+func (c *Client) allowMessage(now time.Time) bool {
+	if messageRateLimit <= 0 {
+		return true
+	}
+	c.rateTokens += now.Sub(c.rateRefilledAt).Seconds() * float64(messageRateLimit)
+	if c.rateTokens > float64(messageRateBurst) {
+		c.rateTokens = float64(messageRateBurst)
+	}
+	c.rateRefilledAt = now
+	if c.rateTokens < 1 {
+		return false
+	}
+	c.rateTokens--
+	return true
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -55,22 +156,137 @@ type Client struct {
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		c.conn.Close()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, message, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
-		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		c.hub.broadcast <- message
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+		if !c.forward(data) {
+			break
+		}
+	}
+}
+
+// Hands a message read from the connection over to the hub. Every message is
+// answered by an ack or an error. Returns false if the hub is closed.
+func (c *Client) forward(data []byte) bool {
+	now := time.Now()
+	message, errorMessage := parseClientMessage(data)
+	if !c.allowMessage(now) {
+		return c.reply(newError(message.ID, ErrorCodeRateLimited, "too many messages, slow down"))
+	}
+	if errorMessage != nil {
+		return c.reply(*errorMessage)
+	}
+
+	switch message.Type {
+	case TypeJoin:
+		ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+		defer cancel()
+		exists, err := c.hub.rooms.RoomExists(ctx, roomOf(message.Topic))
+		if err != nil {
+			log.Println(err.Error())
+			return c.reply(newError(message.ID, ErrorCodeInternal, "room can't be joined"))
+		}
+		if !exists {
+			return c.reply(newError(message.ID, ErrorCodeRoomNotFound, ErrRoomNotFound.Error()))
+		}
+		c.rooms[message.Topic] = true
+		return c.subscribe(c.hub.subscribe, message)
+	case TypeLeave:
+		delete(c.rooms, message.Topic)
+		return c.subscribe(c.hub.unsubscribe, message)
+	case TypeSubscribe:
+		return c.subscribe(c.hub.subscribe, message)
+	case TypeUnsubscribe:
+		return c.subscribe(c.hub.unsubscribe, message)
+	}
+
+	if c.isMuted(now) {
+		return c.reply(newError(message.ID, ErrorCodeMuted, "muted by an admin"))
+	}
+	var payload ChatPayload
+	// Chat payloads were validated by parseClientMessage
+	json.Unmarshal(message.Payload, &payload)
+	text, err := c.hub.moderator.Moderate(c.account, payload.Text)
+	if err != nil {
+		if errors.Is(err, ErrMessageRejected) {
+			return c.reply(newError(message.ID, ErrorCodeMessageRejected, err.Error()))
+		}
+		log.Println(err.Error())
+		return c.reply(newError(message.ID, ErrorCodeInternal, "message can't be moderated"))
+	}
+
+	chat := newMessage(TypeChat, newMessageID(), ChatPayload{Text: text})
+	chat.From = c.account
+	chat.Topic = message.Topic
+
+	// Messages of rooms are kept for the history
+	if room := roomOf(chat.Topic); len(room) > 0 {
+		if !c.rooms[chat.Topic] {
+			return c.reply(newError(message.ID, ErrorCodeNotJoined, "room must be joined first"))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+		defer cancel()
+		if err := c.hub.rooms.SaveMessage(ctx, room, chat); err != nil {
+			log.Println(err.Error())
+			if errors.Is(err, ErrRoomNotFound) {
+				return c.reply(newError(message.ID, ErrorCodeRoomNotFound, err.Error()))
+			}
+			return c.reply(newError(message.ID, ErrorCodeInternal, "message can't be stored"))
+		}
+	}
+
+	bytes, err := json.Marshal(chat)
+	if err != nil {
+		log.Println(err.Error())
+		return c.reply(newError(message.ID, ErrorCodeInvalidMessage, "message can't be encoded"))
+	}
+	select {
+	case c.hub.outbound <- delivery{topic: chat.Topic, id: chat.ID, message: bytes}:
+	case <-c.hub.done:
+		return false
+	}
+	return c.reply(newAck(message.ID))
+}
+
+// Hands a subscribe or unsubscribe request over to the hub, which answers it.
+// Returns false if the hub is closed.
+func (c *Client) subscribe(requests chan subscription, message Message) bool {
+	select {
+	case requests <- subscription{client: c, topic: message.Topic, id: message.ID}:
+		return true
+	case <-c.hub.done:
+		return false
+	}
+}
+
+// Sends the message back to the client through the hub. Returns false if the
+// hub is closed.
+func (c *Client) reply(message Message) bool {
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		log.Println(err.Error())
+		return true
+	}
+	select {
+	case c.hub.inbound <- delivery{client: c, message: bytes}:
+		return true
+	case <-c.hub.done:
+		return false
 	}
 }
 
@@ -91,28 +307,25 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMessage := []byte{}
+				if c.disconnection != nil {
+					closeMessage = websocket.FormatCloseMessage(c.disconnection.closeCode, c.disconnection.reason)
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// Every message is a JSON envelope of its own frame.
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !c.isAuthenticated() {
+				closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "access token expired or revoked")
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
+				return
+			}
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -120,18 +333,89 @@ func (c *Client) writePump() {
 	}
 }
 
-// serveWs handles websocket requests from the peer.
+// serveWs handles websocket requests from the peer. The request must have
+// passed middlewares.AccessTokenCheckMW, which puts the token and its claims
+// in the request context. The client is bound to the token owner and
+// disconnected once the token expires or is revoked.
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	accessToken, hasToken := r.Context().Value("accessToken").(string)
+	if !ok || !hasToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Mutes applied before the hub started are only known to the store
+	mutedUntil, err := hub.mutedUntil(r.Context(), claims.Account)
+	if err != nil {
+		log.Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
-	client.hub.register <- client
+	client := &Client{
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan []byte, sendBufferSize),
+		account:     claims.Account,
+		accessToken: accessToken,
+		topics:      make(map[string]bool),
+		rooms:       make(map[string]bool),
+		lastActive:  time.Now().UnixNano(),
+
+		rateTokens:     float64(messageRateBurst),
+		rateRefilledAt: time.Now(),
+	}
+	if claims.ExpiresAt > 0 {
+		client.expiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	if !mutedUntil.IsZero() {
+		client.mutedUntil = mutedUntil.UnixNano()
+	}
+	select {
+	case hub.register <- client:
+	case <-hub.done:
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		conn.Close()
+		return
+	}
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
 	go client.writePump()
 	go client.readPump()
 }
+
+// Allows requests without an Origin header, which don't come from browsers,
+// requests from the origin of the service and from WEBSOCKET_ALLOWED_ORIGINS.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	if allowedOrigins["*"] || allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(originURL.Host, r.Host)
+}
+
+// Parses a comma separated list of origins, e.g.
+// https://example.com,https://app.example.com, or * for any origin.
+func parseOrigins(origins string) map[string]bool {
+	parsed := make(map[string]bool)
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); len(origin) > 0 {
+			parsed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+	return parsed
+}
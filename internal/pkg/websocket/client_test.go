@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseOrigins(t *testing.T) {
+	origins := parseOrigins(" https://Example.com/, https://app.example.com,,")
+	if len(origins) != 2 || !origins["https://example.com"] || !origins["https://app.example.com"] {
+		t.Errorf("got origins %v", origins)
+	}
+	if origins := parseOrigins(""); len(origins) > 0 {
+		t.Errorf("got origins %v", origins)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	previous := allowedOrigins
+	t.Cleanup(func() {
+		allowedOrigins = previous
+	})
+
+	for name, tt := range map[string]struct {
+		allowed string
+		origin  string
+		want    bool
+	}{
+		"no origin":          {"", "", true},
+		"same origin":        {"", "https://chat.example.com", true},
+		"same origin case":   {"", "https://CHAT.example.com", true},
+		"other origin":       {"", "https://evil.example.com", false},
+		"allowed origin":     {"https://app.example.com", "https://App.example.com", true},
+		"not allowed origin": {"https://app.example.com", "https://evil.example.com", false},
+		"any origin":         {"*", "https://evil.example.com", true},
+		"invalid origin":     {"", "://chat.example.com", false},
+	} {
+		allowedOrigins = parseOrigins(tt.allowed)
+		r := httptest.NewRequest(http.MethodGet, "http://chat.example.com/ws", nil)
+		if len(tt.origin) > 0 {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkOrigin(r); got != tt.want {
+			t.Errorf("%s got %t, want %t", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Controls relayed through the backplane, applied to the clients of an
+// account by every hub.
+const (
+	controlMute   = "mute"
+	controlUnmute = "unmute"
+	controlKick   = "kick"
+	// Presence of accounts on the reporting replica
+	controlPresence = "presence"
+)
+
+// Events of moderation
+const (
+	EventChatMuted   = "chat.muted"
+	EventChatUnmuted = "chat.unmuted"
+)
+
+// Why the hub disconnected a client
+type disconnection struct {
+	// Close code for websocket connections
+	closeCode int
+	// Error code for event streams
+	errorCode string
+	reason    string
+}
+
+var (
+	slowConsumerDisconnection = &disconnection{websocket.CloseTryAgainLater, ErrorCodeSlowConsumer, "too slow to keep up with messages"}
+	kickedDisconnection       = &disconnection{websocket.ClosePolicyViolation, ErrorCodeKicked, "kicked by an admin"}
+)
+
+// Mute stops the account from sending chat messages until the given time, on
+// every replica and for connections made later. It can still receive messages.
+func (h *Hub) Mute(ctx context.Context, account string, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if h.muteStore != nil {
+		if err := h.muteStore.SaveMute(ctx, account, until); err != nil {
+			return err
+		}
+	}
+	return h.queue(delivery{account: account, control: controlMute, until: until})
+}
+
+// Unmute lets a muted account send chat messages again.
+func (h *Hub) Unmute(ctx context.Context, account string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if h.muteStore != nil {
+		if err := h.muteStore.DeleteMute(ctx, account); err != nil {
+			return err
+		}
+	}
+	return h.queue(delivery{account: account, control: controlUnmute})
+}
+
+// Kick disconnects every connection of the account on every replica. It can
+// connect again unless its sessions are revoked as well.
+func (h *Hub) Kick(ctx context.Context, account string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return h.queue(delivery{account: account, control: controlKick})
+}
+
+// Applies a control to the clients of its account, or the presence reported
+// by a replica, run by the hub goroutine.
+func (h *Hub) applyControl(delivery delivery) {
+	switch delivery.control {
+	case controlMute:
+		h.mutes[delivery.account] = delivery.until
+		text := fmt.Sprintf("%s is muted until %s", delivery.account, delivery.until.UTC().Format(time.RFC3339))
+		for client := range h.accounts[delivery.account] {
+			atomic.StoreInt64(&client.mutedUntil, delivery.until.UnixNano())
+			h.sendMessage(client, NewEvent(EventChatMuted, delivery.account, text))
+		}
+	case controlUnmute:
+		delete(h.mutes, delivery.account)
+		text := fmt.Sprintf("%s is no longer muted", delivery.account)
+		for client := range h.accounts[delivery.account] {
+			atomic.StoreInt64(&client.mutedUntil, 0)
+			h.sendMessage(client, NewEvent(EventChatUnmuted, delivery.account, text))
+		}
+	case controlKick:
+		for client := range h.accounts[delivery.account] {
+			client.disconnection = kickedDisconnection
+			h.remove(client)
+		}
+	case controlPresence:
+		h.applyRemotePresence(delivery.replica, delivery.presence, time.Now())
+	}
+}
+
+// Loads the end of the account's mute for a new connection, zero if it isn't muted.
+func (h *Hub) mutedUntil(ctx context.Context, account string) (time.Time, error) {
+	if h.muteStore == nil {
+		return time.Time{}, nil
+	}
+	return h.muteStore.MutedUntil(ctx, account)
+}
+
+// Forgets mutes which are over, run by the hub goroutine.
+func (h *Hub) expireMutes(now time.Time) {
+	for account, until := range h.mutes {
+		if !until.After(now) {
+			delete(h.mutes, account)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"uiassignment/internal/pkg/events"
+)
+
+// Notification texts of domain events, by event type
+var domainEventTexts = map[string]string{
+	events.UserCreated: "Account %s was created",
+	events.UserUpdated: "Account details of %s were updated",
+	events.UserDeleted: "Account %s was deleted",
+}
+
+// HandleEvent notifies every connection of the account a domain event is
+// about. It's meant to be subscribed to the event bus. Events without a text,
+// e.g. failed logins which handlers already notify about as login.failed, are
+// skipped.
+func (h *Hub) HandleEvent(ctx context.Context, event events.Event) error {
+	text, ok := domainEventTexts[event.Type]
+	if !ok {
+		return nil
+	}
+	return h.SendToUser(ctx, event.Account, NewEvent(event.Type, event.Account, fmt.Sprintf(text, event.Account)))
+}
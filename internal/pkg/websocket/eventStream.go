@@ -0,0 +1,211 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/config"
+)
+
+var (
+	// Number of the latest published messages kept for resuming event streams.
+	replayBufferSize = config.GetInt("SSE_REPLAY_BUFFER", 1000)
+	// Send heartbeat comments to event streams with this period, which also
+	// re-checks their access token.
+	heartbeatPeriod = config.GetDuration("SSE_HEARTBEAT_PERIOD", 15*time.Second)
+)
+
+// Reconnection delay suggested to event stream clients, in milliseconds.
+const streamRetry = 3000
+
+// Request to register an event stream, resuming after the message with
+// lastEventID if set.
+type streamRegistration struct {
+	client      *Client
+	lastEventID string
+}
+
+// Ring buffer of the latest messages received from the backplane, owned by
+// the hub goroutine. Every replica receives them in the same order, so streams
+// can resume on any replica which still has the last event.
+type replayBuffer struct {
+	deliveries []delivery
+	// Index of the oldest delivery once the buffer is full
+	start int
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	if size < 0 {
+		size = 0
+	}
+	return &replayBuffer{deliveries: make([]delivery, 0, size)}
+}
+
+func (b *replayBuffer) add(delivery delivery) {
+	if cap(b.deliveries) == 0 || len(delivery.id) == 0 {
+		return
+	}
+	if len(b.deliveries) < cap(b.deliveries) {
+		b.deliveries = append(b.deliveries, delivery)
+		return
+	}
+	b.deliveries[b.start] = delivery
+	b.start = (b.start + 1) % len(b.deliveries)
+}
+
+// Returns the deliveries after the one with the ID, oldest first, or false if
+// it isn't buffered anymore.
+func (b *replayBuffer) since(id string) ([]delivery, bool) {
+	for i := range b.deliveries {
+		if b.deliveries[(b.start+i)%len(b.deliveries)].id != id {
+			continue
+		}
+		var deliveries []delivery
+		for j := i + 1; j < len(b.deliveries); j++ {
+			deliveries = append(deliveries, b.deliveries[(b.start+j)%len(b.deliveries)])
+		}
+		return deliveries, true
+	}
+	return nil, false
+}
+
+// Sends the buffered messages for the client published after lastEventID, or
+// an error if they're not available anymore.
+func (h *Hub) replayTo(client *Client, lastEventID string) {
+	deliveries, ok := h.replay.since(lastEventID)
+	if !ok {
+		h.sendMessage(client, newError("", ErrorCodeReplayUnavailable, "messages after the last event ID are no longer available"))
+		return
+	}
+	for _, delivery := range deliveries {
+		if _, ok := h.clients[client]; !ok {
+			return
+		}
+		if delivery.isFor(client) {
+			h.send(client, delivery.message)
+		}
+	}
+}
+
+// IsValidTopic reports whether clients can subscribe to the topic. Topics of
+// rooms must be joined instead.
+func IsValidTopic(topic string) bool {
+	return topicPattern.MatchString(topic) && len(roomOf(topic)) == 0
+}
+
+// ServeEvents streams the messages for the token owner as Server-Sent Events:
+// broadcasts, messages sent to the account and messages published to the
+// topics. The request must have passed middlewares.AccessTokenCheckMW, which
+// puts the token and its claims in the request context. Streams resume after
+// the Last-Event-ID header from the replay buffer, and end once the token
+// expires or is revoked.
+func ServeEvents(hub *Hub, w http.ResponseWriter, r *http.Request, topics []string) {
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	accessToken, hasToken := r.Context().Value("accessToken").(string)
+	if !ok || !hasToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println("response writer doesn't support streaming")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	client := &Client{
+		hub: hub,
+		// Room for a full replay on top of the usual backlog
+		send:        make(chan []byte, sendBufferSize+replayBufferSize),
+		account:     claims.Account,
+		accessToken: accessToken,
+		topics:      make(map[string]bool),
+		lastActive:  time.Now().UnixNano(),
+	}
+	if claims.ExpiresAt > 0 {
+		client.expiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	for _, topic := range topics {
+		client.topics[topic] = true
+	}
+
+	select {
+	case hub.registerStream <- streamRegistration{client: client, lastEventID: r.Header.Get("Last-Event-ID")}:
+	case <-hub.done:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+	defer func() {
+		select {
+		case hub.unregister <- client:
+		case <-hub.done:
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep reverse proxies like nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	flusher.Flush()
+
+	ticker := time.NewTicker(heartbeatPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				// The hub closed the channel.
+				if client.disconnection != nil {
+					errorMessage, _ := json.Marshal(newError("", client.disconnection.errorCode, client.disconnection.reason))
+					writeEvent(w, errorMessage)
+					flusher.Flush()
+				}
+				return
+			}
+			if err := writeEvent(w, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if !client.isAuthenticated() {
+				errorMessage, _ := json.Marshal(newError("", ErrorCodeUnauthorized, "access token expired or revoked"))
+				writeEvent(w, errorMessage)
+				flusher.Flush()
+				return
+			}
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// Writes the encoded message as an event named after its type, with its ID
+// for resuming.
+func writeEvent(w http.ResponseWriter, message []byte) error {
+	var envelope struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+	if len(envelope.ID) > 0 {
+		if _, err := fmt.Fprintf(w, "id: %s\n", envelope.ID); err != nil {
+			return err
+		}
+	}
+	// Encoded JSON has no newlines, so it's always a single data line
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", envelope.Type, message)
+	return err
+}
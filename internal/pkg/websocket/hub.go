@@ -4,61 +4,493 @@
 
 package websocket
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"sync/atomic"
+	"time"
+	"uiassignment/internal/pkg/auth"
+	"uiassignment/internal/pkg/config"
+)
+
+const (
+	// Maximum number of topics a client can subscribe to.
+	maxSubscriptions = 32
+
+	// Maximum number of published messages waiting for the backplane, and of
+	// messages from the backplane waiting for the hub.
+	publishQueueSize = 1024
+)
+
+// Slow consumer policies, applied when the send buffer of a client is full
+const (
+	// Drop the oldest queued message to make room for the new one
+	PolicyDropOldest = "drop_oldest"
+	// Drop the new message
+	PolicyDropNewest = "drop_newest"
+	// Disconnect the client with a close reason
+	PolicyDisconnect = "disconnect"
+)
+
+// Policy selected by the WEBSOCKET_SLOW_CONSUMER_POLICY env variable.
+var slowConsumerPolicy = parseSlowConsumerPolicy(config.Get("WEBSOCKET_SLOW_CONSUMER_POLICY", PolicyDisconnect))
+
+var (
+	ErrHubBusy   = errors.New("websocket hub is busy, message dropped")
+	ErrHubClosed = errors.New("websocket hub is closed")
+)
+
+// Topic names clients can subscribe to.
+var topicPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]{0,63}$`)
+
+// TokenValidator validates the access token of a client, returning its claims.
+// It returns auth.ErrInvalidAccessToken if the token is invalid, expired or
+// revoked, and other errors if it couldn't be checked.
+type TokenValidator func(accessToken string) (*auth.Claims, error)
+
+// Encoded message for one client, the clients of one account, one topic or,
+// if none is set, every client.
+type delivery struct {
+	client  *Client
+	account string
+	topic   string
+	// ID of the message, for resuming event streams
+	id      string
+	message []byte
+	// Control to apply to the clients of the account instead of a message
+	control string
+	// End of a mute
+	until time.Time
+	// Reporting replica and its presence of accounts
+	replica  string
+	presence []Presence
+}
+
+// Reports whether the client is a recipient of the delivery.
+func (d delivery) isFor(client *Client) bool {
+	switch {
+	case d.client != nil:
+		return d.client == client
+	case len(d.account) > 0:
+		return d.account == client.account
+	case len(d.topic) > 0:
+		return client.topics[d.topic]
+	}
+	return true
+}
+
+// Message on the backplane, for the clients of one account, one topic or, if
+// neither is set, every client.
+type backplaneMessage struct {
+	Account  string          `json:"account,omitempty"`
+	Topic    string          `json:"topic,omitempty"`
+	ID       string          `json:"id,omitempty"`
+	Message  json.RawMessage `json:"message,omitempty"`
+	Control  string          `json:"control,omitempty"`
+	Until    *time.Time      `json:"until,omitempty"`
+	Replica  string          `json:"replica,omitempty"`
+	Presence []Presence      `json:"presence,omitempty"`
+}
+
+// Request of a client to subscribe to or unsubscribe from a topic.
+type subscription struct {
+	client *Client
+	topic  string
+	// ID of the request message, for the ack
+	id string
+}
+
 // Hub maintains the set of active clients and broadcasts messages to the
-// clients.
+// clients. The clients and indexes are only accessed by the goroutine running
+// Run, everything else talks to it through channels.
 type Hub struct {
+	// Identifies this hub among the hubs of all replicas.
+	replica string
+
+	// Re-checks access tokens of connected clients.
+	validateToken TokenValidator
+
+	// Relays published messages between the hubs of all replicas.
+	backplane Backplane
+
+	// Persists chat rooms and their messages.
+	rooms RoomStore
+
+	// Persists mutes of accounts, they're only kept in memory without it.
+	muteStore MuteStore
+
+	// Checks chat messages before they're sent.
+	moderator Moderator
+
 	// Registered clients.
 	clients map[*Client]bool
 
-	// Inbound messages from the clients.
-	broadcast chan []byte
+	// Registered clients by the account they authenticated as.
+	accounts map[string]map[*Client]bool
+
+	// Registered clients by the topics they subscribed to.
+	topics map[string]map[*Client]bool
+
+	// Presence of the accounts with registered clients.
+	presence map[string]*Presence
+
+	// Presence of accounts reported by the other replicas, by account and replica.
+	remotePresence map[string]map[string]replicaPresence
+
+	// Ends of the mutes of accounts.
+	mutes map[string]time.Time
+
+	// Requests for a snapshot of the presence.
+	presenceRequests chan chan []Presence
+
+	// Latest messages received from the backplane, for resuming event streams.
+	replay *replayBuffer
+
+	// Replies to messages of the clients.
+	inbound chan delivery
+
+	// Messages of the application and the clients waiting for the backplane.
+	outbound chan delivery
+
+	// Messages received from the backplane.
+	deliver chan delivery
+
+	// Subscribe requests from the clients.
+	subscribe chan subscription
+
+	// Unsubscribe requests from the clients.
+	unsubscribe chan subscription
 
 	// Register requests from the clients.
 	register chan *Client
 
+	// Register requests from event streams.
+	registerStream chan streamRegistration
+
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Closed once Run returns.
+	done chan struct{}
 }
 
-func NewHub() *Hub {
+func NewHub(validateToken TokenValidator, backplane Backplane, rooms RoomStore, mutes MuteStore, moderator Moderator) *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+		replica:       newMessageID(),
+		validateToken: validateToken,
+		backplane:     backplane,
+		rooms:         rooms,
+		muteStore:     mutes,
+		moderator:     moderator,
+		inbound:       make(chan delivery),
+		outbound:      make(chan delivery, publishQueueSize),
+		deliver:       make(chan delivery, publishQueueSize),
+		subscribe:     make(chan subscription),
+		unsubscribe:   make(chan subscription),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		done:          make(chan struct{}),
+		clients:       make(map[*Client]bool),
+		accounts:      make(map[string]map[*Client]bool),
+		topics:        make(map[string]map[*Client]bool),
+		presence:      make(map[string]*Presence),
+		mutes:         make(map[string]time.Time),
+		replay:        newReplayBuffer(replayBufferSize),
+
+		remotePresence:   make(map[string]map[string]replicaPresence),
+		presenceRequests: make(chan chan []Presence),
+		registerStream:   make(chan streamRegistration),
 	}
 }
 
-func (h *Hub) Run() {
+// Run serves the hub until the context is done, then disconnects all clients.
+func (h *Hub) Run(ctx context.Context) {
+	go h.relay(ctx)
+	go func() {
+		if err := h.backplane.Subscribe(ctx, h.receive); err != nil && ctx.Err() == nil {
+			log.Println(err.Error())
+		}
+	}()
+
+	presenceTicker := time.NewTicker(presenceCheckPeriod)
+	defer func() {
+		presenceTicker.Stop()
+		for client := range h.clients {
+			close(client.send)
+		}
+		close(h.done)
+	}()
+
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case client := <-h.register:
-			h.clients[client] = true
+			h.add(client)
+		case registration := <-h.registerStream:
+			h.add(registration.client)
+			if len(registration.lastEventID) > 0 {
+				h.replayTo(registration.client, registration.lastEventID)
+			}
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.remove(client)
+			}
+		case subscription := <-h.subscribe:
+			client := subscription.client
+			if _, ok := h.clients[client]; !ok {
+				continue
+			}
+			if len(client.topics) >= maxSubscriptions && !client.topics[subscription.topic] {
+				h.sendMessage(client, newError(subscription.id, ErrorCodeTooManySubscriptions, "at most 32 topics can be subscribed to"))
+				continue
+			}
+			client.topics[subscription.topic] = true
+			addToIndex(h.topics, subscription.topic, client)
+			h.sendMessage(client, newAck(subscription.id))
+		case subscription := <-h.unsubscribe:
+			if _, ok := h.clients[subscription.client]; ok {
+				delete(subscription.client.topics, subscription.topic)
+				removeFromIndex(h.topics, subscription.topic, subscription.client)
+				h.sendMessage(subscription.client, newAck(subscription.id))
 			}
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+		case delivery := <-h.inbound:
+			h.dispatch(delivery)
+		case delivery := <-h.deliver:
+			h.replay.add(delivery)
+			h.dispatch(delivery)
+		case reply := <-h.presenceRequests:
+			reply <- h.listPresence()
+		case now := <-presenceTicker.C:
+			for account := range h.presence {
+				h.updatePresence(account, now)
 			}
+			h.reportAllPresence()
+			h.expirePresence(now)
+			h.expireMutes(now)
 		}
 	}
 }
 
-func (h *Hub) BroadcastMessage(message string) {
-	for client := range h.clients {
+// Publishes the outbound messages through the backplane, one at a time to
+// keep them in order.
+func (h *Hub) relay(ctx context.Context) {
+	for {
 		select {
-		case client.send <- []byte(message):
+		case <-ctx.Done():
+			return
+		case delivery := <-h.outbound:
+			message := backplaneMessage{
+				Account:  delivery.account,
+				Topic:    delivery.topic,
+				ID:       delivery.id,
+				Message:  delivery.message,
+				Control:  delivery.control,
+				Replica:  delivery.replica,
+				Presence: delivery.presence,
+			}
+			if !delivery.until.IsZero() {
+				message.Until = &delivery.until
+			}
+			data, err := json.Marshal(message)
+			if err == nil {
+				err = h.backplane.Publish(ctx, data)
+			}
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}
+}
+
+// Queues a message from the backplane for the hub, dropping it if the hub
+// can't keep up.
+func (h *Hub) receive(data []byte) {
+	var message backplaneMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	delivery := delivery{
+		account:  message.Account,
+		topic:    message.Topic,
+		id:       message.ID,
+		message:  message.Message,
+		control:  message.Control,
+		replica:  message.Replica,
+		presence: message.Presence,
+	}
+	if message.Until != nil {
+		delivery.until = *message.Until
+	}
+	select {
+	case h.deliver <- delivery:
+	default:
+		metrics.Add(metricBackplaneDropped, 1)
+		log.Println(ErrHubBusy.Error())
+	}
+}
+
+// Sends the delivery to its recipients.
+func (h *Hub) dispatch(delivery delivery) {
+	if len(delivery.control) > 0 {
+		h.applyControl(delivery)
+		return
+	}
+	if delivery.client != nil {
+		if _, ok := h.clients[delivery.client]; ok {
+			h.send(delivery.client, delivery.message)
+		}
+		return
+	}
+
+	recipients := h.clients
+	if len(delivery.account) > 0 {
+		recipients = h.accounts[delivery.account]
+	} else if len(delivery.topic) > 0 {
+		recipients = h.topics[delivery.topic]
+	}
+	for client := range recipients {
+		h.send(client, delivery.message)
+	}
+}
+
+// BroadcastMessage sends the message to every connection.
+func (h *Hub) BroadcastMessage(ctx context.Context, message Message) error {
+	return h.enqueue(ctx, delivery{}, message)
+}
+
+// SendToUser sends the message to every connection of the account.
+func (h *Hub) SendToUser(ctx context.Context, account string, message Message) error {
+	return h.enqueue(ctx, delivery{account: account}, message)
+}
+
+// Publish sends the message to every connection subscribed to the topic.
+func (h *Hub) Publish(ctx context.Context, topic string, message Message) error {
+	message.Topic = topic
+	return h.enqueue(ctx, delivery{topic: topic}, message)
+}
+
+// Queues a published message for the backplane without ever blocking the
+// caller. The message is dropped if the queue is full.
+func (h *Hub) enqueue(ctx context.Context, delivery delivery, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	delivery.id = message.ID
+	if delivery.message, err = json.Marshal(message); err != nil {
+		return err
+	}
+	return h.queue(delivery)
+}
+
+// Queues the delivery for the backplane without ever blocking the caller.
+func (h *Hub) queue(delivery delivery) error {
+	select {
+	case <-h.done:
+		return ErrHubClosed
+	default:
+	}
+	select {
+	case h.outbound <- delivery:
+		return nil
+	default:
+		metrics.Add(metricPublishDropped, 1)
+		return ErrHubBusy
+	}
+}
+
+// Queues the message for the client. If the client can't keep up, the slow
+// consumer policy drops a message or the client.
+func (h *Hub) send(client *Client, message []byte) {
+	select {
+	case client.send <- message:
+		return
+	default:
+	}
+
+	switch slowConsumerPolicy {
+	case PolicyDropOldest:
+		// The client may have taken a message meanwhile, then nothing is dropped
+		select {
+		case <-client.send:
+			metrics.Add(metricDroppedOldest, 1)
 		default:
-			close(client.send)
-			delete(h.clients, client)
 		}
+		select {
+		case client.send <- message:
+		default:
+			metrics.Add(metricDroppedNewest, 1)
+		}
+	case PolicyDropNewest:
+		metrics.Add(metricDroppedNewest, 1)
+	default:
+		metrics.Add(metricSlowConsumersDisconnected, 1)
+		log.Printf("Disconnecting slow websocket client of %s", client.account)
+		client.disconnection = slowConsumerDisconnection
+		h.remove(client)
+	}
+}
+
+// Encodes the message and queues it for the client.
+func (h *Hub) sendMessage(client *Client, message Message) {
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	h.send(client, bytes)
+}
+
+// Adds the client to the hub and its indexes. Mutes applied since the client
+// loaded its mute replace it.
+func (h *Hub) add(client *Client) {
+	h.clients[client] = true
+	addToIndex(h.accounts, client.account, client)
+	for topic := range client.topics {
+		addToIndex(h.topics, topic, client)
+	}
+	now := time.Now()
+	if until, ok := h.mutes[client.account]; ok && until.After(now) {
+		atomic.StoreInt64(&client.mutedUntil, until.UnixNano())
+	}
+	h.updatePresence(client.account, now)
+}
+
+// Removes the client from the hub and its indexes and closes its send channel.
+func (h *Hub) remove(client *Client) {
+	delete(h.clients, client)
+	removeFromIndex(h.accounts, client.account, client)
+	for topic := range client.topics {
+		removeFromIndex(h.topics, topic, client)
+	}
+	close(client.send)
+	h.updatePresence(client.account, time.Now())
+}
+
+func addToIndex(index map[string]map[*Client]bool, key string, client *Client) {
+	if _, ok := index[key]; !ok {
+		index[key] = make(map[*Client]bool)
+	}
+	index[key][client] = true
+}
+
+func removeFromIndex(index map[string]map[*Client]bool, key string, client *Client) {
+	delete(index[key], client)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+func parseSlowConsumerPolicy(policy string) string {
+	switch policy {
+	case PolicyDropOldest, PolicyDropNewest, PolicyDisconnect:
+		return policy
 	}
+	log.Printf("Unknown slow consumer policy %q, falling back to %s", policy, PolicyDisconnect)
+	return PolicyDisconnect
 }
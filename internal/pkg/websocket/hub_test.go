@@ -0,0 +1,777 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/auth"
+
+	gorillaws "github.com/gorilla/websocket"
+)
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	return newTestReplica(t, NewLocalBackplane())
+}
+
+// Starts a hub relaying through the given backplane, like a replica of the service.
+func newTestReplica(t *testing.T, backplane Backplane) *Hub {
+	t.Helper()
+	validateToken := func(accessToken string) (*auth.Claims, error) {
+		return &auth.Claims{Account: accessToken}, nil
+	}
+	return runTestHub(t, NewHub(validateToken, backplane, nil, nil, nil))
+}
+
+// Runs the hub until the test is over.
+func runTestHub(t *testing.T, hub *Hub) *Hub {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		<-hub.done
+	})
+	go hub.Run(ctx)
+	return hub
+}
+
+func newTestClient(hub *Hub, account string, topics ...string) *Client {
+	client := &Client{
+		hub:         hub,
+		send:        make(chan []byte, sendBufferSize),
+		account:     account,
+		accessToken: account,
+		topics:      make(map[string]bool),
+		lastActive:  time.Now().UnixNano(),
+	}
+	for _, topic := range topics {
+		client.topics[topic] = true
+	}
+	return client
+}
+
+// Reads messages of the client until the hub closes its channel.
+func drain(client *Client) <-chan int {
+	received := make(chan int, 1)
+	go func() {
+		count := 0
+		for range client.send {
+			count++
+		}
+		received <- count
+	}()
+	return received
+}
+
+// Hammers the hub with registrations, subscriptions, broadcasts, controls and
+// presence requests from many goroutines at once. Run with -race.
+func TestHubConcurrentAccess(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	const (
+		connections = 50
+		rounds      = 20
+		publishers  = 8
+	)
+
+	stop := make(chan struct{})
+	var publishing sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		publishing.Add(1)
+		go func(i int) {
+			defer publishing.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				account := fmt.Sprintf("user%d", n%10)
+				// Errors only report a full queue, dropping is fine here
+				switch n % 6 {
+				case 0:
+					hub.BroadcastMessage(ctx, NewEvent("test", "", "broadcast"))
+				case 1:
+					hub.SendToUser(ctx, account, NewEvent("test", account, "direct"))
+				case 2:
+					hub.Publish(ctx, "room:stress", NewEvent("test", "", "topic"))
+				case 3:
+					hub.Mute(ctx, account, time.Now().Add(time.Second))
+				case 4:
+					hub.Unmute(ctx, account)
+				case 5:
+					if _, err := hub.IsOnline(ctx, account); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				if n%50 == 0 && i == 0 {
+					hub.Kick(ctx, account)
+				}
+			}
+		}(i)
+	}
+
+	var connecting sync.WaitGroup
+	for i := 0; i < connections; i++ {
+		connecting.Add(1)
+		go func(i int) {
+			defer connecting.Done()
+			for round := 0; round < rounds; round++ {
+				client := newTestClient(hub, fmt.Sprintf("user%d", i%10), "room:stress")
+				received := drain(client)
+				if round%2 == 0 {
+					hub.register <- client
+				} else {
+					hub.registerStream <- streamRegistration{client: client, lastEventID: "unknown"}
+				}
+				hub.subscribe <- subscription{client: client, topic: fmt.Sprintf("topic:%d", round), id: "1"}
+				client.isMuted(time.Now())
+				hub.unsubscribe <- subscription{client: client, topic: "room:stress", id: "2"}
+				hub.unregister <- client
+				<-received
+			}
+		}(i)
+	}
+	connecting.Wait()
+	close(stop)
+	publishing.Wait()
+
+	presence, err := hub.Presence(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(presence) != 0 {
+		t.Errorf("presence of unregistered clients is left: %v", presence)
+	}
+}
+
+// A registered client gets broadcasts, messages to its account and messages
+// of its topics, but not those of other accounts or topics.
+func TestHubDelivery(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	client := newTestClient(hub, "alice", "room:general")
+	hub.register <- client
+	waitForBackplane(t, hub, client)
+
+	publish := []func() error{
+		func() error { return hub.SendToUser(ctx, "bob", NewEvent("test", "bob", "bob")) },
+		func() error { return hub.Publish(ctx, "room:other", NewEvent("test", "", "other")) },
+		func() error { return hub.BroadcastMessage(ctx, NewEvent("test", "", "everyone")) },
+		func() error { return hub.SendToUser(ctx, "alice", NewEvent("test", "alice", "alice")) },
+		func() error { return hub.Publish(ctx, "room:general", NewEvent("test", "", "general")) },
+	}
+	for _, publish := range publish {
+		if err := publish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Messages keep their order through the backplane, so any wrongly
+	// delivered one would show up among these
+	for _, want := range []string{"everyone", "alice", "general"} {
+		for {
+			payload := receiveEvent(t, client)
+			if payload.Event == "ready" {
+				continue
+			}
+			if payload.Text != want {
+				t.Errorf("got message %q, want %q", payload.Text, want)
+			}
+			break
+		}
+	}
+}
+
+// Waits until the hub has subscribed to the backplane, which it does in the
+// background once running. Messages published before are lost.
+func waitForBackplane(t *testing.T, hub *Hub, client *Client) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := hub.SendToUser(context.Background(), client.account, NewEvent("ready", client.account, "")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-client.send:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("hub didn't subscribe to the backplane")
+}
+
+// Receives the next event queued for the client.
+func receiveEvent(t *testing.T, client *Client) EventPayload {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		return decodeEvent(t, data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was delivered")
+	}
+	return EventPayload{}
+}
+
+func decodeEvent(t *testing.T, data []byte) EventPayload {
+	t.Helper()
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	var payload EventPayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// Presence is shared by the replicas through the backplane, and presence
+// events follow the accounts over all replicas.
+func TestHubPresenceAcrossReplicas(t *testing.T) {
+	backplane := NewLocalBackplane()
+	first := newTestReplica(t, backplane)
+	second := newTestReplica(t, backplane)
+	ctx := context.Background()
+	waitForSubscribers(t, backplane, 2)
+
+	watcher := newTestClient(second, "watcher", PresenceTopic)
+	second.register <- watcher
+	waitForPresence(t, first, Presence{Account: "watcher", Status: PresenceOnline, Connections: 1})
+	expectPresenceEvent(t, watcher, "watcher", EventPresenceJoined)
+
+	alice := newTestClient(first, "alice")
+	first.register <- alice
+	waitForPresence(t, second, Presence{Account: "alice", Status: PresenceOnline, Connections: 1},
+		Presence{Account: "watcher", Status: PresenceOnline, Connections: 1})
+	expectPresenceEvent(t, watcher, "alice", EventPresenceJoined)
+	if online, err := second.IsOnline(ctx, "alice"); err != nil || !online {
+		t.Errorf("alice isn't online on the other replica: %v", err)
+	}
+
+	// Connecting on another replica as well doesn't join again, and leaving
+	// one of them doesn't leave
+	aliceAgain := newTestClient(second, "alice")
+	second.register <- aliceAgain
+	waitForPresence(t, first, Presence{Account: "alice", Status: PresenceOnline, Connections: 2},
+		Presence{Account: "watcher", Status: PresenceOnline, Connections: 1})
+	first.unregister <- alice
+	waitForPresence(t, second, Presence{Account: "alice", Status: PresenceOnline, Connections: 1},
+		Presence{Account: "watcher", Status: PresenceOnline, Connections: 1})
+	second.unregister <- aliceAgain
+	waitForPresence(t, first, Presence{Account: "watcher", Status: PresenceOnline, Connections: 1})
+	expectPresenceEvent(t, watcher, "alice", EventPresenceLeft)
+	if online, err := first.IsOnline(ctx, "alice"); err != nil || online {
+		t.Errorf("alice is still online: %v", err)
+	}
+}
+
+// Presence of a replica which stops reporting it expires. The hub isn't run,
+// so its methods are called directly.
+func TestHubPresenceExpires(t *testing.T) {
+	hub := NewHub(nil, NewLocalBackplane(), nil, nil, nil)
+	now := time.Now()
+	lastActiveAt := now.Add(-time.Second)
+
+	hub.applyRemotePresence("other", []Presence{
+		{Account: "alice", Status: PresenceIdle, Connections: 2, LastActiveAt: now.Add(-time.Hour)},
+		{Account: "bob", Status: PresenceOnline, Connections: 1, LastActiveAt: lastActiveAt},
+	}, now)
+	hub.applyRemotePresence("another", []Presence{
+		{Account: "alice", Status: PresenceOnline, Connections: 1, LastActiveAt: lastActiveAt},
+	}, now.Add(presenceCheckPeriod))
+	// Reports of this replica come back through the backplane as well
+	hub.applyRemotePresence(hub.replica, []Presence{{Account: "carol", Status: PresenceOnline, Connections: 1}}, now)
+
+	want := []Presence{
+		{Account: "alice", Status: PresenceOnline, Connections: 3, LastActiveAt: lastActiveAt},
+		{Account: "bob", Status: PresenceOnline, Connections: 1, LastActiveAt: lastActiveAt},
+	}
+	if presence := hub.listPresence(); !presenceEquals(presence, want) {
+		t.Fatalf("got presence %+v, want %+v", presence, want)
+	}
+
+	// Only the reports of the first replica are old enough
+	hub.expirePresence(now.Add(presenceExpiryPeriods * presenceCheckPeriod))
+	want = []Presence{{Account: "alice", Status: PresenceOnline, Connections: 1, LastActiveAt: lastActiveAt}}
+	if presence := hub.listPresence(); !presenceEquals(presence, want) {
+		t.Fatalf("got presence %+v, want %+v", presence, want)
+	}
+	select {
+	case published := <-hub.outbound:
+		payload := decodeEvent(t, published.message)
+		if published.topic != PresenceTopic || payload.Event != EventPresenceLeft || payload.Account != "bob" {
+			t.Errorf("got event %s of %s to %q", payload.Event, payload.Account, published.topic)
+		}
+	default:
+		t.Fatal("leaving of bob wasn't published")
+	}
+	if len(hub.outbound) > 0 {
+		t.Errorf("%d more messages were published", len(hub.outbound))
+	}
+}
+
+// Waits until the given number of hubs subscribed to the backplane.
+func waitForSubscribers(t *testing.T, backplane *LocalBackplane, subscribers int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		backplane.mu.RLock()
+		subscribed := len(backplane.handlers)
+		backplane.mu.RUnlock()
+		if subscribed == subscribers {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("hubs didn't subscribe to the backplane")
+}
+
+// Waits until the hub lists the given presence, ignoring the last activity
+// unless it's set.
+func waitForPresence(t *testing.T, hub *Hub, want ...Presence) {
+	t.Helper()
+	var presence []Presence
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var err error
+		if presence, err = hub.Presence(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if presenceEquals(presence, want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("got presence %+v, want %+v", presence, want)
+}
+
+func presenceEquals(presence []Presence, want []Presence) bool {
+	if len(presence) != len(want) {
+		return false
+	}
+	for i := range want {
+		got := presence[i]
+		if want[i].LastActiveAt.IsZero() {
+			got.LastActiveAt = time.Time{}
+		}
+		if !got.LastActiveAt.Equal(want[i].LastActiveAt) {
+			return false
+		}
+		got.LastActiveAt = want[i].LastActiveAt
+		if got != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Receives the next presence event, which has to be the given one.
+func expectPresenceEvent(t *testing.T, client *Client, account string, event string) {
+	t.Helper()
+	for {
+		payload := receiveEvent(t, client)
+		if payload.Event == "ready" {
+			continue
+		}
+		if payload.Event != event || payload.Account != account {
+			t.Errorf("got event %s of %s, want %s of %s", payload.Event, payload.Account, event, account)
+		}
+		return
+	}
+}
+
+// Mutes kept in memory, like the chat_mutes table.
+type memoryMuteStore struct {
+	mu    sync.Mutex
+	mutes map[string]time.Time
+}
+
+func (s *memoryMuteStore) SaveMute(ctx context.Context, account string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mutes[account] = until
+	return nil
+}
+
+func (s *memoryMuteStore) DeleteMute(ctx context.Context, account string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mutes, account)
+	return nil
+}
+
+func (s *memoryMuteStore) MutedUntil(ctx context.Context, account string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until := s.mutes[account]; until.After(time.Now()) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+// Mutes are stored, so connections made later, e.g. to a replica started
+// after the mute, are muted as well.
+func TestHubLoadsStoredMutes(t *testing.T) {
+	store := &memoryMuteStore{mutes: map[string]time.Time{"alice": time.Now().Add(time.Hour)}}
+	validateToken := func(accessToken string) (*auth.Claims, error) {
+		return &auth.Claims{Account: accessToken}, nil
+	}
+	hub := runTestHub(t, NewHub(validateToken, NewLocalBackplane(), nil, store, Pipeline{}))
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account := r.URL.Query().Get("account")
+		ctx := context.WithValue(r.Context(), "tokenClaims", &auth.Claims{Account: account})
+		ctx = context.WithValue(ctx, "accessToken", account)
+		ServeWs(hub, w, r.WithContext(ctx))
+	}))
+	t.Cleanup(server.Close)
+
+	// Sends a chat message as the account, returning the type of the answer
+	chat := func(account string) string {
+		t.Helper()
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?account=" + account
+		conn, _, err := gorillaws.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := conn.WriteMessage(gorillaws.TextMessage, []byte(`{"v":1,"type":"chat","id":"1","payload":{"text":"hi"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var message Message
+			if err := conn.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			}
+			if message.ID != "1" {
+				continue
+			}
+			if message.Type == TypeError {
+				var payload ErrorPayload
+				json.Unmarshal(message.Payload, &payload)
+				return payload.Code
+			}
+			return message.Type
+		}
+	}
+
+	if answer := chat("alice"); answer != ErrorCodeMuted {
+		t.Errorf("muted account got %s", answer)
+	}
+	if answer := chat("bob"); answer != TypeAck {
+		t.Errorf("account which isn't muted got %s", answer)
+	}
+
+	if err := hub.Unmute(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Mute(ctx, "bob", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	store.mu.Lock()
+	_, aliceMuted := store.mutes["alice"]
+	_, bobMuted := store.mutes["bob"]
+	store.mu.Unlock()
+	if aliceMuted || !bobMuted {
+		t.Errorf("got stored mutes of alice %v and bob %v", aliceMuted, bobMuted)
+	}
+}
+
+// Only tokens found invalid or revoked end a connection. A failed check, e.g.
+// while the DB is unavailable, keeps it until the next ping.
+func TestClientRevalidatesToken(t *testing.T) {
+	for name, tt := range map[string]struct {
+		err  error
+		want bool
+	}{
+		"valid":         {nil, true},
+		"revoked":       {auth.ErrInvalidAccessToken, false},
+		"check failed":  {errors.New("connection refused"), true},
+		"wrapped error": {fmt.Errorf("session: %w", auth.ErrInvalidAccessToken), false},
+	} {
+		err := tt.err
+		hub := NewHub(func(accessToken string) (*auth.Claims, error) {
+			return &auth.Claims{Account: "alice"}, err
+		}, NewLocalBackplane(), nil, nil, nil)
+		client := &Client{hub: hub, account: "alice", accessToken: "token"}
+		if got := client.isAuthenticated(); got != tt.want {
+			t.Errorf("%s: got authenticated %t, want %t", name, got, tt.want)
+		}
+	}
+
+	hub := NewHub(func(accessToken string) (*auth.Claims, error) {
+		return nil, errors.New("connection refused")
+	}, NewLocalBackplane(), nil, nil, nil)
+	client := &Client{hub: hub, account: "alice", accessToken: "token", expiresAt: time.Now().Add(-time.Second)}
+	if client.isAuthenticated() {
+		t.Error("expired token was kept")
+	}
+}
+
+// Connections are only served behind the access check, which passes the token
+// along with its claims.
+func TestServeRequiresCheckedToken(t *testing.T) {
+	hub := NewHub(nil, NewLocalBackplane(), nil, nil, nil)
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "tokenClaims", &auth.Claims{Account: "alice"}))
+
+	w := httptest.NewRecorder()
+	ServeWs(hub, w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("websocket without token got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	ServeEvents(hub, w, r, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("event stream without token got status %d", w.Code)
+	}
+}
+
+// Receives the next message queued for the client, whatever its type.
+func receiveMessage(t *testing.T, client *Client) Message {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was delivered")
+	}
+	return Message{}
+}
+
+// Messages to an account reach every connection of the account, and only
+// those.
+func TestHubSendToUserReachesEverySession(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	alicePhone, aliceLaptop, bob := newTestClient(hub, "alice"), newTestClient(hub, "alice"), newTestClient(hub, "bob")
+	for _, client := range []*Client{alicePhone, aliceLaptop, bob} {
+		hub.register <- client
+	}
+	waitForBackplane(t, hub, bob)
+	// The ready marker of bob's wait went to alice's connections as well
+	for _, client := range []*Client{alicePhone, aliceLaptop} {
+		for len(client.send) > 0 {
+			<-client.send
+		}
+	}
+
+	if err := hub.SendToUser(ctx, "alice", NewEvent(EventLoginFailed, "alice", "login failed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.SendToUser(ctx, "bob", NewEvent("test", "bob", "bob")); err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []*Client{alicePhone, aliceLaptop} {
+		if payload := receiveEvent(t, client); payload.Event != EventLoginFailed {
+			t.Errorf("alice got event %q", payload.Event)
+		}
+	}
+	if payload := receiveEvent(t, bob); payload.Text != "bob" {
+		t.Errorf("bob got a message of alice: %+v", payload)
+	}
+}
+
+// Subscribe and unsubscribe frames are acked, and topics only deliver while
+// subscribed.
+func TestHubSubscriptions(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+	client := newTestClient(hub, "alice")
+	hub.register <- client
+	waitForBackplane(t, hub, client)
+
+	frame := func(messageType string, id string, topic string) []byte {
+		return []byte(fmt.Sprintf(`{"v":1,"type":%q,"id":%q,"topic":%q}`, messageType, id, topic))
+	}
+	expect := func(messageType string, id string) Message {
+		t.Helper()
+		message := receiveMessage(t, client)
+		if message.Type != messageType || message.ID != id {
+			t.Fatalf("got %s %q, want %s %q", message.Type, message.ID, messageType, id)
+		}
+		return message
+	}
+
+	client.forward(frame(TypeSubscribe, "1", "orders.eu"))
+	expect(TypeAck, "1")
+	if err := hub.Publish(ctx, "orders.eu", NewEvent("test", "", "subscribed")); err != nil {
+		t.Fatal(err)
+	}
+	if payload := receiveEvent(t, client); payload.Text != "subscribed" {
+		t.Errorf("got %+v", payload)
+	}
+
+	client.forward(frame(TypeUnsubscribe, "2", "orders.eu"))
+	expect(TypeAck, "2")
+	if err := hub.Publish(ctx, "orders.eu", NewEvent("test", "", "unsubscribed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.SendToUser(ctx, "alice", NewEvent("test", "alice", "marker")); err != nil {
+		t.Fatal(err)
+	}
+	if payload := receiveEvent(t, client); payload.Text != "marker" {
+		t.Errorf("got a message of an unsubscribed topic: %+v", payload)
+	}
+
+	for id, topic := range map[string]string{"3": "Orders", "4": "room:general", "5": ""} {
+		client.forward(frame(TypeSubscribe, id, topic))
+		message := expect(TypeError, id)
+		var payload ErrorPayload
+		json.Unmarshal(message.Payload, &payload)
+		if payload.Code != ErrorCodeInvalidTopic {
+			t.Errorf("topic %q got error %q", topic, payload.Code)
+		}
+	}
+
+	// Straight to the hub, so the client's rate limit doesn't kick in
+	for i := len(client.topics); i < maxSubscriptions; i++ {
+		hub.subscribe <- subscription{client: client, topic: fmt.Sprintf("topic.%d", i), id: "sub"}
+		expect(TypeAck, "sub")
+	}
+	hub.subscribe <- subscription{client: client, topic: "topic.over", id: "over"}
+	message := expect(TypeError, "over")
+	var payload ErrorPayload
+	json.Unmarshal(message.Payload, &payload)
+	if payload.Code != ErrorCodeTooManySubscriptions {
+		t.Errorf("subscription over the limit got error %q", payload.Code)
+	}
+}
+
+// Reads a counter of the websocket expvar map.
+func metricValue(name string) int64 {
+	if counter, ok := metrics.Get(name).(*expvar.Int); ok {
+		return counter.Value()
+	}
+	return 0
+}
+
+func setSlowConsumerPolicy(t *testing.T, policy string) {
+	t.Helper()
+	previous := slowConsumerPolicy
+	slowConsumerPolicy = policy
+	t.Cleanup(func() {
+		slowConsumerPolicy = previous
+	})
+}
+
+// Adds a client with a send buffer of two messages, both queued, to a hub
+// which isn't running, so the test calls its methods itself.
+func newSlowTestClient(t *testing.T) (*Hub, *Client) {
+	t.Helper()
+	hub := NewHub(nil, NewLocalBackplane(), nil, nil, nil)
+	client := newTestClient(hub, "slow")
+	client.send = make(chan []byte, 2)
+	hub.add(client)
+	hub.send(client, []byte("first"))
+	hub.send(client, []byte("second"))
+	return hub, client
+}
+
+func queuedMessages(client *Client) []string {
+	var queued []string
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				return queued
+			}
+			queued = append(queued, string(message))
+		default:
+			return queued
+		}
+	}
+}
+
+func TestSlowConsumerDropOldest(t *testing.T) {
+	setSlowConsumerPolicy(t, PolicyDropOldest)
+	hub, client := newSlowTestClient(t)
+	dropped := metricValue(metricDroppedOldest)
+
+	hub.send(client, []byte("third"))
+	if queued := strings.Join(queuedMessages(client), ","); queued != "second,third" {
+		t.Errorf("queued %s, want second,third", queued)
+	}
+	if got := metricValue(metricDroppedOldest) - dropped; got != 1 {
+		t.Errorf("%s grew by %d", metricDroppedOldest, got)
+	}
+	if !hub.clients[client] || client.disconnection != nil {
+		t.Error("client was disconnected")
+	}
+}
+
+func TestSlowConsumerDropNewest(t *testing.T) {
+	setSlowConsumerPolicy(t, PolicyDropNewest)
+	hub, client := newSlowTestClient(t)
+	dropped := metricValue(metricDroppedNewest)
+
+	hub.send(client, []byte("third"))
+	if queued := strings.Join(queuedMessages(client), ","); queued != "first,second" {
+		t.Errorf("queued %s, want first,second", queued)
+	}
+	if got := metricValue(metricDroppedNewest) - dropped; got != 1 {
+		t.Errorf("%s grew by %d", metricDroppedNewest, got)
+	}
+	if !hub.clients[client] || client.disconnection != nil {
+		t.Error("client was disconnected")
+	}
+}
+
+// The client is removed and its channel closed, so its write pump sends the
+// close reason after the queued messages.
+func TestSlowConsumerDisconnect(t *testing.T) {
+	setSlowConsumerPolicy(t, PolicyDisconnect)
+	hub, client := newSlowTestClient(t)
+	disconnected := metricValue(metricSlowConsumersDisconnected)
+
+	hub.send(client, []byte("third"))
+	if client.disconnection != slowConsumerDisconnection {
+		t.Errorf("got disconnection %+v", client.disconnection)
+	}
+	if hub.clients[client] || len(hub.accounts["slow"]) > 0 {
+		t.Error("client wasn't removed from the hub")
+	}
+	if queued := strings.Join(queuedMessages(client), ","); queued != "first,second" {
+		t.Errorf("queued %s, want first,second", queued)
+	}
+	if _, ok := <-client.send; ok {
+		t.Error("send channel wasn't closed")
+	}
+	if got := metricValue(metricSlowConsumersDisconnected) - disconnected; got != 1 {
+		t.Errorf("%s grew by %d", metricSlowConsumersDisconnected, got)
+	}
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	for policy, want := range map[string]string{
+		PolicyDropOldest: PolicyDropOldest,
+		PolicyDropNewest: PolicyDropNewest,
+		PolicyDisconnect: PolicyDisconnect,
+		"":               PolicyDisconnect,
+		"DROP_OLDEST":    PolicyDisconnect,
+		"block":          PolicyDisconnect,
+	} {
+		if got := parseSlowConsumerPolicy(policy); got != want {
+			t.Errorf("%q got policy %s, want %s", policy, got, want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/auth"
+)
+
+// Version of the message protocol
+const ProtocolVersion = 1
+
+// Message types
+const (
+	TypeChat        = "chat"
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+	TypeJoin        = "join"
+	TypeLeave       = "leave"
+	TypeAck         = "ack"
+	TypeError       = "error"
+	TypeEvent       = "event"
+)
+
+// Events of system notifications
+const (
+	EventLoginFailed     = "login.failed"
+	EventPasswordChanged = "password.changed"
+	EventPasswordReset   = "password.reset"
+	EventTotpDisabled    = "totp.disabled"
+)
+
+// Codes of error messages
+const (
+	ErrorCodeInvalidMessage       = "invalid_message"
+	ErrorCodeUnsupportedVersion   = "unsupported_version"
+	ErrorCodeInvalidTopic         = "invalid_topic"
+	ErrorCodeTooManySubscriptions = "too_many_subscriptions"
+	ErrorCodeRoomNotFound         = "room_not_found"
+	ErrorCodeNotJoined            = "not_joined"
+	ErrorCodeInternal             = "internal_error"
+	ErrorCodeReplayUnavailable    = "replay_unavailable"
+	ErrorCodeUnauthorized         = "unauthorized"
+	ErrorCodeSlowConsumer         = "slow_consumer"
+	ErrorCodeKicked               = "kicked"
+	ErrorCodeRateLimited          = "rate_limited"
+	ErrorCodeMuted                = "muted"
+	ErrorCodeMessageRejected      = "message_rejected"
+)
+
+// Maximum length of message IDs chosen by clients.
+const maxMessageIDLength = 64
+
+// Message is the envelope of every websocket frame in both directions.
+type Message struct {
+	// Protocol version
+	V int `json:"v"`
+	// Message type
+	Type string `json:"type"`
+	// Message ID chosen by the sender. Acks and errors carry the ID of the message they answer.
+	ID string `json:"id"`
+	// The time when the server sent the message
+	TS time.Time `json:"ts"`
+	// Account which sent the message, empty for messages of the server
+	From string `json:"from,omitempty"`
+	// Topic of the message, every connection receives chat messages without one
+	Topic string `json:"topic,omitempty"`
+	// Type specific content
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Payload of chat messages
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+// Payload of event messages
+type EventPayload struct {
+	// What happened, one of the Event constants
+	Event string `json:"event"`
+	// Account the event is about
+	Account string `json:"account,omitempty"`
+	// Human readable description
+	Text string `json:"text"`
+}
+
+// Payload of error messages
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewEvent creates a system notification about the account.
+func NewEvent(event string, account string, text string) Message {
+	return newMessage(TypeEvent, newMessageID(), EventPayload{Event: event, Account: account, Text: text})
+}
+
+func newAck(id string) Message {
+	return newMessage(TypeAck, id, nil)
+}
+
+func newError(id string, code string, message string) Message {
+	return newMessage(TypeError, id, ErrorPayload{Code: code, Message: message})
+}
+
+func newMessage(messageType string, id string, payload interface{}) Message {
+	message := Message{
+		V:    ProtocolVersion,
+		Type: messageType,
+		ID:   id,
+		TS:   time.Now().UTC(),
+	}
+	if payload != nil {
+		// Payloads are plain structs of strings, which always marshal
+		message.Payload, _ = json.Marshal(payload)
+	}
+	return message
+}
+
+// IDs of messages sent by the server.
+func newMessageID() string {
+	id, err := auth.GenerateRandomToken(12)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return id
+}
+
+// Parses and validates a message received from a client. Invalid messages
+// are answered by the returned error message.
+func parseClientMessage(data []byte) (Message, *Message) {
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		errorMessage := newError("", ErrorCodeInvalidMessage, "message isn't a JSON object")
+		return message, &errorMessage
+	}
+
+	invalid := func(code string, text string) (Message, *Message) {
+		errorMessage := newError(message.ID, code, text)
+		return message, &errorMessage
+	}
+	if message.V != ProtocolVersion {
+		return invalid(ErrorCodeUnsupportedVersion, "v must be 1")
+	}
+	if len(message.ID) == 0 || len(message.ID) > maxMessageIDLength {
+		message.ID = ""
+		return invalid(ErrorCodeInvalidMessage, "id is required and at most 64 characters long")
+	}
+	if len(message.Topic) > 0 && !topicPattern.MatchString(message.Topic) {
+		return invalid(ErrorCodeInvalidTopic, "topic must be lowercase letters, digits and ._:- up to 64 characters")
+	}
+
+	switch message.Type {
+	case TypeChat:
+		var payload ChatPayload
+		if err := json.Unmarshal(message.Payload, &payload); err != nil || len(strings.TrimSpace(payload.Text)) == 0 {
+			return invalid(ErrorCodeInvalidMessage, "payload.text is required")
+		}
+		message.Payload, _ = json.Marshal(ChatPayload{Text: strings.TrimSpace(payload.Text)})
+	case TypeSubscribe, TypeUnsubscribe:
+		if len(message.Topic) == 0 {
+			return invalid(ErrorCodeInvalidTopic, "topic is required")
+		}
+		if len(roomOf(message.Topic)) > 0 {
+			return invalid(ErrorCodeInvalidTopic, "rooms must be joined and left instead")
+		}
+	case TypeJoin, TypeLeave:
+		if !IsValidRoomName(roomOf(message.Topic)) {
+			return invalid(ErrorCodeInvalidTopic, "topic must be room: followed by the room name")
+		}
+	default:
+		return invalid(ErrorCodeInvalidMessage, "type must be chat, subscribe, unsubscribe, join or leave")
+	}
+	return message, nil
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClientMessageRejectsInvalidEnvelopes(t *testing.T) {
+	for data, want := range map[string]struct {
+		id   string
+		code string
+	}{
+		`not json`: {"", ErrorCodeInvalidMessage},
+		`{"type":"chat","id":"1","payload":{"text":"hi"}}`:             {"1", ErrorCodeUnsupportedVersion},
+		`{"v":2,"type":"chat","id":"1","payload":{"text":"hi"}}`:       {"1", ErrorCodeUnsupportedVersion},
+		`{"v":1,"type":"chat","payload":{"text":"hi"}}`:                {"", ErrorCodeInvalidMessage},
+		`{"v":1,"type":"chat","id":"` + strings.Repeat("i", 65) + `"}`: {"", ErrorCodeInvalidMessage},
+		`{"v":1,"type":"chat","id":"1","topic":"Room","payload":{}}`:   {"1", ErrorCodeInvalidTopic},
+		`{"v":1,"type":"chat","id":"1","payload":{"text":"  "}}`:       {"1", ErrorCodeInvalidMessage},
+		`{"v":1,"type":"chat","id":"1"}`:                               {"1", ErrorCodeInvalidMessage},
+		`{"v":1,"type":"ack","id":"1"}`:                                {"1", ErrorCodeInvalidMessage},
+		`{"v":1,"type":"event","id":"1"}`:                              {"1", ErrorCodeInvalidMessage},
+		`{"v":1,"type":"join","id":"1","topic":"general"}`:             {"1", ErrorCodeInvalidTopic},
+		`{"v":1,"type":"subscribe","id":"1","topic":"room:general"}`:   {"1", ErrorCodeInvalidTopic},
+	} {
+		_, errorMessage := parseClientMessage([]byte(data))
+		if errorMessage == nil {
+			t.Errorf("%s was accepted", data)
+			continue
+		}
+		var payload ErrorPayload
+		if err := json.Unmarshal(errorMessage.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if errorMessage.Type != TypeError || errorMessage.ID != want.id || payload.Code != want.code {
+			t.Errorf("%s got %s %q with code %s, want id %q and code %s",
+				data, errorMessage.Type, errorMessage.ID, payload.Code, want.id, want.code)
+		}
+	}
+}
+
+func TestParseClientMessage(t *testing.T) {
+	message, errorMessage := parseClientMessage([]byte(
+		`{"v":1,"type":"chat","id":"42","topic":"room:general","payload":{"text":"  hello  "}}`))
+	if errorMessage != nil {
+		t.Fatalf("got error %s", errorMessage.Payload)
+	}
+	var payload ChatPayload
+	json.Unmarshal(message.Payload, &payload)
+	if message.ID != "42" || message.Topic != "room:general" || payload.Text != "hello" {
+		t.Errorf("got %+v with text %q", message, payload.Text)
+	}
+
+	for _, data := range []string{
+		`{"v":1,"type":"subscribe","id":"1","topic":"orders.eu"}`,
+		`{"v":1,"type":"unsubscribe","id":"1","topic":"orders.eu"}`,
+		`{"v":1,"type":"join","id":"1","topic":"room:general"}`,
+		`{"v":1,"type":"leave","id":"1","topic":"room:general"}`,
+	} {
+		if _, errorMessage := parseClientMessage([]byte(data)); errorMessage != nil {
+			t.Errorf("%s got error %s", data, errorMessage.Payload)
+		}
+	}
+}
+
+// Messages of the server carry the protocol version, an ID and the time they
+// were created.
+func TestServerMessagesAreVersioned(t *testing.T) {
+	before := time.Now().UTC()
+	for _, message := range []Message{
+		NewEvent(EventLoginFailed, "alice", "login failed"),
+		newAck("1"),
+		newError("1", ErrorCodeInvalidMessage, "invalid"),
+	} {
+		data, err := json.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{"v", "type", "id", "ts"} {
+			if _, ok := envelope[field]; !ok {
+				t.Errorf("%s message lacks %s: %s", message.Type, field, data)
+			}
+		}
+		if message.V != ProtocolVersion || len(message.ID) == 0 || message.TS.Before(before) {
+			t.Errorf("got %+v", message)
+		}
+	}
+
+	var payload EventPayload
+	json.Unmarshal(NewEvent(EventLoginFailed, "alice", "login failed").Payload, &payload)
+	if payload.Event != EventLoginFailed || payload.Account != "alice" {
+		t.Errorf("got event payload %+v", payload)
+	}
+}
@@ -0,0 +1,22 @@
+package websocket
+
+import "expvar"
+
+// Counters of dropped messages and clients, published with expvar under
+// "websocket".
+var metrics = expvar.NewMap("websocket")
+
+// Names of the counters
+const (
+	// Queued messages dropped for a newer one by the drop_oldest policy
+	metricDroppedOldest = "messages_dropped_oldest"
+	// New messages dropped by the drop_newest policy
+	metricDroppedNewest = "messages_dropped_newest"
+	// Clients disconnected by the disconnect policy
+	metricSlowConsumersDisconnected = "slow_consumers_disconnected"
+	// Published messages dropped because the queue for the backplane was full
+	metricPublishDropped = "publish_dropped"
+	// Messages from the backplane dropped because the hub couldn't keep up or
+	// they couldn't be loaded
+	metricBackplaneDropped = "backplane_dropped"
+)
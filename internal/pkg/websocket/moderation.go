@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"uiassignment/internal/pkg/config"
+	"unicode/utf8"
+)
+
+var (
+	// Maximum length of chat messages in characters, unlimited if 0.
+	chatMaxLength = config.GetInt("CHAT_MAX_LENGTH", 500)
+	// Comma separated words masked in chat messages.
+	chatBannedWords = config.Get("CHAT_BANNED_WORDS", "")
+	// Whether links are removed from chat messages.
+	chatStripLinks = config.Get("CHAT_STRIP_LINKS", "true") == "true"
+)
+
+// ErrMessageRejected is wrapped by the errors of moderators rejecting a message.
+var ErrMessageRejected = errors.New("message rejected")
+
+// Links removed by StripLinks
+var linkPattern = regexp.MustCompile(`(?i)\s*\b(?:[a-z][a-z0-9+.-]*://|www\.)\S+`)
+
+// Moderator checks the text of a chat message before it's sent, returning the
+// text to send instead, e.g. with parts removed. Rejected messages return an
+// error wrapping ErrMessageRejected, which is shown to the sender.
+type Moderator interface {
+	Moderate(account string, text string) (string, error)
+}
+
+// ModeratorFunc lets ordinary functions be used as moderators.
+type ModeratorFunc func(account string, text string) (string, error)
+
+func (f ModeratorFunc) Moderate(account string, text string) (string, error) {
+	return f(account, text)
+}
+
+// Pipeline runs its moderators in order, each on the text returned by the
+// previous one. Messages left empty are rejected.
+type Pipeline []Moderator
+
+func (p Pipeline) Moderate(account string, text string) (string, error) {
+	for _, moderator := range p {
+		var err error
+		if text, err = moderator.Moderate(account, text); err != nil {
+			return "", err
+		}
+	}
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return "", fmt.Errorf("%w: nothing left after moderation", ErrMessageRejected)
+	}
+	return text, nil
+}
+
+// Creates the pipeline configured by the CHAT_MAX_LENGTH, CHAT_STRIP_LINKS and
+// CHAT_BANNED_WORDS env variables.
+func NewModerator() Moderator {
+	var pipeline Pipeline
+	if chatMaxLength > 0 {
+		pipeline = append(pipeline, MaxLength(chatMaxLength))
+	}
+	if chatStripLinks {
+		pipeline = append(pipeline, StripLinks())
+	}
+	if words := strings.Split(chatBannedWords, ","); len(strings.TrimSpace(chatBannedWords)) > 0 {
+		pipeline = append(pipeline, BannedWords(words))
+	}
+	return pipeline
+}
+
+// MaxLength rejects messages longer than the given number of characters.
+func MaxLength(length int) Moderator {
+	return ModeratorFunc(func(account string, text string) (string, error) {
+		if utf8.RuneCountInString(text) > length {
+			return "", fmt.Errorf("%w: longer than %d characters", ErrMessageRejected, length)
+		}
+		return text, nil
+	})
+}
+
+// StripLinks removes URLs and www. addresses from messages.
+func StripLinks() Moderator {
+	return ModeratorFunc(func(account string, text string) (string, error) {
+		return linkPattern.ReplaceAllString(text, ""), nil
+	})
+}
+
+// BannedWords masks the words with asterisks wherever they appear as whole
+// words, ignoring case.
+func BannedWords(words []string) Moderator {
+	var quoted []string
+	for _, word := range words {
+		if word = strings.TrimSpace(word); len(word) > 0 {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return ModeratorFunc(func(account string, text string) (string, error) {
+		if len(quoted) == 0 {
+			return text, nil
+		}
+		return pattern.ReplaceAllStringFunc(text, func(word string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(word))
+		}), nil
+	})
+}
@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"context"
+	"time"
+	"uiassignment/internal/pkg/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MuteStore persists mutes, so they outlast restarts and apply to connections
+// made later on any replica.
+type MuteStore interface {
+	// Mutes the account until the given time, replacing any earlier mute.
+	SaveMute(ctx context.Context, account string, until time.Time) error
+	// Lifts the mute of the account.
+	DeleteMute(ctx context.Context, account string) error
+	// End of the mute of the account, zero if it isn't muted.
+	MutedUntil(ctx context.Context, account string) (time.Time, error)
+}
+
+// DBMuteStore keeps mutes in the chat_mutes table.
+type DBMuteStore struct {
+	db *gorm.DB
+}
+
+func NewMuteStore(db *gorm.DB) *DBMuteStore {
+	return &DBMuteStore{db: db}
+}
+
+func (s *DBMuteStore) SaveMute(ctx context.Context, account string, until time.Time) error {
+	now := time.Now()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Mutes which are over are kept until the next one is saved
+		if result := tx.Where("muted_until <= ?", now).Delete(&models.ChatMutes{}); result.Error != nil {
+			return result.Error
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "acct"}},
+			DoUpdates: clause.AssignmentColumns([]string{"muted_until", "created_at"}),
+		}).Create(&models.ChatMutes{Acct: account, MutedUntil: until, CreatedAt: now}).Error
+	})
+}
+
+func (s *DBMuteStore) DeleteMute(ctx context.Context, account string) error {
+	return s.db.WithContext(ctx).Where("acct = ?", account).Delete(&models.ChatMutes{}).Error
+}
+
+func (s *DBMuteStore) MutedUntil(ctx context.Context, account string) (time.Time, error) {
+	var mutes []models.ChatMutes
+	result := s.db.WithContext(ctx).Where("acct = ? AND muted_until > ?", account, time.Now()).Limit(1).Find(&mutes)
+	if result.Error != nil || len(mutes) == 0 {
+		return time.Time{}, result.Error
+	}
+	return mutes[0].MutedUntil, nil
+}
@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/models"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+const (
+	// Postgres rejects NOTIFY payloads from 8000 bytes on.
+	maxNotifyPayloadSize = 7999
+
+	// Time to wait before listening again after the connection was lost.
+	listenRetryDelay = 5 * time.Second
+
+	// Prefix of notifications carrying the ID of a message kept in the
+	// backplane_payloads table. Encoded messages are JSON objects, so they
+	// never start with it.
+	payloadRefPrefix = "ref:"
+
+	// Time large messages are kept for the listeners to load them.
+	payloadRetention = time.Minute
+)
+
+// PostgresBackplane relays messages between replicas with LISTEN/NOTIFY on a
+// channel of the database the service already uses. Messages published while
+// a replica isn't listening, e.g. during a reconnect, are lost for it.
+// Messages too large for a NOTIFY payload are kept in the backplane_payloads
+// table for a while and only their IDs are notified.
+type PostgresBackplane struct {
+	db      *gorm.DB
+	dsn     string
+	channel string
+}
+
+func NewPostgresBackplane(db *gorm.DB, dsn string, channel string) *PostgresBackplane {
+	return &PostgresBackplane{db: db, dsn: dsn, channel: channel}
+}
+
+func (b *PostgresBackplane) Publish(ctx context.Context, data []byte) error {
+	if len(data) <= maxNotifyPayloadSize {
+		return b.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", b.channel, string(data)).Error
+	}
+
+	now := time.Now()
+	payload := models.BackplanePayloads{ID: newMessageID(), Payload: string(data), CreatedAt: now}
+	// The notification is sent on commit, once listeners can load the message
+	return b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("created_at < ?", now.Add(-payloadRetention)).
+			Delete(&models.BackplanePayloads{}); result.Error != nil {
+			return result.Error
+		}
+		if result := tx.Create(&payload); result.Error != nil {
+			return result.Error
+		}
+		return tx.Exec("SELECT pg_notify(?, ?)", b.channel, payloadRefPrefix+payload.ID).Error
+	})
+}
+
+func (b *PostgresBackplane) Subscribe(ctx context.Context, handle func(data []byte)) error {
+	for {
+		err := b.listen(ctx, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Lost websocket backplane connection, listening again in %s: %v", listenRetryDelay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+// Listens on a dedicated connection, since GORM's pool can't hold on to one.
+func (b *PostgresBackplane) listen(ctx context.Context, handle func(data []byte)) error {
+	config, err := pgconn.ParseConfig(b.dsn)
+	if err != nil {
+		return err
+	}
+	config.OnNotification = func(_ *pgconn.PgConn, notification *pgconn.Notification) {
+		if !strings.HasPrefix(notification.Payload, payloadRefPrefix) {
+			handle([]byte(notification.Payload))
+			return
+		}
+		// Loaded before the next notification, so messages stay in order
+		data, err := b.loadPayload(ctx, strings.TrimPrefix(notification.Payload, payloadRefPrefix))
+		if err != nil {
+			metrics.Add(metricBackplaneDropped, 1)
+			log.Println(err.Error())
+			return
+		}
+		handle(data)
+	}
+
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	// pg_notify takes the channel name verbatim, so it's quoted here as well
+	quotedChannel := `"` + strings.ReplaceAll(b.channel, `"`, `""`) + `"`
+	if _, err := conn.Exec(ctx, "LISTEN "+quotedChannel).ReadAll(); err != nil {
+		return err
+	}
+
+	for {
+		if err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Loads a message kept in the backplane_payloads table. The listening
+// connection is busy waiting for notifications, so it's loaded through the pool.
+func (b *PostgresBackplane) loadPayload(ctx context.Context, id string) ([]byte, error) {
+	var payload models.BackplanePayloads
+	if result := b.db.WithContext(ctx).Where("id = ?", id).First(&payload); result.Error != nil {
+		return nil, result.Error
+	}
+	return []byte(payload.Payload), nil
+}
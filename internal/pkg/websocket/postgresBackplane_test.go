@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"uiassignment/internal/pkg/db"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Connects to the database set up by `make start_db init_db`. Tests needing
+// it are skipped if it can't be reached.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	testDB, err := gorm.Open(postgres.Open(db.DSN()+" connect_timeout=2"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Skipf("test database isn't available: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := testDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return testDB
+}
+
+// Messages too large for a NOTIFY payload are relayed as well, in order.
+func TestPostgresBackplaneRelaysLargeMessages(t *testing.T) {
+	backplane := NewPostgresBackplane(openTestDB(t), db.DSN(), "websocket_test_"+newMessageID()[:8])
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	received := make(chan string, 16)
+	go backplane.Subscribe(ctx, func(data []byte) { received <- string(data) })
+
+	// Messages published before the listener is up are lost, so wait for it
+	ready := false
+	for attempt := 0; attempt < 50 && !ready; attempt++ {
+		if err := backplane.Publish(ctx, []byte(`{"probe":true}`)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-received:
+			ready = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if !ready {
+		t.Fatal("backplane isn't listening")
+	}
+	// Drain probes which arrived late
+	for drained := false; !drained; {
+		select {
+		case <-received:
+		case <-time.After(200 * time.Millisecond):
+			drained = true
+		}
+	}
+
+	messages := []string{
+		`{"message":"` + strings.Repeat("a", 3*maxNotifyPayloadSize) + `"}`,
+		`{"message":"small"}`,
+		`{"message":"` + strings.Repeat("b", maxNotifyPayloadSize) + `"}`,
+	}
+	for _, message := range messages {
+		if err := backplane.Publish(ctx, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range messages {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("message %d got %d bytes, want %d", i, len(got), len(want))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d wasn't relayed", i)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	"uiassignment/internal/pkg/config"
+)
+
+// Topic presence events are published to.
+const PresenceTopic = "presence"
+
+// Presence statuses
+const (
+	PresenceOnline = "online"
+	PresenceIdle   = "idle"
+)
+
+// Events of presence changes
+const (
+	EventPresenceJoined = "presence.joined"
+	EventPresenceIdle   = "presence.idle"
+	EventPresenceActive = "presence.active"
+	EventPresenceLeft   = "presence.left"
+)
+
+var (
+	// Accounts whose connections sent nothing for this long become idle.
+	presenceIdleAfter = config.GetDuration("PRESENCE_IDLE_AFTER", 5*time.Minute)
+	// How often idle accounts are looked for and the presence is reported to
+	// the other replicas.
+	presenceCheckPeriod = 30 * time.Second
+)
+
+const (
+	// Presence reported by another replica is forgotten if it isn't reported
+	// again within this many check periods, e.g. because the replica stopped.
+	presenceExpiryPeriods = 3
+
+	// Encoded size of the presence reported in one backplane message, well
+	// below the NOTIFY payload limit of the Postgres backplane.
+	maxPresenceReportSize = 4000
+)
+
+// Presence of an account connected to the hub.
+type Presence struct {
+	// User account
+	Account string `json:"account"`
+	// online or idle
+	Status string `json:"status"`
+	// Number of open connections
+	Connections int `json:"connections"`
+	// The time when any of the connections last sent a message or connected
+	LastActiveAt time.Time `json:"lastActiveAt"`
+}
+
+// Presence of an account reported by another replica
+type replicaPresence struct {
+	Presence
+	expiresAt time.Time
+}
+
+// Presence lists the accounts connected to the hub of any replica, ordered by
+// account. Changes on other replicas are seen once they're relayed by the
+// backplane, a replica which just started sees the others' accounts after a
+// presence check period.
+func (h *Hub) Presence(ctx context.Context) ([]Presence, error) {
+	reply := make(chan []Presence, 1)
+	select {
+	case h.presenceRequests <- reply:
+	case <-h.done:
+		return nil, ErrHubClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case presence := <-reply:
+		return presence, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// IsOnline reports whether the account has any connection to the hub of any replica.
+func (h *Hub) IsOnline(ctx context.Context, account string) (bool, error) {
+	presence, err := h.Presence(ctx)
+	if err != nil {
+		return false, err
+	}
+	index := sort.Search(len(presence), func(i int) bool { return presence[i].Account >= account })
+	return index < len(presence) && presence[index].Account == account, nil
+}
+
+// Snapshot of the presence of all accounts on all replicas, run by the hub goroutine.
+func (h *Hub) listPresence() []Presence {
+	accounts := make(map[string]bool)
+	for account := range h.presence {
+		accounts[account] = true
+	}
+	for account := range h.remotePresence {
+		accounts[account] = true
+	}
+
+	var presence = []Presence{}
+	for account := range accounts {
+		if accountPresence, ok := h.mergedPresence(account); ok {
+			presence = append(presence, accountPresence)
+		}
+	}
+	sort.Slice(presence, func(i, j int) bool { return presence[i].Account < presence[j].Account })
+	return presence
+}
+
+// Presence of the account over all replicas, online if it's online on any of
+// them. Reports false if the account isn't connected anywhere.
+func (h *Hub) mergedPresence(account string) (Presence, bool) {
+	merged := Presence{Account: account, Status: PresenceIdle}
+	add := func(presence Presence) {
+		merged.Connections += presence.Connections
+		if presence.LastActiveAt.After(merged.LastActiveAt) {
+			merged.LastActiveAt = presence.LastActiveAt
+		}
+		if presence.Status == PresenceOnline {
+			merged.Status = PresenceOnline
+		}
+	}
+	if local, ok := h.presence[account]; ok {
+		add(*local)
+	}
+	for _, remote := range h.remotePresence[account] {
+		add(remote.Presence)
+	}
+	return merged, merged.Connections > 0
+}
+
+// Recomputes the presence of the account from its connections, reports it to
+// the other replicas and publishes the change, run by the hub goroutine.
+func (h *Hub) updatePresence(account string, now time.Time) {
+	before, wasPresent := h.mergedPresence(account)
+	previous, wasConnected := h.presence[account]
+	clients := h.accounts[account]
+	if len(clients) == 0 {
+		if wasConnected {
+			delete(h.presence, account)
+			h.reportPresence([]Presence{{Account: account}})
+			h.publishPresenceChange(account, before, wasPresent)
+		}
+		return
+	}
+
+	current := Presence{Account: account, Connections: len(clients)}
+	for client := range clients {
+		if lastActiveAt := client.lastActiveAt(); lastActiveAt.After(current.LastActiveAt) {
+			current.LastActiveAt = lastActiveAt
+		}
+	}
+	current.Status = PresenceOnline
+	if now.Sub(current.LastActiveAt) >= presenceIdleAfter {
+		current.Status = PresenceIdle
+	}
+	h.presence[account] = &current
+
+	if !wasConnected || previous.Status != current.Status || previous.Connections != current.Connections {
+		h.reportPresence([]Presence{current})
+	}
+	h.publishPresenceChange(account, before, wasPresent)
+}
+
+// Publishes the change of the account's presence over all replicas. Only the
+// replica where the change happened publishes it.
+func (h *Hub) publishPresenceChange(account string, before Presence, wasPresent bool) {
+	after, isPresent := h.mergedPresence(account)
+	switch {
+	case !wasPresent && isPresent:
+		h.publishPresence(EventPresenceJoined, account, "%s came online")
+	case wasPresent && !isPresent:
+		h.publishPresence(EventPresenceLeft, account, "%s went offline")
+	case !isPresent:
+	case before.Status == PresenceOnline && after.Status == PresenceIdle:
+		h.publishPresence(EventPresenceIdle, account, "%s is idle")
+	case before.Status == PresenceIdle && after.Status == PresenceOnline:
+		h.publishPresence(EventPresenceActive, account, "%s is active again")
+	}
+}
+
+func (h *Hub) publishPresence(event string, account string, format string) {
+	err := h.Publish(context.Background(), PresenceTopic, NewEvent(event, account, fmt.Sprintf(format, account)))
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Sends the presence of accounts on this replica to the others. Presence
+// without connections tells that the account left this replica.
+func (h *Hub) reportPresence(presence []Presence) {
+	if err := h.queue(delivery{control: controlPresence, replica: h.replica, presence: presence}); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Reports the presence of all accounts on this replica, so the other
+// replicas keep it. Split up to keep the backplane messages small.
+func (h *Hub) reportAllPresence() {
+	var report []Presence
+	size := 0
+	for _, presence := range h.presence {
+		// Roughly the size of the encoded presence
+		presenceSize := len(presence.Account) + 100
+		if len(report) > 0 && size+presenceSize > maxPresenceReportSize {
+			h.reportPresence(report)
+			report, size = nil, 0
+		}
+		report = append(report, *presence)
+		size += presenceSize
+	}
+	if len(report) > 0 {
+		h.reportPresence(report)
+	}
+}
+
+// Keeps the presence reported by another replica, run by the hub goroutine.
+// Changes are published by the reporting replica, not here.
+func (h *Hub) applyRemotePresence(replica string, presence []Presence, now time.Time) {
+	if replica == h.replica || len(replica) == 0 {
+		return
+	}
+	expiresAt := now.Add(presenceExpiryPeriods * presenceCheckPeriod)
+	for _, accountPresence := range presence {
+		account := accountPresence.Account
+		if accountPresence.Connections <= 0 {
+			delete(h.remotePresence[account], replica)
+			if len(h.remotePresence[account]) == 0 {
+				delete(h.remotePresence, account)
+			}
+			continue
+		}
+		if _, ok := h.remotePresence[account]; !ok {
+			h.remotePresence[account] = make(map[string]replicaPresence)
+		}
+		h.remotePresence[account][replica] = replicaPresence{Presence: accountPresence, expiresAt: expiresAt}
+	}
+}
+
+// Forgets the presence of replicas which stopped reporting it, run by the hub
+// goroutine. Every replica publishes the resulting changes, since the stopped
+// one can't.
+func (h *Hub) expirePresence(now time.Time) {
+	for account, replicas := range h.remotePresence {
+		before, wasPresent := h.mergedPresence(account)
+		for replica, presence := range replicas {
+			if !presence.expiresAt.After(now) {
+				delete(replicas, replica)
+			}
+		}
+		if len(replicas) == 0 {
+			delete(h.remotePresence, account)
+		}
+		h.publishPresenceChange(account, before, wasPresent)
+	}
+}
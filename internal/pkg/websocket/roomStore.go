@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"uiassignment/internal/pkg/models"
+
+	"gorm.io/gorm"
+)
+
+// Topics of chat rooms are the room name with this prefix.
+const RoomTopicPrefix = "room:"
+
+// Names of chat rooms, short enough for their topic.
+var roomNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,58}$`)
+
+var ErrRoomNotFound = errors.New("chat room doesn't exist")
+
+// RoomStore persists chat rooms and their messages.
+type RoomStore interface {
+	// Reports whether the room exists.
+	RoomExists(ctx context.Context, room string) (bool, error)
+	// Stores a chat message sent to the room and applies the room's retention.
+	SaveMessage(ctx context.Context, room string, message Message) error
+}
+
+// IsValidRoomName reports whether the name can be used for a chat room.
+func IsValidRoomName(name string) bool {
+	return roomNamePattern.MatchString(name)
+}
+
+// RoomTopic is the topic messages of the room are published to.
+func RoomTopic(room string) string {
+	return RoomTopicPrefix + room
+}
+
+// Room of the topic, empty if it's no room topic.
+func roomOf(topic string) string {
+	if !strings.HasPrefix(topic, RoomTopicPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(topic, RoomTopicPrefix)
+}
+
+// DBRoomStore keeps chat rooms in the chat_rooms and chat_messages tables.
+type DBRoomStore struct {
+	db *gorm.DB
+}
+
+func NewRoomStore(db *gorm.DB) *DBRoomStore {
+	return &DBRoomStore{db: db}
+}
+
+func (s *DBRoomStore) RoomExists(ctx context.Context, room string) (bool, error) {
+	var count int64
+	result := s.db.WithContext(ctx).Model(&models.ChatRooms{}).Where(&models.ChatRooms{Name: room}).Count(&count)
+	return count > 0, result.Error
+}
+
+func (s *DBRoomStore) SaveMessage(ctx context.Context, room string, message Message) error {
+	var payload ChatPayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var chatRoom models.ChatRooms
+		if result := tx.Where(&models.ChatRooms{Name: room}).First(&chatRoom); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrRoomNotFound
+			}
+			return result.Error
+		}
+
+		chatMessage := models.ChatMessages{
+			ID:        message.ID,
+			Room:      room,
+			Acct:      message.From,
+			Text:      payload.Text,
+			CreatedAt: message.TS,
+		}
+		if result := tx.Create(&chatMessage); result.Error != nil {
+			return result.Error
+		}
+
+		// Apply the retention of the room
+		if retainedSince := chatRoom.RetainedSince(time.Now()); !retainedSince.IsZero() {
+			if result := tx.Where("room = ? AND created_at < ?", room, retainedSince).
+				Delete(&models.ChatMessages{}); result.Error != nil {
+				return result.Error
+			}
+		}
+		if chatRoom.MaxMessages != nil {
+			latest := tx.Model(&models.ChatMessages{}).Select("id").Where("room = ?", room).
+				Order("created_at desc, id desc").Limit(*chatRoom.MaxMessages)
+			if result := tx.Where("room = ? AND id NOT IN (?)", room, latest).
+				Delete(&models.ChatMessages{}); result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+}